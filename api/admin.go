@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+type Checker interface {
+	Check(ctx context.Context, fix bool) (*models.ConsistencyReport, error)
+}
+
+type adminHandler struct {
+	checker Checker
+	logger  *logging.Logger
+}
+
+func NewAdminHandler(checker Checker, logger *logging.Logger) *adminHandler {
+	return &adminHandler{
+		checker: checker,
+		logger:  logger,
+	}
+}
+
+func (h *adminHandler) Register(router chi.Router) {
+	router.Get("/admin/consistency", h.Check)
+	router.Post("/admin/consistency", h.Check)
+}
+
+// GET только проверяет, POST с ?fix=true исправляет найденное
+func (h *adminHandler) Check(w http.ResponseWriter, r *http.Request) {
+	fix := r.Method == http.MethodPost && r.URL.Query().Get("fix") == "true"
+
+	report, err := h.checker.Check(r.Context(), fix)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	content, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	h.logger.HandlerLog(r, http.StatusOK, "Consistency check finished")
+}
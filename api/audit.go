@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActorHeader - кто выполняет запрос по словам клиента, без заголовка действие записывается
+// на anonymous. При mTLS исполнитель - subject сертификата, заголовок только сохраняется
+const ActorHeader = "X-Actor"
+
+type actorKey struct{}
+
+// Actor кладет исполнителя запроса в контекст
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithActor(r.Context(), r.Header.Get(ActorHeader))))
+	})
+}
+
+// WithActor кладет в контекст исполнителя, которого назвал клиент
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// auditActor заполняет исполнителя записи: subject проверенного клиентского сертификата,
+// а без него - исполнителя из запроса или anonymous
+func auditActor(ctx context.Context, e *models.AuditEntry) {
+	claimed, _ := ctx.Value(actorKey{}).(string)
+	if subject, ok := ClientSubject(ctx); ok {
+		e.Actor = subject.String()
+		e.ActorVerified = true
+		e.ClaimedActor = claimed
+		return
+	}
+	e.Actor = claimed
+	if e.Actor == "" {
+		e.Actor = "anonymous"
+	}
+}
+
+type AuditLog interface {
+	Append(ctx context.Context, e *models.AuditEntry) error
+	Find(ctx context.Context, f models.AuditFilter) ([]*models.AuditEntry, error)
+}
+
+// auditRepository записывает в журнал успешные Create, MakeFriends, Unfriend, Delete и UpdateAge,
+// остальные методы передаются репозиторию без изменений
+type auditRepository struct {
+	Repository
+	log    AuditLog
+	logger *logging.Logger
+}
+
+func NewAuditRepository(repository Repository, log AuditLog, logger *logging.Logger) *auditRepository {
+	return &auditRepository{
+		Repository: repository,
+		log:        log,
+		logger:     logger,
+	}
+}
+
+func (a *auditRepository) Create(ctx context.Context, user *models.UserModel) error {
+	err := a.Repository.Create(ctx, user)
+	if err != nil {
+		return err
+	}
+	a.record(ctx, models.AuditCreate, []string{user.ID}, nil, []*models.UserModel{user.Copy()})
+	return nil
+}
+
+func (a *auditRepository) MakeFriends(ctx context.Context, sourceId, targetId string) (string, error) {
+	ids := []string{sourceId, targetId}
+	before := a.snapshot(ctx, ids)
+	text, err := a.Repository.MakeFriends(ctx, sourceId, targetId)
+	if err != nil {
+		return "", err
+	}
+	a.record(ctx, models.AuditMakeFriends, ids, before, a.snapshot(ctx, ids))
+	return text, nil
+}
+
+func (a *auditRepository) Unfriend(ctx context.Context, id, friendID string) (string, error) {
+	ids := []string{id, friendID}
+	before := a.snapshot(ctx, ids)
+	text, err := a.Repository.Unfriend(ctx, id, friendID)
+	if err != nil {
+		return "", err
+	}
+	a.record(ctx, models.AuditUnfriend, ids, before, a.snapshot(ctx, ids))
+	return text, nil
+}
+
+func (a *auditRepository) Delete(ctx context.Context, id string) (string, error) {
+	ids := []string{id}
+	before := a.snapshot(ctx, ids)
+	text, err := a.Repository.Delete(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	a.record(ctx, models.AuditDelete, ids, before, nil)
+	return text, nil
+}
+
+func (a *auditRepository) UpdateAge(ctx context.Context, id, age string) error {
+	ids := []string{id}
+	before := a.snapshot(ctx, ids)
+	if err := a.Repository.UpdateAge(ctx, id, age); err != nil {
+		return err
+	}
+	a.record(ctx, models.AuditUpdateAge, ids, before, a.snapshot(ctx, ids))
+	return nil
+}
+
+// snapshot - состояние пользователей, отсутствующие пропускаются
+func (a *auditRepository) snapshot(ctx context.Context, ids []string) []*models.UserModel {
+	users := make([]*models.UserModel, 0, len(ids))
+	for _, id := range ids {
+		if u, err := a.Repository.FindUser(ctx, id); err == nil {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// record не возвращает ошибку: операция уже выполнена, сбой журнала только логируется
+func (a *auditRepository) record(ctx context.Context, action string, targets []string, before, after []*models.UserModel) {
+	e := &models.AuditEntry{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Targets:   targets,
+		Before:    before,
+		After:     after,
+		RequestID: middleware.GetReqID(ctx),
+	}
+	auditActor(ctx, e)
+	if err := a.log.Append(ctx, e); err != nil {
+		a.logger.Err(err).Str("action", action).Strs("targets", targets).Msg("can't write audit entry")
+	}
+}
+
+type auditHandler struct {
+	log    AuditLog
+	logger *logging.Logger
+}
+
+func NewAuditHandler(log AuditLog, logger *logging.Logger) *auditHandler {
+	return &auditHandler{
+		log:    log,
+		logger: logger,
+	}
+}
+
+func (h *auditHandler) Register(router chi.Router) {
+	router.Get("/audit", h.Find)
+}
+
+const defaultAuditLimit = 100
+
+// Find - записи по пользователю (?user=) за период (?from=, ?to= в RFC3339), новые первыми
+func (h *auditHandler) Find(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	entries, err := h.log.Find(r.Context(), f)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	writeResponse(w, r, h.logger, http.StatusOK, auditEntries(entries), "Audit entries found")
+}
+
+// auditEntries - в CSV без снимков до и после изменения
+type auditEntries []*models.AuditEntry
+
+func (e auditEntries) CSVHeader() []string {
+	return []string{"time", "actor", "actor_verified", "claimed_actor", "action", "targets", "request_id"}
+}
+
+func (e auditEntries) CSVRows() [][]string {
+	rows := make([][]string, 0, len(e))
+	for _, entry := range e {
+		rows = append(rows, []string{
+			entry.Time.Format(time.RFC3339Nano), entry.Actor, strconv.FormatBool(entry.ActorVerified), entry.ClaimedActor, entry.Action,
+			strings.Join(entry.Targets, ";"), entry.RequestID,
+		})
+	}
+	return rows
+}
+
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	f := models.AuditFilter{UserID: query.Get("user"), Limit: defaultAuditLimit}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("invalid from, expected RFC3339")
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("invalid to, expected RFC3339")
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return f, errors.New("invalid limit")
+		}
+	}
+	return f, nil
+}
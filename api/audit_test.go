@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"github.com/ast3am/educationProject/api/mocks"
+	"github.com/ast3am/educationProject/internal/audit"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditRepository(t *testing.T) {
+	log := logging.GetLogger()
+	store, err := audit.NewFileStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	repository := mocks.NewRepository(t)
+	repository.
+		On("FindUser", mock.Anything, "1").Return(&models.UserModel{ID: "1", Name: "John", Age: "24"}, nil).Once().
+		On("UpdateAge", mock.Anything, "1", "25").Return(nil).
+		On("FindUser", mock.Anything, "1").Return(&models.UserModel{ID: "1", Name: "John", Age: "25"}, nil).Once().
+		On("UpdateAge", mock.Anything, "2", "30").Return(nil).
+		On("FindUser", mock.Anything, "2").Return(nil, errors.New("пользователь с 2 не найден"))
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID, Actor)
+	NewHandler(NewAuditRepository(repository, store, log), log).Register(router)
+	NewAuditHandler(store, log).Register(router)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("PATCH", "/users/1", bytes.NewBufferString(`{"age":"25"}`)),
+		httptest.NewRequest("PATCH", "/users/2", bytes.NewBufferString(`{"age":"30"}`)),
+	} {
+		req.Header.Set(ActorHeader, "admin")
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/audit?user=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	var entries []*models.AuditEntry
+	if err = json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("wrong number of entries: got %d want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Actor != "admin" || e.Action != models.AuditUpdateAge || e.RequestID == "" {
+		t.Errorf("wrong entry: got %+v", e)
+	}
+	if len(e.Before) != 1 || e.Before[0].Age != "24" || len(e.After) != 1 || e.After[0].Age != "25" {
+		t.Errorf("wrong snapshots: got %+v, %+v", e.Before, e.After)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/audit?from=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAuditActor(t *testing.T) {
+	subject := pkix.Name{CommonName: "billing", Organization: []string{"educationProject"}}
+	verified := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: subject}},
+		VerifiedChains:   [][]*x509.Certificate{{{Subject: subject}}},
+	}
+	testTable := []struct {
+		name     string
+		state    *tls.ConnectionState
+		header   string
+		expected models.AuditEntry
+	}{
+		{"anonymous", nil, "", models.AuditEntry{Actor: "anonymous"}},
+		{"header", nil, "admin", models.AuditEntry{Actor: "admin"}},
+		{"certificate", verified, "", models.AuditEntry{Actor: "CN=billing,O=educationProject", ActorVerified: true}},
+		// заголовок не подменяет исполнителя из сертификата
+		{"certificate and header", verified, "admin",
+			models.AuditEntry{Actor: "CN=billing,O=educationProject", ActorVerified: true, ClaimedActor: "admin"}},
+	}
+
+	var got models.AuditEntry
+	handler := Actor(ClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = models.AuditEntry{}
+		auditActor(r.Context(), &got)
+	})))
+	for _, test := range testTable {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = test.state
+		if test.header != "" {
+			req.Header.Set(ActorHeader, test.header)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got %+v want %+v", test.name, got, test.expected)
+		}
+	}
+}
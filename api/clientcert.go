@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"net/http"
+)
+
+type clientSubjectKey struct{}
+
+// ClientCert кладет в контекст subject клиентского сертификата, если сервер
+// проверил его по CA. Непроверенные сертификаты не учитываются
+func ClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			r = r.WithContext(WithClientSubject(r.Context(), *r.TLS))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithClientSubject кладет в контекст subject клиентского сертификата из
+// состояния TLS-соединения, если сертификат проверен. Нужен и для gRPC
+func WithClientSubject(ctx context.Context, state tls.ConnectionState) context.Context {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, clientSubjectKey{}, state.VerifiedChains[0][0].Subject)
+}
+
+// ClientSubject - subject проверенного клиентского сертификата, ok=false без mTLS
+func ClientSubject(ctx context.Context) (pkix.Name, bool) {
+	subject, ok := ctx.Value(clientSubjectKey{}).(pkix.Name)
+	return subject, ok
+}
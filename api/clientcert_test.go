@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCert(t *testing.T) {
+	subject := pkix.Name{CommonName: "billing", Organization: []string{"educationProject"}}
+	var got string
+	handler := ClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ""
+		if s, ok := ClientSubject(r.Context()); ok {
+			got = s.String()
+		}
+	}))
+
+	testTable := []struct {
+		name     string
+		state    *tls.ConnectionState
+		expected string
+	}{
+		{"plain http", nil, ""},
+		{"no client certificate", &tls.ConnectionState{}, ""},
+		{"unverified certificate", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: subject}},
+		}, ""},
+		{"verified certificate", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: subject}},
+			VerifiedChains:   [][]*x509.Certificate{{{Subject: subject}, {Subject: pkix.Name{CommonName: "CA"}}}},
+		}, "CN=billing,O=educationProject"},
+	}
+
+	for _, test := range testTable {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = test.state
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != test.expected {
+			t.Errorf("%s: got subject %q want %q", test.name, got, test.expected)
+		}
+	}
+}
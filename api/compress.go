@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressMinSize - ответы меньше этого размера не сжимаются
+const DefaultCompressMinSize = 1024
+
+// compressible - типы ответов, которые имеет смысл сжимать
+var compressible = map[string]bool{
+	"application/json":    true,
+	"application/msgpack": true,
+	"application/graphql": true,
+	"text/csv":            true,
+	"text/html":           true,
+	"text/plain":          true,
+}
+
+// Compress сжимает ответы в br или gzip по Accept-Encoding. Поток событий
+// и WebSocket проходят без изменений, чтобы не задерживать сообщения
+func Compress(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := acceptEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptEncoding выбирает br или gzip с наибольшим q, при равном q - br.
+// * относится только к кодировкам, не названным явно
+func acceptEncoding(header string) string {
+	qs := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qs[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		q, ok := qs[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter копит начало ответа, пока не станет ясно, стоит ли его сжимать
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	// started - заголовки отправлены, дальше пишем в enc или напрямую
+	started bool
+	enc     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 || w.started {
+		return
+	}
+	w.status = status
+	// ответы без тела и поток событий отправляются сразу
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		!w.compressible() {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.started {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// compressible - тип ответа сжимается и ответ еще не сжат обработчиком
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && compressible[mediaType]
+}
+
+// start отправляет заголовки и накопленное начало ответа
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if compress && w.compressible() {
+		header := w.Header()
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if w.encoding == "br" {
+			w.enc = brotli.NewWriter(w.ResponseWriter)
+		} else {
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush сжимает накопленное независимо от размера и отдает клиенту
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(true)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response does not support hijacking")
+}
+
+// Close дописывает короткий ответ без сжатия и закрывает сжатие
+func (w *compressWriter) Close() error {
+	if !w.started {
+		if w.status == 0 && len(w.buf) == 0 {
+			// обработчик ничего не записал
+			return nil
+		}
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
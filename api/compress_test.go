@@ -0,0 +1,118 @@
+package api
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	testTable := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, *", "gzip"},
+		{"*;q=0", ""},
+		{"GZIP;q=0.1", "gzip"},
+	}
+	for _, test := range testTable {
+		if got := acceptEncoding(test.header); got != test.expected {
+			t.Errorf("%q: got %q want %q", test.header, got, test.expected)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := `{"name":"` + strings.Repeat("a", 2048) + `"}`
+	handler := Compress(DefaultCompressMinSize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			// запись частями: сжатие начинается после minSize
+			w.Write([]byte(large[:100]))
+			w.Write([]byte(large[100:]))
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(strings.Repeat("data: x\n\n", 200)))
+			w.(http.Flusher).Flush()
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	do := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for encoding, decode := range decoders {
+		w := do("/large", encoding)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: wrong status code: got %v want %v", encoding, w.Code, http.StatusOK)
+		}
+		checkHeaders(t, encoding, w, map[string]string{"Content-Encoding": encoding, "Vary": "Accept-Encoding"})
+		if w.Body.Len() >= len(large) {
+			t.Errorf("%s: body is not compressed: %d bytes", encoding, w.Body.Len())
+		}
+		reader, err := decode(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if string(body) != large {
+			t.Errorf("%s: decoded body differs from the original", encoding)
+		}
+	}
+
+	testTable := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		code           int
+		body           string
+	}{
+		{"no accept-encoding", "/large", "", http.StatusOK, large},
+		{"below min size", "/small", "gzip", http.StatusOK, `{}`},
+		{"event stream", "/events", "gzip", http.StatusOK, strings.Repeat("data: x\n\n", 200)},
+		{"no content", "/empty", "br", http.StatusNoContent, ""},
+	}
+	for _, test := range testTable {
+		w := do(test.path, test.acceptEncoding)
+		if w.Code != test.code {
+			t.Errorf("%s: wrong status code: got %v want %v", test.name, w.Code, test.code)
+		}
+		checkHeaders(t, test.name, w, map[string]string{"Content-Encoding": ""})
+		if w.Body.String() != test.body {
+			t.Errorf("%s: got body of %d bytes want %d", test.name, w.Body.Len(), len(test.body))
+		}
+	}
+	// поток событий не буферизуется
+	if w := do("/events", "gzip"); !w.Flushed {
+		t.Errorf("event stream is not flushed")
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions - настройки CORS, пустой AllowedOrigins отключает CORS
+type CORSOptions struct {
+	// источники вида https://app.example.com, https://*.example.com или *
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// сколько браузер хранит ответ на preflight, 0 - не передавать
+	MaxAge time.Duration
+}
+
+type cors struct {
+	opts    CORSOptions
+	methods map[string]bool
+	headers map[string]bool
+}
+
+// CORS отвечает на preflight-запросы и добавляет заголовки Access-Control-* к
+// ответам для разрешенных источников. Ставится до маршрутизатора, иначе chi
+// ответит на OPTIONS 405
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	c := &cors{opts: opts, methods: make(map[string]bool), headers: make(map[string]bool)}
+	for _, m := range opts.AllowedMethods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range opts.AllowedHeaders {
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(c.opts.AllowedOrigins) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+				c.preflight(w, r, origin)
+				return
+			}
+			if origin != "" && c.allowedOrigin(origin) {
+				c.setOrigin(w, origin)
+				if len(c.opts.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.opts.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// preflight проверяет метод и заголовки будущего запроса, запрос дальше не идет
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	var err error
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := requestedHeaders(r.Header.Get("Access-Control-Request-Headers"))
+	switch {
+	case !c.allowedOrigin(origin):
+		err = errors.New("origin " + origin + " is not allowed")
+	case !c.methods[method]:
+		err = errors.New("method " + method + " is not allowed")
+	default:
+		for _, h := range requested {
+			if !c.headers[h] {
+				err = errors.New("header " + h + " is not allowed")
+				break
+			}
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	c.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.opts.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if c.opts.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.opts.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setOrigin - с учетными данными браузер не принимает *, поэтому источник повторяется
+func (c *cors) setOrigin(w http.ResponseWriter, origin string) {
+	if c.opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	for _, allowed := range c.opts.AllowedOrigins {
+		if allowed == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
+
+// CheckOrigin - проверка источника WebSocket-подключения для websocket.Upgrader по тем же
+// источникам, что и у CORS. Подключения без Origin (не из браузера) и со страниц самого
+// сервиса разрешены всегда, как в websocket.Upgrader без CheckOrigin
+func CheckOrigin(opts CORSOptions) func(r *http.Request) bool {
+	c := &cors{opts: opts}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		return c.allowedOrigin(origin)
+	}
+}
+
+func (c *cors) allowedOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.opts.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// https://*.example.com - любой поддомен, но не сам example.com
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(prefix)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func requestedHeaders(header string) []string {
+	var headers []string
+	for _, h := range strings.Split(header, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	return headers
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCORSRouter(opts CORSOptions) http.Handler {
+	router := chi.NewRouter()
+	router.Get("/api/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.Delete("/api/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return CORS(opts)(router)
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	handler := newCORSRouter(CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods: []string{"GET", "POST", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "X-Actor"},
+		MaxAge:         10 * time.Minute,
+	})
+
+	testTable := []struct {
+		name               string
+		origin             string
+		method             string
+		headers            string
+		expectedStatusCode int
+		expectedOrigin     string
+		expectedHeaders    string
+		expectedBody       string
+	}{
+		{"allowed", "https://app.example.com", "DELETE", "", http.StatusNoContent, "https://app.example.com", "", ""},
+		{"allowed headers", "https://app.example.com", "POST", "content-type, x-actor", http.StatusNoContent,
+			"https://app.example.com", "Content-Type, X-Actor", ""},
+		{"subdomain", "https://admin.example.org", "GET", "", http.StatusNoContent, "https://admin.example.org", "", ""},
+		{"bare domain for wildcard", "https://example.org", "GET", "", http.StatusForbidden, "", "",
+			"origin https://example.org is not allowed"},
+		{"unknown origin", "https://evil.com", "GET", "", http.StatusForbidden, "", "", "origin https://evil.com is not allowed"},
+		{"method", "https://app.example.com", "PUT", "", http.StatusForbidden, "", "", "method PUT is not allowed"},
+		{"header", "https://app.example.com", "POST", "X-API-Key", http.StatusForbidden, "", "", "header X-Api-Key is not allowed"},
+	}
+
+	for _, test := range testTable {
+		req := httptest.NewRequest("OPTIONS", "/api/v1/users/1", nil)
+		req.Header.Set("Origin", test.origin)
+		req.Header.Set("Access-Control-Request-Method", test.method)
+		if test.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", test.headers)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", test.name, w.Code, test.expectedStatusCode)
+		}
+		if w.Body.String() != test.expectedBody {
+			t.Errorf("%s: handler returned unexpected body: got %q want %q", test.name, w.Body.String(), test.expectedBody)
+		}
+		expected := map[string]string{
+			"Access-Control-Allow-Origin":  test.expectedOrigin,
+			"Access-Control-Allow-Headers": test.expectedHeaders,
+			"Vary":                         "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+		}
+		if test.expectedStatusCode == http.StatusNoContent {
+			expected["Access-Control-Allow-Methods"] = "GET, POST, DELETE"
+			expected["Access-Control-Max-Age"] = "600"
+		}
+		checkHeaders(t, test.name, w, expected)
+	}
+}
+
+func TestCORS_Request(t *testing.T) {
+	do := func(handler http.Handler, method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/users/1", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	handler := newCORSRouter(CORSOptions{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+		ExposedHeaders: []string{"X-Next-Cursor", "Retry-After"},
+	})
+	w := do(handler, "GET", "https://app.example.com")
+	if w.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	checkHeaders(t, "any origin", w, map[string]string{
+		"Access-Control-Allow-Origin":      "*",
+		"Access-Control-Expose-Headers":    "X-Next-Cursor, Retry-After",
+		"Access-Control-Allow-Credentials": "",
+	})
+
+	// без Access-Control-Request-Method это обычный OPTIONS, а не preflight
+	w = do(handler, "OPTIONS", "https://app.example.com")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w = do(handler, "GET", "")
+	checkHeaders(t, "no origin", w, map[string]string{"Access-Control-Allow-Origin": ""})
+
+	credentials := newCORSRouter(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET"},
+		AllowCredentials: true,
+	})
+	w = do(credentials, "GET", "https://app.example.com")
+	checkHeaders(t, "credentials", w, map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+	})
+
+	restricted := newCORSRouter(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}})
+	w = do(restricted, "GET", "https://evil.com")
+	if w.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	checkHeaders(t, "unknown origin", w, map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"})
+
+	disabled := newCORSRouter(CORSOptions{})
+	w = do(disabled, "GET", "https://app.example.com")
+	checkHeaders(t, "disabled", w, map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""})
+}
+
+func TestCheckOrigin(t *testing.T) {
+	testTable := []struct {
+		name     string
+		allowed  []string
+		origin   string
+		expected bool
+	}{
+		{"no origin", nil, "", true},
+		{"same host", nil, "http://example.com", true},
+		{"cross origin without cors", nil, "https://app.example.com", false},
+		{"allowed origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"any origin", []string{"*"}, "https://evil.com", true},
+		{"unknown origin", []string{"https://app.example.com"}, "https://evil.com", false},
+	}
+	for _, test := range testTable {
+		req := httptest.NewRequest("GET", "http://example.com/events/ws", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if got := CheckOrigin(CORSOptions{AllowedOrigins: test.allowed})(req); got != test.expected {
+			t.Errorf("%s: got %v want %v", test.name, got, test.expected)
+		}
+	}
+}
+
+// checkHeaders сравнивает заголовки ответа, пустое значение - заголовка нет
+func checkHeaders(t *testing.T, name string, w *httptest.ResponseRecorder, expected map[string]string) {
+	t.Helper()
+	for header, want := range expected {
+		if got := strings.Join(w.Header().Values(header), ", "); got != want {
+			t.Errorf("%s: wrong %s: got %q want %q", name, header, got, want)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	proxies, err := ParseNetworks([]string{"10.0.0.0/8", "192.0.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := SecurityHeaders(SecurityOptions{
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubdomains: true,
+		FrameOptions:          "DENY",
+		TrustedProxies:        proxies,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testTable := []struct {
+		name       string
+		url        string
+		remoteAddr string
+		proto      string
+		hsts       string
+	}{
+		{"plain http", "/", "203.0.113.1:1000", "", ""},
+		{"tls", "https://localhost/", "203.0.113.1:1000", "", "max-age=3600; includeSubDomains"},
+		{"trusted proxy network", "/", "10.1.2.3:1000", "https", "max-age=3600; includeSubDomains"},
+		{"trusted proxy address", "/", "192.0.2.10:1000", "https", "max-age=3600; includeSubDomains"},
+		{"untrusted client", "/", "192.0.2.11:1000", "https", ""},
+	}
+	for _, test := range testTable {
+		req := httptest.NewRequest("GET", test.url, nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		checkHeaders(t, test.name, w, map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Strict-Transport-Security": test.hsts,
+		})
+	}
+
+	if _, err = ParseNetworks([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("invalid network is accepted")
+	}
+	if _, err = ParseNetworks([]string{"proxy.local"}); err == nil {
+		t.Errorf("invalid address is accepted")
+	}
+}
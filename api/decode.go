@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodySize - предел тела запроса, если BodyLimit не подключен
+const DefaultMaxBodySize = 1 << 20
+
+type bodyLimitKey struct{}
+
+// BodyLimit задает предел тела запроса для readBody и decodeJSON
+func BodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, limit)))
+		})
+	}
+}
+
+func bodyLimit(ctx context.Context) int64 {
+	if limit, ok := ctx.Value(bodyLimitKey{}).(int64); ok {
+		return limit
+	}
+	return DefaultMaxBodySize
+}
+
+// requestError - ошибка в теле запроса с кодом ответа
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{status: http.StatusBadRequest, msg: "Unmarshal error \n" + fmt.Sprintf(format, args...)}
+}
+
+// readBody читает тело запроса не больше предела, больший запрос - 413
+func readBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	limit := bodyLimit(r.Context())
+	content, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, &requestError{status: http.StatusBadRequest, msg: "can't read request body: " + err.Error()}
+	}
+	if int64(len(content)) > limit {
+		return nil, &requestError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    "request body is larger than " + strconv.FormatInt(limit, 10) + " bytes",
+		}
+	}
+	return content, nil
+}
+
+// decodeJSON читает в v тело application/json. Неизвестные поля, данные после
+// объекта и значения не того типа отклоняются с именем поля в ошибке
+func decodeJSON(r *http.Request, v interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return &requestError{status: http.StatusUnsupportedMediaType, msg: "Content-Type must be application/json"}
+	}
+	content, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return badRequest("request body is required")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return badRequest("request body must contain a single JSON value")
+	}
+	return nil
+}
+
+// decodeError переводит ошибку encoding/json в понятное клиенту сообщение
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return badRequest("malformed JSON at position %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequest("malformed JSON: unexpected end of body")
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return badRequest("request body must be %s", jsonType(typeErr.Type))
+		}
+		return badRequest("field %q must be %s, got %s", typeErr.Field, jsonType(typeErr.Type), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// у encoding/json нет отдельного типа для этой ошибки
+		return badRequest("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return badRequest("%s", err.Error())
+}
+
+// jsonType - название типа JSON для поля Go
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return "number"
+}
+
+// writeRequestError отвечает на ошибку readBody или decodeJSON
+func writeRequestError(w http.ResponseWriter, r *http.Request, logger *logging.Logger, err error) {
+	status := http.StatusBadRequest
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		status = reqErr.status
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(err.Error()))
+	logger.HandlerErrorLog(r, status, "", err)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"github.com/ast3am/educationProject/pkg/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type friendship struct {
+		Label string `json:"label"`
+	}
+	type request struct {
+		TargetID   string      `json:"target_id"`
+		Closeness  *float64    `json:"closeness"`
+		Friendship *friendship `json:"friendship"`
+	}
+
+	testTable := []struct {
+		name               string
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"valid", "application/json", `{"target_id":"2"}`, http.StatusOK, ""},
+		{"charset", "application/json; charset=utf-8", `{"target_id":"2"} `, http.StatusOK, ""},
+		{"no content type", "", `{"target_id":"2"}`, http.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"form", "application/x-www-form-urlencoded", `target_id=2`, http.StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"too large", "application/json", `{"target_id":"` + strings.Repeat("1", 64) + `"}`, http.StatusRequestEntityTooLarge, "request body is larger than 64 bytes"},
+		{"empty", "application/json", ` `, http.StatusBadRequest, "Unmarshal error \nrequest body is required"},
+		{"unknown field", "application/json", `{"target_id":"2","source_id":"1"}`, http.StatusBadRequest, "Unmarshal error \nunknown field \"source_id\""},
+		{"wrong type", "application/json", `{"closeness":"high"}`, http.StatusBadRequest, "Unmarshal error \nfield \"closeness\" must be number, got string"},
+		{"nested field", "application/json", `{"friendship":{"label":1}}`, http.StatusBadRequest, "Unmarshal error \nfield \"friendship.label\" must be string, got number"},
+		{"not an object", "application/json", `["2"]`, http.StatusBadRequest, "Unmarshal error \nrequest body must be object"},
+		{"trailing data", "application/json", `{"target_id":"2"}{"target_id":"3"}`, http.StatusBadRequest, "Unmarshal error \nrequest body must contain a single JSON value"},
+		{"malformed", "application/json", `{"target_id":}`, http.StatusBadRequest, "Unmarshal error \nmalformed JSON at position 14"},
+		{"truncated", "application/json", `{"target_id":"2"`, http.StatusBadRequest, "Unmarshal error \nmalformed JSON: unexpected end of body"},
+	}
+
+	log := logging.GetLogger()
+	handler := BodyLimit(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := decodeJSON(r, &request{}); err != nil {
+			writeRequestError(w, r, log, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, test := range testTable {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.name, w.Code, test.expectedStatusCode)
+		}
+		if w.Body.String() != test.expectedBody {
+			t.Errorf("%s: handler returned unexpected body: got %q want %q",
+				test.name, w.Body.String(), test.expectedBody)
+		}
+	}
+}
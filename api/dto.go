@@ -0,0 +1,160 @@
+package api
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserResponse - представление пользователя в ответах API.
+// Friends заполняется только при ?expand=friends
+type UserResponse struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Age       string          `json:"age"`
+	FriendIDs []string        `json:"friend_ids"`
+	Friends   []*UserResponse `json:"friends,omitempty"`
+	// метаданные дружбы, только в списке друзей
+	Friendship *FriendshipResponse `json:"friendship,omitempty"`
+}
+
+type FriendshipResponse struct {
+	Since     *time.Time `json:"since,omitempty"`
+	Initiator string     `json:"initiator,omitempty"`
+	Label     string     `json:"label,omitempty"`
+	Closeness *float64   `json:"closeness,omitempty"`
+}
+
+// NewFriendshipResponse не показывает дату у дружбы, созданной до появления метаданных
+func NewFriendshipResponse(f *models.Friendship) *FriendshipResponse {
+	resp := &FriendshipResponse{
+		Initiator: f.Initiator,
+		Label:     f.Label,
+		Closeness: f.Closeness,
+	}
+	if !f.CreatedAt.IsZero() {
+		since := f.CreatedAt
+		resp.Since = &since
+	}
+	return resp
+}
+
+func NewUserResponse(u *models.UserModel) *UserResponse {
+	friendIDs := u.FriendIDs
+	if friendIDs == nil {
+		friendIDs = []string{}
+	}
+	return &UserResponse{
+		ID:        u.ID,
+		Name:      u.Name,
+		Age:       u.Age,
+		FriendIDs: friendIDs,
+	}
+}
+
+func (u *UserResponse) Expand(friends []*models.UserModel) {
+	u.Friends = make([]*UserResponse, 0, len(friends))
+	for _, f := range friends {
+		u.Friends = append(u.Friends, NewUserResponse(f))
+	}
+}
+
+func (u *UserResponse) Select(fields []string) map[string]interface{} {
+	all := map[string]interface{}{
+		"id":         u.ID,
+		"name":       u.Name,
+		"age":        u.Age,
+		"friend_ids": u.FriendIDs,
+		"friendship": u.Friendship,
+	}
+	res := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		res[f] = all[f]
+	}
+	return res
+}
+
+// userCSVFields - колонки CSV по умолчанию, friendship раскрывается в несколько колонок
+var userCSVFields = []string{"id", "name", "age", "friend_ids"}
+
+var friendshipCSVColumns = []string{"friendship_since", "friendship_initiator", "friendship_label", "friendship_closeness"}
+
+// csvHeader - заголовок CSV для выбранных полей
+func csvHeader(fields []string) []string {
+	header := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f == "friendship" {
+			header = append(header, friendshipCSVColumns...)
+			continue
+		}
+		header = append(header, f)
+	}
+	return header
+}
+
+// CSVRow - значения полей пользователя для строки CSV, список друзей через ;
+func (u *UserResponse) CSVRow(fields []string) []string {
+	row := make([]string, 0, len(fields))
+	for _, f := range fields {
+		switch f {
+		case "id":
+			row = append(row, u.ID)
+		case "name":
+			row = append(row, u.Name)
+		case "age":
+			row = append(row, u.Age)
+		case "friend_ids":
+			row = append(row, strings.Join(u.FriendIDs, ";"))
+		case "friendship":
+			row = append(row, u.Friendship.csvRow()...)
+		}
+	}
+	return row
+}
+
+func (f *FriendshipResponse) csvRow() []string {
+	row := make([]string, len(friendshipCSVColumns))
+	if f == nil {
+		return row
+	}
+	if f.Since != nil {
+		row[0] = f.Since.Format(time.RFC3339)
+	}
+	row[1], row[2] = f.Initiator, f.Label
+	if f.Closeness != nil {
+		row[3] = strconv.FormatFloat(*f.Closeness, 'f', -1, 64)
+	}
+	return row
+}
+
+type SuggestionResponse struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Age           string `json:"age"`
+	MutualFriends int    `json:"mutual_friends"`
+}
+
+func NewSuggestionResponse(s *models.Suggestion) *SuggestionResponse {
+	return &SuggestionResponse{
+		ID:            s.User.ID,
+		Name:          s.User.Name,
+		Age:           s.User.Age,
+		MutualFriends: s.Mutual,
+	}
+}
+
+// SuggestionsResponse - список рекомендаций, в CSV по строке на пользователя
+type SuggestionsResponse []*SuggestionResponse
+
+func (s SuggestionsResponse) CSVHeader() []string {
+	return []string{"id", "name", "age", "mutual_friends"}
+}
+
+func (s SuggestionsResponse) CSVRows() [][]string {
+	rows := make([][]string, 0, len(s))
+	for _, v := range s {
+		rows = append(rows, []string{v.ID, v.Name, v.Age, strconv.Itoa(v.MutualFriends)})
+	}
+	return rows
+}
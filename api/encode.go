@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Table - ответ со списком, который можно отдать в CSV
+type Table interface {
+	CSVHeader() []string
+	CSVRows() [][]string
+}
+
+// Encoder записывает ответ в одном из форматов
+type Encoder interface {
+	ContentType() string
+	// Supports - можно ли записать v в этом формате
+	Supports(v interface{}) bool
+	Encode(w io.Writer, v interface{}) error
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Supports(v interface{}) bool { return true }
+
+func (jsonEncoder) Encode(w io.Writer, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// msgpackEncoder берет имена полей из тегов json, чтобы ответы совпадали с JSON
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string { return "application/msgpack" }
+
+func (msgpackEncoder) Supports(v interface{}) bool { return true }
+
+func (msgpackEncoder) Encode(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	return enc.Encode(v)
+}
+
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string { return "text/csv; charset=utf-8" }
+
+func (csvEncoder) Supports(v interface{}) bool {
+	_, ok := v.(Table)
+	return ok
+}
+
+func (csvEncoder) Encode(w io.Writer, v interface{}) error {
+	table, ok := v.(Table)
+	if !ok {
+		return errors.New("response is not a table")
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(table.CSVHeader()); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(table.CSVRows()); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// encoders - поддерживаемые форматы, при равном q выигрывает первый
+var encoders = []Encoder{jsonEncoder{}, msgpackEncoder{}, csvEncoder{}}
+
+// mediaAliases - другие названия MessagePack
+var mediaAliases = map[string]string{
+	"application/x-msgpack":   "application/msgpack",
+	"application/vnd.msgpack": "application/msgpack",
+}
+
+// acceptRange - один вариант из заголовка Accept
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if alias, ok := mediaAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// quality - q для типа с учетом */* и type/*, более точный вариант главнее
+func quality(ranges []acceptRange, mediaType string) float64 {
+	best, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mediaType == mediaType:
+			s = 2
+		case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")):
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			best, specificity = r.q, s
+		}
+	}
+	return best
+}
+
+// negotiate выбирает формат ответа по Accept, без заголовка - JSON.
+// nil - ни один подходящий формат не умеет записать v
+func negotiate(accept string, v interface{}) Encoder {
+	if strings.TrimSpace(accept) == "" {
+		return jsonEncoder{}
+	}
+	ranges := parseAccept(accept)
+	var best Encoder
+	bestQ := 0.0
+	for _, enc := range encoders {
+		if !enc.Supports(v) {
+			continue
+		}
+		mediaType, _, _ := mime.ParseMediaType(enc.ContentType())
+		if q := quality(ranges, mediaType); q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// writeResponse записывает v в формате из Accept, 406 - если формат не подходит
+func writeResponse(w http.ResponseWriter, r *http.Request, logger *logging.Logger, status int, v interface{}, msg string) {
+	w.Header().Add("Vary", "Accept")
+	enc := negotiate(r.Header.Get("Accept"), v)
+	if enc == nil {
+		supported := []string{"application/json", "application/msgpack"}
+		if _, ok := v.(Table); ok {
+			supported = append(supported, "text/csv")
+		}
+		err := errors.New("supported response types: " + strings.Join(supported, ", "))
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte(err.Error()))
+		logger.HandlerErrorLog(r, http.StatusNotAcceptable, "", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, v); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+	logger.HandlerLog(r, status, msg)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/vmihailenco/msgpack/v5"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	table := SuggestionsResponse{}
+	testTable := []struct {
+		name     string
+		accept   string
+		v        interface{}
+		expected string
+	}{
+		{"no header", "", table, "application/json"},
+		{"any", "*/*", table, "application/json"},
+		{"csv", "text/csv", table, "text/csv; charset=utf-8"},
+		{"text wildcard", "text/*", table, "text/csv; charset=utf-8"},
+		{"msgpack", "application/msgpack", table, "application/msgpack"},
+		{"msgpack alias", "application/x-msgpack", table, "application/msgpack"},
+		{"q order", "application/json;q=0.5, text/csv;q=0.9", table, "text/csv; charset=utf-8"},
+		{"specific wins over wildcard", "text/csv;q=0, */*", table, "application/json"},
+		{"csv not a table", "text/csv, application/msgpack;q=0.1", struct{}{}, "application/msgpack"},
+		{"nothing acceptable", "text/html", table, ""},
+		{"csv only for not a table", "text/csv", struct{}{}, ""},
+	}
+
+	for _, test := range testTable {
+		enc := negotiate(test.accept, test.v)
+		got := ""
+		if enc != nil {
+			got = enc.ContentType()
+		}
+		if got != test.expected {
+			t.Errorf("%s: got %q want %q", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	since := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	closeness := 0.5
+	page := &models.FriendsPage{
+		Friends: []*models.UserModel{
+			{ID: "2", Name: "Bob", Age: "30", FriendIDs: []string{"1", "3"}},
+			{ID: "3", Name: "Eve, Jr", Age: "20"},
+		},
+		Friendships: map[string]*models.Friendship{
+			"2": {CreatedAt: since, Initiator: "1", Label: "school", Closeness: &closeness},
+		},
+	}
+	log := logging.GetLogger()
+	do := func(accept string, v interface{}) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		writeResponse(w, req, log, http.StatusOK, v, "ok")
+		return w
+	}
+
+	w := do("text/csv", NewFriendsPageResponse(page, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("csv: wrong status code: got %v want %v", w.Code, http.StatusOK)
+	}
+	checkHeaders(t, "csv", w, map[string]string{"Content-Type": "text/csv; charset=utf-8", "Vary": "Accept"})
+	expected := "id,name,age,friend_ids,friendship_since,friendship_initiator,friendship_label,friendship_closeness\n" +
+		"2,Bob,30,1;3,2023-01-02T03:04:05Z,1,school,0.5\n" +
+		"3,\"Eve, Jr\",20,,,,,\n"
+	if w.Body.String() != expected {
+		t.Errorf("csv: got body %q want %q", w.Body.String(), expected)
+	}
+
+	w = do("text/csv", NewFriendsPageResponse(page, []string{"name", "id"}))
+	if expected = "name,id\nBob,2\n\"Eve, Jr\",3\n"; w.Body.String() != expected {
+		t.Errorf("csv fields: got body %q want %q", w.Body.String(), expected)
+	}
+
+	w = do("application/msgpack", NewFriendsPageResponse(page, []string{"id"}))
+	checkHeaders(t, "msgpack", w, map[string]string{"Content-Type": "application/msgpack"})
+	decoded := map[string]interface{}{}
+	if err := msgpack.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expectedMap := map[string]interface{}{"friends": []interface{}{
+		map[string]interface{}{"id": "2"},
+		map[string]interface{}{"id": "3"},
+	}}
+	if !reflect.DeepEqual(decoded, expectedMap) {
+		t.Errorf("msgpack: got %v want %v", decoded, expectedMap)
+	}
+
+	w = do("application/json", NewFriendsPageResponse(page, []string{"id"}))
+	if expected = `{"friends":[{"id":"2"},{"id":"3"}]}`; w.Body.String() != expected {
+		t.Errorf("json: got body %q want %q", w.Body.String(), expected)
+	}
+
+	w = do("text/csv", []*models.Webhook{})
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("not acceptable: wrong status code: got %v want %v", w.Code, http.StatusNotAcceptable)
+	}
+	if expected = "supported response types: application/json, application/msgpack"; w.Body.String() != expected {
+		t.Errorf("not acceptable: got body %q want %q", w.Body.String(), expected)
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type EventBus interface {
+	Publish(e *models.Event)
+	Subscribe(userID string, afterID uint64) (replay []*models.Event, events <-chan *models.Event, cancel func())
+}
+
+type eventsHandler struct {
+	bus       EventBus
+	heartbeat time.Duration
+	upgrader  websocket.Upgrader
+	logger    *logging.Logger
+}
+
+// NewEventsHandler - checkOrigin проверяет источник WebSocket-подключения (см. CheckOrigin),
+// nil разрешает только страницы с того же хоста
+func NewEventsHandler(bus EventBus, heartbeat time.Duration, checkOrigin func(r *http.Request) bool, logger *logging.Logger) *eventsHandler {
+	return &eventsHandler{
+		bus:       bus,
+		heartbeat: heartbeat,
+		upgrader:  websocket.Upgrader{CheckOrigin: checkOrigin},
+		logger:    logger,
+	}
+}
+
+func (h *eventsHandler) Register(router chi.Router) {
+	router.Get("/events", h.Stream)
+	router.Get("/events/ws", h.WebSocket)
+}
+
+// lastEventID - заголовок Last-Event-ID, браузер передает его при переподключении,
+// для WebSocket тот же ID передается параметром ?last_event_id=
+func lastEventID(r *http.Request) (uint64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid last event id")
+	}
+	return id, nil
+}
+
+// Stream - Server-Sent Events, ?user= оставляет только события пользователя
+func (h *eventsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	afterID, err := lastEventID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err = errors.New("streaming is not supported")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	replay, events, cancel := h.bus.Subscribe(r.URL.Query().Get("user"), afterID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	h.logger.HandlerLog(r, http.StatusOK, "Event stream opened")
+
+	for _, e := range replay {
+		if err = writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			// комментарий не виден клиенту, но не дает прокси закрыть соединение
+			if _, err = w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				// подписчик не успевал читать, клиент переподключится с Last-Event-ID
+				return
+			}
+			if err = writeSSE(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e *models.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// WebSocket отправляет события JSON-сообщениями, heartbeat - ping-кадры
+func (h *eventsHandler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	afterID, err := lastEventID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+	// подписка до ответа клиенту, чтобы не потерять события между ними
+	replay, events, cancel := h.bus.Subscribe(r.URL.Query().Get("user"), afterID)
+	defer cancel()
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade сам отвечает клиенту
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+	defer conn.Close()
+	h.logger.HandlerLog(r, http.StatusSwitchingProtocols, "Event socket opened")
+
+	// сообщения клиента не нужны, чтение только обрабатывает pong и закрытие
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * h.heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * h.heartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range replay {
+		if err = h.writeWS(conn, e); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.heartbeat)); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber is too slow"),
+					time.Now().Add(h.heartbeat))
+				return
+			}
+			if err = h.writeWS(conn, e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (h *eventsHandler) writeWS(conn *websocket.Conn, e *models.Event) error {
+	conn.SetWriteDeadline(time.Now().Add(h.heartbeat))
+	return conn.WriteJSON(e)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ast3am/educationProject/internal/events"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/outbox"
+	"github.com/ast3am/educationProject/internal/user/db"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type outboxStorage interface {
+	EnableOutbox(store *outbox.MemoryStore)
+}
+
+// testOutbox связывает хранилище в памяти с шиной, как outbox в cmd/main.go:
+// события, записанные после вызова, публикует в bus фоновый relay
+func testOutbox(t *testing.T, storage outboxStorage, bus *events.Bus) {
+	store := outbox.NewMemoryStore()
+	storage.EnableOutbox(store)
+	relay := outbox.NewMemoryRelay(store, time.Millisecond, time.Minute, 100, &logging.Logger{Logger: zerolog.Nop()})
+	relay.AddLocalConsumer("events", func(ctx context.Context, e *models.Event) error {
+		bus.Publish(e)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// readEvent ждет следующее событие подписки
+func readEvent(t *testing.T, ch <-chan *models.Event) *models.Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("event is not published")
+		return nil
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	ctx := context.Background()
+	log := logging.GetLogger()
+	bus := events.NewBus(10, 10)
+	storage := db.NewRepository(ctx, make(map[string]*models.UserModel), &logging.Logger{Logger: zerolog.Nop()})
+	for _, name := range []string{"John", "Nate", "Helen", "Anna"} {
+		if err := storage.Create(ctx, &models.UserModel{ID: storage.MakeID(), Name: name, Age: "20"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testOutbox(t, storage, bus)
+	_, published, cancel := bus.Subscribe("", 0)
+	defer cancel()
+
+	router := chi.NewRouter()
+	NewHandler(storage, log).Register(router)
+	NewEventsHandler(bus, time.Second, CheckOrigin(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}), log).Register(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, ids := range [][]string{{"1", "2"}, {"3", "4"}} {
+		body := bytes.NewBufferString(`{"target_id":"` + ids[1] + `"}`)
+		res, err := http.Post(server.URL+"/users/"+ids[0]+"/friends", "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		readEvent(t, published)
+	}
+
+	// SSE: продолжение после первого события, только события пользователя 4
+	req, _ := http.NewRequest("GET", server.URL+"/events?user=4", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wrong content type: got %s", ct)
+	}
+	reader := bufio.NewReader(res.Body)
+	lines := make([]string, 0, 3)
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "id: 2" || lines[1] != "event: friendship.created" || !strings.Contains(lines[2], `"user_ids":["3","4"]`) {
+		t.Errorf("wrong event: got %v", lines)
+	}
+
+	// WebSocket: источник проверяется по списку CORS
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/events/ws"
+	_, res, err = websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.com"}})
+	if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("unknown origin: got %v want status %v", err, http.StatusForbidden)
+	}
+	// без last_event_id приходят только новые события
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// разрыв дружбы и удаление приходят из outbox так же, как создание
+	for _, path := range []string{"/users/3/friends/4", "/users/1"} {
+		req, _ := http.NewRequest("DELETE", server.URL+path, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	testTable := []struct {
+		id      uint64
+		kind    string
+		userIDs []string
+	}{
+		{3, models.EventFriendshipDeleted, []string{"3", "4"}},
+		{4, models.EventUserDeleted, []string{"1"}},
+	}
+	for _, test := range testTable {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := models.Event{}
+		if err = json.Unmarshal(message, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != test.id || e.Type != test.kind || !reflect.DeepEqual(e.UserIDs, test.userIDs) || e.Key == "" {
+			t.Errorf("wrong event: got %+v want %s %v", e, test.kind, test.userIDs)
+		}
+	}
+
+	res, err = http.Get(server.URL + "/events?last_event_id=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", res.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFriendsLimit = 20
+	maxFriendsLimit     = 100
+)
+
+// NextCursorHeader дублирует next_cursor страницы, в CSV его больше негде передать
+const NextCursorHeader = "X-Next-Cursor"
+
+// поля ответа и соответствующие им поля модели хранения
+var friendFields = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"age":        "age",
+	"friend_ids": "friends",
+	"friendship": "",
+}
+
+// parseFriendsQuery разбирает ?limit=&cursor=&sort=name|age|since&fields=id,name
+func parseFriendsQuery(r *http.Request) (models.FriendsQuery, []string, error) {
+	values := r.URL.Query()
+	q := models.FriendsQuery{Limit: defaultFriendsLimit, Sort: models.SortBySince}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, nil, errors.New("limit must be a positive number")
+		}
+		if n > maxFriendsLimit {
+			n = maxFriendsLimit
+		}
+		q.Limit = n
+	}
+
+	switch sort := values.Get("sort"); sort {
+	case "":
+	case models.SortByName, models.SortByAge, models.SortBySince:
+		q.Sort = sort
+	default:
+		return q, nil, errors.New("unknown sort " + sort)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := models.DecodeCursor(cursor)
+		if err != nil {
+			return q, nil, err
+		}
+		q.After = after
+	}
+
+	var fields []string
+	if list := values.Get("fields"); list != "" {
+		for _, f := range strings.Split(list, ",") {
+			field, ok := friendFields[f]
+			if !ok {
+				return q, nil, errors.New("unknown field " + f)
+			}
+			fields = append(fields, f)
+			// метаданные дружбы не поле пользователя
+			if field != "" {
+				q.Fields = append(q.Fields, field)
+			}
+		}
+	}
+	return q, fields, nil
+}
+
+type FriendsPageResponse struct {
+	Friends    []interface{} `json:"friends"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	// для CSV: полные ответы и порядок колонок
+	users  []*UserResponse
+	fields []string
+}
+
+// NewFriendsPageResponse оставляет в ответе только запрошенные поля
+func NewFriendsPageResponse(page *models.FriendsPage, fields []string) *FriendsPageResponse {
+	resp := &FriendsPageResponse{Friends: make([]interface{}, 0, len(page.Friends)), fields: fields}
+	for _, f := range page.Friends {
+		u := NewUserResponse(f)
+		if meta, ok := page.Friendships[f.ID]; ok {
+			u.Friendship = NewFriendshipResponse(meta)
+		}
+		resp.users = append(resp.users, u)
+		if len(fields) == 0 {
+			resp.Friends = append(resp.Friends, u)
+			continue
+		}
+		resp.Friends = append(resp.Friends, u.Select(fields))
+	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+	return resp
+}
+
+func (p *FriendsPageResponse) csvFields() []string {
+	if len(p.fields) == 0 {
+		return []string{"id", "name", "age", "friend_ids", "friendship"}
+	}
+	return p.fields
+}
+
+func (p *FriendsPageResponse) CSVHeader() []string {
+	return csvHeader(p.csvFields())
+}
+
+// CSVRows - курсор следующей страницы в CSV передается заголовком NextCursorHeader
+func (p *FriendsPageResponse) CSVRows() [][]string {
+	fields := p.csvFields()
+	rows := make([][]string, 0, len(p.users))
+	for _, u := range p.users {
+		rows = append(rows, u.CSVRow(fields))
+	}
+	return rows
+}
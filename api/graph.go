@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ast3am/educationProject/internal/graph"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+type GraphStats interface {
+	Stats(ctx context.Context) (*graph.Stats, error)
+	Refresh() bool
+}
+
+type graphHandler struct {
+	stats  GraphStats
+	logger *logging.Logger
+}
+
+func NewGraphHandler(stats GraphStats, logger *logging.Logger) *graphHandler {
+	return &graphHandler{
+		stats:  stats,
+		logger: logger,
+	}
+}
+
+func (h *graphHandler) Register(router chi.Router) {
+	router.Get("/graph/stats", h.GetStats)
+}
+
+func (h *graphHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	// ?refresh=true сбрасывает кэш перед расчетом, если результат не слишком свежий
+	if r.URL.Query().Get("refresh") == "true" && !h.stats.Refresh() {
+		h.logger.Debug().Msg("graph stats are too fresh to refresh")
+	}
+
+	stats, err := h.stats.Stats(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	content, err := json.Marshal(stats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	h.logger.HandlerLog(r, http.StatusOK, "Graph stats received")
+}
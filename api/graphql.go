@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed graphql.graphql
+var graphQLSchema string
+
+// graphQLMaxDepth ограничивает вложенность запроса: друзья друзей - 8 уровней
+const graphQLMaxDepth = 8
+
+// протокол graphql-transport-ws для подписок
+const (
+	gqlSubprotocol    = "graphql-transport-ws"
+	gqlConnectionInit = "connection_init"
+	gqlConnectionAck  = "connection_ack"
+	gqlPing           = "ping"
+	gqlPong           = "pong"
+	gqlSubscribe      = "subscribe"
+	gqlNext           = "next"
+	gqlError          = "error"
+	gqlComplete       = "complete"
+)
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// расширения клиента не используются, но и не считаются ошибкой
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+type gqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type graphQLHandler struct {
+	schema    *graphql.Schema
+	resolver  *graphQLResolver
+	heartbeat time.Duration
+	upgrader  websocket.Upgrader
+	logger    *logging.Logger
+}
+
+// NewGraphQLHandler - checkOrigin проверяет источник WebSocket-подключения подписок, как в NewEventsHandler
+func NewGraphQLHandler(repository Repository, bus EventBus, heartbeat time.Duration, checkOrigin func(r *http.Request) bool, logger *logging.Logger) *graphQLHandler {
+	resolver := &graphQLResolver{repository: repository, bus: bus}
+	return &graphQLHandler{
+		schema:    graphql.MustParseSchema(graphQLSchema, resolver, graphql.MaxDepth(graphQLMaxDepth)),
+		resolver:  resolver,
+		heartbeat: heartbeat,
+		upgrader:  websocket.Upgrader{Subprotocols: []string{gqlSubprotocol}, CheckOrigin: checkOrigin},
+		logger:    logger,
+	}
+}
+
+func (h *graphQLHandler) Register(router chi.Router) {
+	router.Get("/graphql", h.Query)
+	router.Post("/graphql", h.Query)
+}
+
+// Query выполняет запрос из тела POST или из параметров GET, GET с Upgrade открывает подписку
+func (h *graphQLHandler) Query(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		h.WebSocket(w, r)
+		return
+	}
+	req, err := readGraphQLRequest(r)
+	if err != nil {
+		writeRequestError(w, r, h.logger, err)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), loaderKey{}, h.resolver.newLoader())
+	resp := h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	content, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	h.logger.HandlerLog(r, http.StatusOK, "GraphQL query executed")
+}
+
+func readGraphQLRequest(r *http.Request) (*graphQLRequest, error) {
+	req := &graphQLRequest{}
+	if r.Method == http.MethodGet {
+		values := r.URL.Query()
+		req.Query = values.Get("query")
+		req.OperationName = values.Get("operationName")
+		if v := values.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return nil, badRequest("parameter variables must be a JSON object")
+			}
+		}
+	} else if err := decodeJSON(r, req); err != nil {
+		return nil, err
+	}
+	if req.Query == "" {
+		return nil, errors.New("query is required")
+	}
+	return req, nil
+}
+
+// gqlConn - соединение graphql-transport-ws, запись из нескольких подписок идет под мьютексом
+type gqlConn struct {
+	conn      *websocket.Conn
+	heartbeat time.Duration
+	mu        sync.Mutex
+}
+
+func (c *gqlConn) send(id, typ string, payload interface{}) error {
+	msg := gqlMessage{ID: id, Type: typ}
+	if payload != nil {
+		content, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = content
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.heartbeat))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *gqlConn) close(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(c.heartbeat))
+}
+
+// WebSocket обслуживает подписки по протоколу graphql-transport-ws
+func (h *graphQLHandler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade сам отвечает клиенту
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+	defer ws.Close()
+	h.logger.HandlerLog(r, http.StatusSwitchingProtocols, "GraphQL socket opened")
+	if ws.Subprotocol() != gqlSubprotocol {
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseProtocolError, "subprotocol "+gqlSubprotocol+" is required"),
+			time.Now().Add(h.heartbeat))
+		return
+	}
+
+	conn := &gqlConn{conn: ws, heartbeat: h.heartbeat}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var mu sync.Mutex
+	subs := make(map[string]context.CancelFunc)
+	acked := false
+
+	for {
+		msg := gqlMessage{}
+		if err = ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case gqlConnectionInit:
+			if acked {
+				conn.close(4429, "Too many initialisation requests")
+				return
+			}
+			acked = true
+			if err = conn.send("", gqlConnectionAck, nil); err != nil {
+				return
+			}
+		case gqlPing:
+			if err = conn.send("", gqlPong, nil); err != nil {
+				return
+			}
+		case gqlPong:
+		case gqlSubscribe:
+			if !acked {
+				conn.close(4401, "Unauthorized")
+				return
+			}
+			req := graphQLRequest{}
+			if err = json.Unmarshal(msg.Payload, &req); err != nil || msg.ID == "" {
+				conn.close(4400, "Invalid subscribe message")
+				return
+			}
+			mu.Lock()
+			if _, ok := subs[msg.ID]; ok {
+				mu.Unlock()
+				conn.close(4409, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+			subCtx, subCancel := context.WithCancel(context.WithValue(ctx, loaderKey{}, h.resolver.newLoader()))
+			subs[msg.ID] = subCancel
+			mu.Unlock()
+
+			responses, err := h.schema.Subscribe(subCtx, req.Query, req.OperationName, req.Variables)
+			if err != nil {
+				mu.Lock()
+				delete(subs, msg.ID)
+				mu.Unlock()
+				subCancel()
+				conn.send(msg.ID, gqlError, []map[string]string{{"message": err.Error()}})
+				continue
+			}
+			go func(id string) {
+				for resp := range responses {
+					if err := conn.send(id, gqlNext, resp); err != nil {
+						subCancel()
+					}
+				}
+				mu.Lock()
+				_, active := subs[id]
+				delete(subs, id)
+				mu.Unlock()
+				subCancel()
+				// после complete от клиента ответный complete не нужен
+				if active {
+					conn.send(id, gqlComplete, nil)
+				}
+			}(msg.ID)
+		case gqlComplete:
+			mu.Lock()
+			if subCancel, ok := subs[msg.ID]; ok {
+				delete(subs, msg.ID)
+				subCancel()
+			}
+			mu.Unlock()
+		default:
+			conn.close(4400, "Unknown message type "+msg.Type)
+			return
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/loader"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/graph-gophers/graphql-go"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// пользователи, запрошенные резолверами в течение loaderWait, читаются одним запросом
+	loaderWait     = time.Millisecond
+	loaderMaxBatch = 100
+)
+
+// ограничения одного запроса GraphQL: вложенные friends(first:) перемножаются,
+// поэтому кроме глубины ограничены размер страницы и общее число пользователей
+const (
+	graphQLMaxFirst = 50
+	graphQLMaxUsers = 1000
+)
+
+var errTooManyUsers = errors.New("query requests more than " + strconv.Itoa(graphQLMaxUsers) + " users, narrow friends(first:)")
+
+type loaderKey struct{}
+
+// usersLoader - загрузчик одного запроса или события подписки, который считает
+// запрошенных пользователей, включая уже прочитанных
+type usersLoader struct {
+	*loader.Users
+	left int64
+}
+
+func (l *usersLoader) spend(n int) error {
+	if atomic.AddInt64(&l.left, -int64(n)) < 0 {
+		return errTooManyUsers
+	}
+	return nil
+}
+
+func (l *usersLoader) Load(ctx context.Context, id string) (*models.UserModel, error) {
+	if err := l.spend(1); err != nil {
+		return nil, err
+	}
+	return l.Users.Load(ctx, id)
+}
+
+func (l *usersLoader) LoadMany(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	if err := l.spend(len(ids)); err != nil {
+		return nil, err
+	}
+	return l.Users.LoadMany(ctx, ids)
+}
+
+// graphQLResolver - корневой резолвер запросов, мутаций и подписок
+type graphQLResolver struct {
+	repository Repository
+	bus        EventBus
+}
+
+func (g *graphQLResolver) newLoader() *usersLoader {
+	return &usersLoader{
+		Users: loader.NewUsers(g.repository.FindUsers, loaderWait, loaderMaxBatch),
+		left:  graphQLMaxUsers,
+	}
+}
+
+// loader - общий загрузчик запроса. Мутации и события подписки получают свой,
+// чтобы не отдавать пользователей, прочитанных до изменения
+func (g *graphQLResolver) loader(ctx context.Context) *usersLoader {
+	if l, ok := ctx.Value(loaderKey{}).(*usersLoader); ok {
+		return l
+	}
+	return g.newLoader()
+}
+
+// graphQLError убирает перевод строки, которым заканчиваются ошибки репозитория
+func graphQLError(err error) error {
+	return errors.New(strings.TrimSpace(err.Error()))
+}
+
+func (g *graphQLResolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	l := g.loader(ctx)
+	u, err := l.Load(ctx, string(args.ID))
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return &userResolver{u: u, loader: l}, nil
+}
+
+func (g *graphQLResolver) Users(ctx context.Context, args struct{ IDs []graphql.ID }) ([]*userResolver, error) {
+	ids := make([]string, len(args.IDs))
+	for i, id := range args.IDs {
+		ids[i] = string(id)
+	}
+	l := g.loader(ctx)
+	users, err := l.LoadMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return newUserResolvers(users, l), nil
+}
+
+// find читает пользователя после мутации
+func (g *graphQLResolver) find(ctx context.Context, id string) (*userResolver, error) {
+	u, err := g.repository.FindUser(ctx, id)
+	if err != nil {
+		return nil, graphQLError(err)
+	}
+	return &userResolver{u: u, loader: g.newLoader()}, nil
+}
+
+func (g *graphQLResolver) CreateUser(ctx context.Context, args struct {
+	Input struct {
+		Name string
+		Age  string
+	}
+}) (*userResolver, error) {
+	u := &models.UserModel{
+		ID:        g.repository.MakeID(),
+		Name:      args.Input.Name,
+		Age:       args.Input.Age,
+		FriendIDs: []string{},
+	}
+	if err := g.repository.Create(ctx, u); err != nil {
+		return nil, graphQLError(err)
+	}
+	return g.find(ctx, u.ID)
+}
+
+func (g *graphQLResolver) UpdateUser(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input struct{ Age *string }
+}) (*userResolver, error) {
+	if args.Input.Age == nil {
+		return nil, errors.New("nothing to update")
+	}
+	if err := g.repository.UpdateAge(ctx, string(args.ID), *args.Input.Age); err != nil {
+		return nil, graphQLError(err)
+	}
+	return g.find(ctx, string(args.ID))
+}
+
+func (g *graphQLResolver) DeleteUser(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	if _, err := g.repository.Delete(ctx, string(args.ID)); err != nil {
+		return "", graphQLError(err)
+	}
+	return args.ID, nil
+}
+
+type friendArgs struct {
+	UserID   graphql.ID
+	FriendID graphql.ID
+}
+
+func (g *graphQLResolver) Befriend(ctx context.Context, args friendArgs) (*userResolver, error) {
+	if _, err := g.repository.MakeFriends(ctx, string(args.UserID), string(args.FriendID)); err != nil {
+		return nil, graphQLError(err)
+	}
+	return g.find(ctx, string(args.UserID))
+}
+
+func (g *graphQLResolver) Unfriend(ctx context.Context, args friendArgs) (*userResolver, error) {
+	if _, err := g.repository.Unfriend(ctx, string(args.UserID), string(args.FriendID)); err != nil {
+		return nil, graphQLError(err)
+	}
+	return g.find(ctx, string(args.UserID))
+}
+
+// FriendshipEvents передает события дружбы из шины, пока клиент не отменит подписку
+func (g *graphQLResolver) FriendshipEvents(ctx context.Context, args struct{ UserID *graphql.ID }) (<-chan *friendshipEventResolver, error) {
+	userID := ""
+	if args.UserID != nil {
+		userID = string(*args.UserID)
+	}
+	_, events, cancel := g.bus.Subscribe(userID, 0)
+	out := make(chan *friendshipEventResolver)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if e.Type != models.EventFriendshipCreated && e.Type != models.EventFriendshipDeleted {
+					continue
+				}
+				select {
+				case out <- &friendshipEventResolver{e: e, loader: g.newLoader()}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+type userResolver struct {
+	u      *models.UserModel
+	loader *usersLoader
+}
+
+func newUserResolvers(users []*models.UserModel, l *usersLoader) []*userResolver {
+	resolvers := make([]*userResolver, len(users))
+	for i, u := range users {
+		resolvers[i] = &userResolver{u: u, loader: l}
+	}
+	return resolvers
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(r.u.ID)
+}
+
+func (r *userResolver) Name() string {
+	return r.u.Name
+}
+
+func (r *userResolver) Age() string {
+	return r.u.Age
+}
+
+func (r *userResolver) FriendCount() int32 {
+	return int32(len(r.u.FriendIDs))
+}
+
+// Friends берет страницу id из списка друзей пользователя, сами друзья всех
+// пользователей одного уровня читаются загрузчиком общими запросами
+func (r *userResolver) Friends(ctx context.Context, args struct {
+	First int32
+	After *string
+}) (*friendConnectionResolver, error) {
+	if args.First <= 0 {
+		return nil, errors.New("first must be a positive number")
+	}
+	limit := int(args.First)
+	if limit > graphQLMaxFirst {
+		limit = graphQLMaxFirst
+	}
+
+	ids := r.u.FriendIDs
+	start := 0
+	if args.After != nil {
+		c, err := models.DecodeCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		start = -1
+		for i, id := range ids {
+			if id == c.ID {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, models.ErrInvalidCursor
+		}
+	}
+	end := start + limit
+	if end > len(ids) {
+		end = len(ids)
+	}
+
+	friends, err := r.loader.LoadMany(ctx, ids[start:end])
+	if err != nil {
+		return nil, err
+	}
+	conn := &friendConnectionResolver{total: len(ids), hasNext: end < len(ids)}
+	position := make(map[string]int, end-start)
+	for i, id := range ids[start:end] {
+		position[id] = start + i
+	}
+	for _, f := range friends {
+		cursor := &models.Cursor{Key: strconv.Itoa(position[f.ID]), ID: f.ID}
+		conn.edges = append(conn.edges, &friendEdgeResolver{
+			cursor:     cursor.Encode(),
+			node:       &userResolver{u: f, loader: r.loader},
+			friendship: r.u.Friendships[f.ID],
+		})
+	}
+	return conn, nil
+}
+
+type friendConnectionResolver struct {
+	edges   []*friendEdgeResolver
+	total   int
+	hasNext bool
+}
+
+func (c *friendConnectionResolver) Edges() []*friendEdgeResolver {
+	if c.edges == nil {
+		return []*friendEdgeResolver{}
+	}
+	return c.edges
+}
+
+func (c *friendConnectionResolver) TotalCount() int32 {
+	return int32(c.total)
+}
+
+func (c *friendConnectionResolver) PageInfo() *pageInfoResolver {
+	info := &pageInfoResolver{hasNext: c.hasNext}
+	if len(c.edges) > 0 {
+		info.endCursor = &c.edges[len(c.edges)-1].cursor
+	}
+	return info
+}
+
+type friendEdgeResolver struct {
+	cursor     string
+	node       *userResolver
+	friendship *models.Friendship
+}
+
+func (e *friendEdgeResolver) Cursor() string {
+	return e.cursor
+}
+
+func (e *friendEdgeResolver) Node() *userResolver {
+	return e.node
+}
+
+func (e *friendEdgeResolver) Friendship() *friendshipResolver {
+	if e.friendship == nil {
+		return nil
+	}
+	return &friendshipResolver{NewFriendshipResponse(e.friendship)}
+}
+
+type friendshipResolver struct {
+	f *FriendshipResponse
+}
+
+func (r *friendshipResolver) Since() *string {
+	if r.f.Since == nil {
+		return nil
+	}
+	since := r.f.Since.Format(time.RFC3339Nano)
+	return &since
+}
+
+func (r *friendshipResolver) Initiator() *string {
+	if r.f.Initiator == "" {
+		return nil
+	}
+	return &r.f.Initiator
+}
+
+func (r *friendshipResolver) Label() *string {
+	if r.f.Label == "" {
+		return nil
+	}
+	return &r.f.Label
+}
+
+func (r *friendshipResolver) Closeness() *float64 {
+	return r.f.Closeness
+}
+
+type pageInfoResolver struct {
+	hasNext   bool
+	endCursor *string
+}
+
+func (p *pageInfoResolver) HasNextPage() bool {
+	return p.hasNext
+}
+
+func (p *pageInfoResolver) EndCursor() *string {
+	return p.endCursor
+}
+
+type friendshipEventResolver struct {
+	e      *models.Event
+	loader *usersLoader
+}
+
+func (r *friendshipEventResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(r.e.ID, 10))
+}
+
+func (r *friendshipEventResolver) Type() string {
+	return r.e.Type
+}
+
+func (r *friendshipEventResolver) Time() string {
+	return r.e.Time.Format(time.RFC3339Nano)
+}
+
+func (r *friendshipEventResolver) Users(ctx context.Context) ([]*userResolver, error) {
+	users, err := r.loader.LoadMany(ctx, r.e.UserIDs)
+	if err != nil {
+		return nil, err
+	}
+	return newUserResolvers(users, r.loader), nil
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ast3am/educationProject/internal/events"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/user/db"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingRepository считает обращения к FindUsers
+type countingRepository struct {
+	Repository
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingRepository) FindUsers(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	return c.Repository.FindUsers(ctx, ids)
+}
+
+// testGraphQLServer: пользователь 1 дружит с 2, 3 и 4, пользователь 2 еще и с 3
+func testGraphQLServer(t *testing.T) (*httptest.Server, *countingRepository, *events.Bus) {
+	ctx := context.Background()
+	log := &logging.Logger{Logger: zerolog.Nop()}
+	storage := db.NewRepository(ctx, make(map[string]*models.UserModel), log)
+	for _, name := range []string{"John", "Nate", "Helen", "Anna"} {
+		if err := storage.Create(ctx, &models.UserModel{ID: storage.MakeID(), Name: name, Age: "20"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pair := range [][2]string{{"1", "2"}, {"1", "3"}, {"1", "4"}, {"2", "3"}} {
+		if _, err := storage.MakeFriends(ctx, pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bus := events.NewBus(10, 10)
+	testOutbox(t, storage, bus)
+	repository := &countingRepository{Repository: storage}
+	router := chi.NewRouter()
+	NewGraphQLHandler(repository, bus, time.Second, nil, log).Register(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, repository, bus
+}
+
+type graphQLResult struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func postGraphQL(t *testing.T, url, query string, variables map[string]interface{}) graphQLResult {
+	body, _ := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	res, err := http.Post(url+"/graphql", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", res.StatusCode, http.StatusOK)
+	}
+	result := graphQLResult{}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestGraphQLHandler_Query(t *testing.T) {
+	server, repository, _ := testGraphQLServer(t)
+
+	result := postGraphQL(t, server.URL, `query($id: ID!) {
+		user(id: $id) {
+			name
+			friends(first: 2) {
+				totalCount
+				pageInfo { hasNextPage endCursor }
+				edges { node { id friendCount friends { edges { node { name } } } } friendship { initiator } }
+			}
+		}
+	}`, map[string]interface{}{"id": "1"})
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", result.Errors)
+	}
+	content, _ := json.Marshal(result.Data)
+	got := string(content)
+	for _, want := range []string{
+		`"name":"John"`,
+		`"totalCount":3`,
+		`"hasNextPage":true`,
+		`{"friendship":{"initiator":"1"},"node":{"friendCount":2`,
+		`"name":"Helen"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("response %s does not contain %s", got, want)
+		}
+	}
+	// пользователь и его друзья читаются двумя запросами, друзья друзей (1, 2, 3) уже в кэше
+	if repository.calls != 2 {
+		t.Errorf("want 2 batched FindUsers calls, got %d", repository.calls)
+	}
+
+	user := result.Data["user"].(map[string]interface{})
+	cursor := user["friends"].(map[string]interface{})["pageInfo"].(map[string]interface{})["endCursor"]
+	result = postGraphQL(t, server.URL, `query($after: String) {
+		user(id: "1") { friends(first: 2, after: $after) { pageInfo { hasNextPage } edges { node { id } } } }
+	}`, map[string]interface{}{"after": cursor})
+	content, _ = json.Marshal(result.Data)
+	if want := `{"user":{"friends":{"edges":[{"node":{"id":"4"}}],"pageInfo":{"hasNextPage":false}}}}`; string(content) != want {
+		t.Errorf("wrong second page: got %s want %s", content, want)
+	}
+
+	result = postGraphQL(t, server.URL, `{ user(id: "1") { friends(after: "bad") { totalCount } } }`, nil)
+	if len(result.Errors) != 1 || result.Errors[0].Message != "invalid cursor" {
+		t.Errorf("want invalid cursor error, got %+v", result.Errors)
+	}
+
+	res, err := http.Get(server.URL + "/graphql?query=" + `%7Busers(ids:%5B%222%22,%2299%22%5D)%7Bname%7D%7D`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = graphQLResult{}
+	json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	content, _ = json.Marshal(result.Data)
+	if want := `{"users":[{"name":"Nate"}]}`; string(content) != want {
+		t.Errorf("wrong GET response: got %s want %s", content, want)
+	}
+
+	res, err = http.Post(server.URL+"/graphql", "application/json", strings.NewReader(`{"query":`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestGraphQLHandler_Mutation(t *testing.T) {
+	server, _, _ := testGraphQLServer(t)
+
+	result := postGraphQL(t, server.URL, `mutation {
+		createUser(input: {name: "Kate", age: "30"}) { id name age friendCount }
+	}`, nil)
+	content, _ := json.Marshal(result.Data)
+	if want := `{"createUser":{"age":"30","friendCount":0,"id":"5","name":"Kate"}}`; string(content) != want {
+		t.Errorf("wrong createUser: got %s want %s", content, want)
+	}
+
+	result = postGraphQL(t, server.URL, `mutation {
+		befriend(userId: "5", friendId: "2") { friends { totalCount edges { node { friendCount } } } }
+	}`, nil)
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %+v", result.Errors)
+	}
+	content, _ = json.Marshal(result.Data)
+	if want := `{"befriend":{"friends":{"edges":[{"node":{"friendCount":3}}],"totalCount":1}}}`; string(content) != want {
+		t.Errorf("wrong befriend: got %s want %s", content, want)
+	}
+
+	result = postGraphQL(t, server.URL, `mutation {
+		updateUser(id: "5", input: {age: "31"}) { age }
+		unfriend(userId: "5", friendId: "2") { friendCount }
+		deleteUser(id: "5")
+	}`, nil)
+	content, _ = json.Marshal(result.Data)
+	if want := `{"deleteUser":"5","unfriend":{"friendCount":0},"updateUser":{"age":"31"}}`; string(content) != want {
+		t.Errorf("wrong mutations: got %s want %s", content, want)
+	}
+
+	result = postGraphQL(t, server.URL, `mutation { befriend(userId: "1", friendId: "2") { id } }`, nil)
+	if len(result.Errors) != 1 || result.Errors[0].Message != "Пользователи 1 2 уже друзья" {
+		t.Errorf("want already friends error, got %+v", result.Errors)
+	}
+}
+
+func TestGraphQLHandler_Subscription(t *testing.T) {
+	server, _, _ := testGraphQLServer(t)
+
+	dialer := websocket.Dialer{Subprotocols: []string{gqlSubprotocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	read := func(wantType string) gqlMessage {
+		t.Helper()
+		msg := gqlMessage{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != wantType {
+			t.Fatalf("wrong message type: got %s want %s", msg.Type, wantType)
+		}
+		return msg
+	}
+
+	conn.WriteJSON(gqlMessage{Type: gqlConnectionInit})
+	read(gqlConnectionAck)
+	payload, _ := json.Marshal(graphQLRequest{Query: `subscription { friendshipEvents(userId: "4") { type users { name friendCount } } }`})
+	conn.WriteJSON(gqlMessage{ID: "1", Type: gqlSubscribe, Payload: payload})
+	// сообщения обрабатываются по порядку, после pong подписка уже оформлена
+	conn.WriteJSON(gqlMessage{Type: gqlPing})
+	read(gqlPong)
+
+	postGraphQL(t, server.URL, `mutation { befriend(userId: "2", friendId: "3") { id } }`, nil)
+	postGraphQL(t, server.URL, `mutation { befriend(userId: "4", friendId: "2") { id } }`, nil)
+
+	msg := read(gqlNext)
+	if msg.ID != "1" {
+		t.Errorf("wrong subscription id: got %s", msg.ID)
+	}
+	want := `{"data":{"friendshipEvents":{"type":"friendship.created","users":[{"name":"Anna","friendCount":2},{"name":"Nate","friendCount":3}]}}}`
+	if string(msg.Payload) != want {
+		t.Errorf("wrong event: got %s want %s", msg.Payload, want)
+	}
+
+	conn.WriteJSON(gqlMessage{ID: "1", Type: gqlComplete})
+	conn.WriteJSON(gqlMessage{Type: gqlPing})
+	read(gqlPong)
+}
+
+func TestGraphQLHandler_Limits(t *testing.T) {
+	ctx := context.Background()
+	log := &logging.Logger{Logger: zerolog.Nop()}
+	storage := db.NewRepository(ctx, make(map[string]*models.UserModel), log)
+	// 60 пользователей, каждый дружит со всеми
+	for i := 0; i < 60; i++ {
+		if err := storage.Create(ctx, &models.UserModel{ID: storage.MakeID(), Name: "User", Age: "20"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 60; i++ {
+		for j := i + 1; j <= 60; j++ {
+			if _, err := storage.MakeFriends(ctx, strconv.Itoa(i), strconv.Itoa(j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	bus := events.NewBus(10, 10)
+	router := chi.NewRouter()
+	NewGraphQLHandler(storage, bus, time.Second, nil, log).Register(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	query := `query($first: Int) { user(id: "1") { friends(first: $first) { edges { node { friends(first: $first) { edges { node { id } } } } } } } }`
+	testTable := []struct {
+		name          string
+		first         int
+		expectedEdges int
+		expectedError string
+	}{
+		// 1 + 20 + 20*20 пользователей
+		{"within budget", 20, 20, ""},
+		// first ограничен 50: 1 + 50 + 50*50 больше 1000
+		{"too many users", 100, 0, errTooManyUsers.Error()},
+	}
+	for _, test := range testTable {
+		result := postGraphQL(t, server.URL, query, map[string]interface{}{"first": test.first})
+		if test.expectedError != "" {
+			if len(result.Errors) == 0 || result.Errors[0].Message != test.expectedError {
+				t.Errorf("%s: got errors %+v want %q", test.name, result.Errors, test.expectedError)
+			}
+			continue
+		}
+		if len(result.Errors) != 0 {
+			t.Fatalf("%s: unexpected errors: %+v", test.name, result.Errors)
+		}
+		edges := result.Data["user"].(map[string]interface{})["friends"].(map[string]interface{})["edges"].([]interface{})
+		if len(edges) != test.expectedEdges {
+			t.Errorf("%s: got %d friends want %d", test.name, len(edges), test.expectedEdges)
+		}
+	}
+
+	result := postGraphQL(t, server.URL, `{ user(id: "1") { friends(first: 100) { edges { node { id } } } } }`, nil)
+	edges := result.Data["user"].(map[string]interface{})["friends"].(map[string]interface{})["edges"].([]interface{})
+	if len(edges) != graphQLMaxFirst {
+		t.Errorf("first is not capped: got %d friends want %d", len(edges), graphQLMaxFirst)
+	}
+
+	// друзья друзей друзей глубже 8 уровней
+	result = postGraphQL(t, server.URL, `{ user(id: "1") { friends { edges { node { friends { edges { node { friends { totalCount } } } } } } } } }`, nil)
+	if len(result.Errors) == 0 {
+		t.Errorf("too deep query is accepted")
+	}
+}
@@ -7,17 +7,27 @@ import (
 	"github.com/ast3am/educationProject/internal/models"
 	"github.com/ast3am/educationProject/pkg/logging"
 	"github.com/go-chi/chi/v5"
-	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 //go:generate mockery --name Repository
 type Repository interface {
 	Create(ctx context.Context, user *models.UserModel) error
 	MakeFriends(ctx context.Context, sourceId, targetId string) (string, error)
+	Unfriend(ctx context.Context, id, friendID string) (string, error)
 	Delete(ctx context.Context, id string) (string, error)
+	FindUser(ctx context.Context, id string) (*models.UserModel, error)
+	FindUsers(ctx context.Context, ids []string) ([]*models.UserModel, error)
 	FindFriend(ctx context.Context, id string) (ufriends []*models.UserModel, err error)
+	FindFriendsPage(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, error)
+	FindAll(ctx context.Context) ([]*models.UserModel, error)
 	UpdateAge(ctx context.Context, id, age string) error
+	UpdateFriendship(ctx context.Context, id, friendID string, update models.FriendshipUpdate) (*models.Friendship, error)
+	Block(ctx context.Context, id, targetID string) error
+	Unblock(ctx context.Context, id, targetID string) error
+	FindFriendsOfFriends(ctx context.Context, id string, limit int) ([]*models.Suggestion, error)
+	Search(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error)
 	MakeID() string
 }
 
@@ -34,35 +44,55 @@ func NewHandler(repository Repository, logger *logging.Logger) *handler {
 }
 
 func (h *handler) Register(router chi.Router) {
+	router.Post("/users", h.Create)
+	router.Get("/users/search", h.Search)
+	router.Get("/users/{id}", h.GetUser)
+	router.Patch("/users/{id}", h.UpdateUser)
+	router.Delete("/users/{id}", h.DeleteUser)
+	router.Get("/users/{id}/friends", h.GetFriends)
+	router.Post("/users/{id}/friends", h.AddFriend)
+	router.Patch("/users/{id}/friends/{friendId}", h.UpdateFriendship)
+	router.Delete("/users/{id}/friends/{friendId}", h.Unfriend)
+	router.Get("/users/{id}/recommendations", h.GetRecommendations)
+	router.Post("/users/{id}/blocks", h.Block)
+	router.Delete("/users/{id}/blocks/{target}", h.Unblock)
+}
+
+// RegisterLegacy - маршруты до /api/v1, оставлены для старых клиентов
+func (h *handler) RegisterLegacy(router chi.Router) {
 	router.Post("/create", h.Create)
 	router.Post("/make_friends", h.MakeFriends)
 	router.Delete("/user", h.Delete)
 	router.Get("/friends/{id}", h.GetFriends)
+	router.Patch("/friends/{id}/{friendId}", h.UpdateFriendship)
+	router.Get("/users/search", h.Search)
+	router.Get("/users/{id}", h.GetUser)
+	router.Get("/users/{id}/recommendations", h.GetRecommendations)
+	router.Post("/users/{id}/blocks", h.Block)
+	router.Delete("/users/{id}/blocks/{target}", h.Unblock)
 	router.Put("/{id}", h.UpdateAge)
 }
 
 func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
-	content, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
-		return
-	}
-	defer r.Body.Close()
-
 	u := models.UserModel{}
-
-	err = json.Unmarshal(content, &u)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unmarshal error \n" + err.Error()))
-		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+	if err := decodeJSON(r, &u); err != nil {
+		writeRequestError(w, r, h.logger, err)
 		return
 	}
 
+	// друзья добавляются только через /make_friends
 	u.ID = h.repository.MakeID()
-	h.repository.Create(r.Context(), &u)
+	u.FriendIDs = []string{}
+	if err := h.repository.Create(r.Context(), &u); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrUserExists) {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, status, "", err)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("New user created with id:" + u.ID))
@@ -70,15 +100,6 @@ func (h *handler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) MakeFriends(w http.ResponseWriter, r *http.Request) {
-	content, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
-		return
-	}
-	defer r.Body.Close()
-
 	// получение ID из тела запроса
 
 	type MakeFriendRequest struct {
@@ -88,26 +109,42 @@ func (h *handler) MakeFriends(w http.ResponseWriter, r *http.Request) {
 
 	mf := MakeFriendRequest{"", ""}
 
-	err = json.Unmarshal(content, &mf)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unmarshal error \n" + err.Error()))
-		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+	if err := decodeJSON(r, &mf); err != nil {
+		writeRequestError(w, r, h.logger, err)
 		return
 	}
 
 	// проверка полей
 
 	if mf.SourceID == "" || mf.TargetID == "" {
+		err := errors.New("Unmarshal error: some ID is nil")
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unmarshal error: some ID is nil"))
-		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "Unmarshal error: some ID is nil", err)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
 		return
 	}
 
-	// создание друзей
+	h.makeFriends(w, r, mf.SourceID, mf.TargetID)
+}
 
-	text, err := h.repository.MakeFriends(r.Context(), mf.SourceID, mf.TargetID)
+// AddFriend - POST /users/{id}/friends с {"target_id":"2"}
+func (h *handler) AddFriend(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	target, err := h.readTarget(w, r)
+	if err != nil {
+		return
+	}
+	h.makeFriends(w, r, id, target)
+}
+
+func (h *handler) makeFriends(w http.ResponseWriter, r *http.Request, sourceID, targetID string) {
+	text, err := h.repository.MakeFriends(r.Context(), sourceID, targetID)
+	if errors.Is(err, models.ErrBlocked) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusForbidden, "", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -118,18 +155,9 @@ func (h *handler) MakeFriends(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(text))
 	h.logger.HandlerLog(r, http.StatusCreated, "Friends were made")
-
 }
 
 func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
-	content, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
-	}
-	defer r.Body.Close()
-
 	//получаем ID из запроса
 
 	type GetID struct {
@@ -138,17 +166,21 @@ func (h *handler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	id := GetID{""}
 
-	err = json.Unmarshal(content, &id)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unmarshal error \n" + err.Error()))
-		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+	if err := decodeJSON(r, &id); err != nil {
+		writeRequestError(w, r, h.logger, err)
 		return
 	}
 
-	// удаляем пользователя
+	h.deleteUser(w, r, id.TargetID)
+}
+
+// DeleteUser - DELETE /users/{id}
+func (h *handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	h.deleteUser(w, r, chi.URLParam(r, "id"))
+}
 
-	text, err := h.repository.Delete(r.Context(), id.TargetID)
+func (h *handler) deleteUser(w http.ResponseWriter, r *http.Request, id string) {
+	text, err := h.repository.Delete(r.Context(), id)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -171,7 +203,8 @@ func (h *handler) GetFriends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	friends, err := h.repository.FindFriend(r.Context(), id)
+	// разбор параметров страницы
+	q, fields, err := parseFriendsQuery(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -179,30 +212,102 @@ func (h *handler) GetFriends(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(200)
-	w.Write([]byte("друзья пользователя с id: " + id + "\n"))
-	for _, v := range friends {
-		w.Write([]byte(v.Name + "\n"))
+	page, err := h.repository.FindFriendsPage(r.Context(), id, q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
 	}
 
-	h.logger.HandlerLog(r, http.StatusCreated, "Friends received")
+	resp := NewFriendsPageResponse(page, fields)
+	if resp.NextCursor != "" {
+		w.Header().Set(NextCursorHeader, resp.NextCursor)
+	}
+	writeResponse(w, r, h.logger, http.StatusOK, resp, "Friends received")
 }
 
-func (h *handler) UpdateAge(w http.ResponseWriter, r *http.Request) {
-	content, err := ioutil.ReadAll(r.Body)
+// Search ищет пользователей по имени, лучшие совпадения первыми
+func (h *handler) Search(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	page, err := h.repository.Search(r.Context(), q)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrInvalidCursor) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, status, "", err)
+		return
+	}
+
+	resp := NewSearchPageResponse(page)
+	if resp.NextCursor != "" {
+		w.Header().Set(NextCursorHeader, resp.NextCursor)
+	}
+	writeResponse(w, r, h.logger, http.StatusOK, resp, "Users found")
+}
+
+func (h *handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		err := errors.New("ID is nil")
+		w.Write([]byte("ID is nil"))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	u, err := h.repository.FindUser(r.Context(), id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusNotFound, "", err)
+		return
+	}
+	resp := NewUserResponse(u)
+
+	// раскрытие друзей по запросу
+	if r.URL.Query().Get("expand") == "friends" {
+		friends, err := h.repository.FindFriend(r.Context(), id)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+			return
+		}
+		resp.Expand(friends)
+	}
+
+	content, err := json.Marshal(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
 	}
-	defer r.Body.Close()
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	h.logger.HandlerLog(r, http.StatusOK, "User received")
+}
+
+func (h *handler) UpdateAge(w http.ResponseWriter, r *http.Request) {
 	// получение ID из запроса
 
 	id := chi.URLParam(r, "id")
 	if id == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		err = errors.New("ID is nil")
+		err := errors.New("ID is nil")
 		w.Write([]byte("ID is nil"))
 		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
 		return
@@ -216,17 +321,37 @@ func (h *handler) UpdateAge(w http.ResponseWriter, r *http.Request) {
 
 	newAge := GetNewAge{""}
 
-	err = json.Unmarshal(content, &newAge)
-	if err != nil {
+	if err := decodeJSON(r, &newAge); err != nil {
+		writeRequestError(w, r, h.logger, err)
+		return
+	}
+
+	h.updateAge(w, r, id, newAge.NewAge)
+}
+
+// UpdateUser - PATCH /users/{id} с {"age":"28"}
+func (h *handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	type UpdateUserRequest struct {
+		Age *string `json:"age"`
+	}
+
+	update := UpdateUserRequest{}
+	if err := decodeJSON(r, &update); err != nil {
+		writeRequestError(w, r, h.logger, err)
+		return
+	}
+	if update.Age == nil {
+		err := errors.New("nothing to update")
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unmarshal error \n" + err.Error()))
+		w.Write([]byte(err.Error()))
 		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
 		return
 	}
+	h.updateAge(w, r, chi.URLParam(r, "id"), *update.Age)
+}
 
-	// обновление возраста
-
-	err = h.repository.UpdateAge(r.Context(), id, newAge.NewAge)
+func (h *handler) updateAge(w http.ResponseWriter, r *http.Request, id, age string) {
+	err := h.repository.UpdateAge(r.Context(), id, age)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -238,3 +363,158 @@ func (h *handler) UpdateAge(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("пользователь с id: " + id + " обновлен"))
 	h.logger.HandlerLog(r, http.StatusCreated, "User updated")
 }
+
+func (h *handler) Unfriend(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	friendID := chi.URLParam(r, "friendId")
+
+	text, err := h.repository.Unfriend(r.Context(), id, friendID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(text))
+	h.logger.HandlerLog(r, http.StatusOK, "Friendship deleted")
+}
+
+func (h *handler) UpdateFriendship(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	friendID := chi.URLParam(r, "friendId")
+
+	// чтение изменяемых полей, closeness от 0 до 1
+
+	update := models.FriendshipUpdate{}
+	if err := decodeJSON(r, &update); err != nil {
+		writeRequestError(w, r, h.logger, err)
+		return
+	}
+	var err error
+	if update.Label == nil && update.Closeness == nil {
+		err = errors.New("nothing to update")
+	} else if update.Closeness != nil && (*update.Closeness < 0 || *update.Closeness > 1) {
+		err = errors.New("closeness must be between 0 and 1")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	meta, err := h.repository.UpdateFriendship(r.Context(), id, friendID, update)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	content, err := json.Marshal(NewFriendshipResponse(meta))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	h.logger.HandlerLog(r, http.StatusOK, "Friendship updated")
+}
+
+// readTarget читает {"target_id":"2"} из тела запроса, при ошибке ответ уже отправлен
+func (h *handler) readTarget(w http.ResponseWriter, r *http.Request) (string, error) {
+	type TargetRequest struct {
+		TargetID string `json:"target_id"`
+	}
+
+	target := TargetRequest{""}
+
+	if err := decodeJSON(r, &target); err != nil {
+		writeRequestError(w, r, h.logger, err)
+		return "", err
+	}
+	if target.TargetID == "" {
+		err := errors.New("Unmarshal error: target ID is nil")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return "", err
+	}
+	return target.TargetID, nil
+}
+
+func (h *handler) Block(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	target, err := h.readTarget(w, r)
+	if err != nil {
+		return
+	}
+
+	// блокировка заодно разрывает дружбу
+
+	err = h.repository.Block(r.Context(), id, target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("пользователь " + target + " заблокирован пользователем " + id))
+	h.logger.HandlerLog(r, http.StatusOK, "User blocked")
+}
+
+func (h *handler) Unblock(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	target := chi.URLParam(r, "target")
+
+	err := h.repository.Unblock(r.Context(), id, target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("пользователь " + target + " разблокирован пользователем " + id))
+	h.logger.HandlerLog(r, http.StatusOK, "User unblocked")
+}
+
+func (h *handler) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	limit := defaultFriendsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxFriendsLimit {
+			err = errors.New("limit must be between 1 and " + strconv.Itoa(maxFriendsLimit))
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := h.repository.FindFriendsOfFriends(r.Context(), id, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	resp := make(SuggestionsResponse, 0, len(suggestions))
+	for _, s := range suggestions {
+		resp = append(resp, NewSuggestionResponse(s))
+	}
+	writeResponse(w, r, h.logger, http.StatusOK, resp, "Recommendations received")
+}
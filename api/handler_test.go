@@ -7,6 +7,8 @@ import (
 	"github.com/ast3am/educationProject/api/mocks"
 	"github.com/ast3am/educationProject/internal/models"
 	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -15,29 +17,46 @@ import (
 func TestHandler_Create(t *testing.T) {
 
 	testModel := models.UserModel{
-		"1",
-		"Helen",
-		"18",
-		[]*models.UserModel{},
+		ID:        "1",
+		Name:      "Helen",
+		Age:       "18",
+		FriendIDs: []string{},
 	}
 
 	testTable := []struct {
 		name                string
 		inputBody           string
+		createErr           error
 		expectedStatusCode  int
 		expectedRequestBody string
 	}{
 		{
 			"positive",
 			`{"name":"Helen","age":"18","friends":[]}`,
+			nil,
 			http.StatusCreated,
 			"New user created with id:1",
 		},
 		{
 			"negative",
 			`{"name":"Helen","age":18,"friends":[]}`,
+			nil,
 			http.StatusBadRequest,
-			"Unmarshal error \njson: cannot unmarshal number into Go struct field UserModel.age of type string",
+			"Unmarshal error \nfield \"age\" must be string, got number",
+		},
+		{
+			"duplicate id",
+			`{"name":"Helen","age":"18","friends":[]}`,
+			models.NewError(models.ErrUserExists, "пользователь с id 1 уже существует"),
+			http.StatusConflict,
+			"пользователь с id 1 уже существует",
+		},
+		{
+			"repository error",
+			`{"name":"Helen","age":"18","friends":[]}`,
+			fmt.Errorf("can't insert user: connection refused"),
+			http.StatusInternalServerError,
+			"can't insert user: connection refused",
 		},
 	}
 
@@ -48,10 +67,10 @@ func TestHandler_Create(t *testing.T) {
 	handler := NewHandler(repository, log)
 
 	for _, test := range testTable {
-		if test.name == "positive" {
+		if test.expectedStatusCode != http.StatusBadRequest {
 			repository.
-				On("MakeID").Return("1").
-				On("Create", ctx, &testModel).Return(nil)
+				On("MakeID").Return("1").Once().
+				On("Create", ctx, &testModel).Return(test.createErr).Once()
 		}
 		var jsonStr = []byte(test.inputBody)
 		req, err := http.NewRequest("POST", "/create", bytes.NewBuffer(jsonStr))
@@ -98,7 +117,13 @@ func TestHandler_MakeFriends(t *testing.T) {
 			"negative2",
 			`{"source_id":"1","target_id":2}`,
 			http.StatusBadRequest,
-			"Unmarshal error \njson: cannot unmarshal number into Go struct field MakeFriendRequest.target_id of type string",
+			"Unmarshal error \nfield \"target_id\" must be string, got number",
+		},
+		{
+			"blocked",
+			`{"source_id":"1","target_id":"3"}`,
+			http.StatusForbidden,
+			"пользователь заблокирован: 1, 3\n",
 		},
 	}
 
@@ -113,6 +138,10 @@ func TestHandler_MakeFriends(t *testing.T) {
 			repository.
 				On("MakeFriends", ctx, "1", "2").Return(test.expectedRequestBody, nil)
 		}
+		if test.name == "blocked" {
+			repository.
+				On("MakeFriends", ctx, "1", "3").Return("", fmt.Errorf("%w: 1, 3\n", models.ErrBlocked))
+		}
 		var jsonStr = []byte(test.inputBody)
 		req, err := http.NewRequest("POST", "/make_friends", bytes.NewBuffer(jsonStr))
 		if err != nil {
@@ -151,7 +180,7 @@ func TestHandler_Delete(t *testing.T) {
 			"negative",
 			`{"target_id":2}`,
 			http.StatusBadRequest,
-			"Unmarshal error \njson: cannot unmarshal number into Go struct field GetID.target_id of type string",
+			"Unmarshal error \nfield \"target_id\" must be string, got number",
 		},
 	}
 
@@ -188,3 +217,125 @@ func TestHandler_Delete(t *testing.T) {
 		}
 	}
 }
+func TestHandler_GetUser(t *testing.T) {
+	testTable := []struct {
+		name                string
+		url                 string
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			"positive",
+			"/users/1",
+			http.StatusOK,
+			`{"id":"1","name":"John","age":"24","friend_ids":["2"]}`,
+		},
+		{
+			"expand",
+			"/users/1?expand=friends",
+			http.StatusOK,
+			`{"id":"1","name":"John","age":"24","friend_ids":["2"],"friends":[{"id":"2","name":"Nate","age":"25","friend_ids":["1"]}]}`,
+		},
+	}
+
+	log := logging.GetLogger()
+	repository := mocks.NewRepository(t)
+	repository.
+		On("FindUser", mock.Anything, "1").Return(&models.UserModel{ID: "1", Name: "John", Age: "24", FriendIDs: []string{"2"}}, nil).
+		On("FindFriend", mock.Anything, "1").Return([]*models.UserModel{{ID: "2", Name: "Nate", Age: "25", FriendIDs: []string{"1"}}}, nil)
+
+	router := chi.NewRouter()
+	NewHandler(repository, log).Register(router)
+
+	for _, test := range testTable {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.name, w.Code, test.expectedStatusCode)
+		}
+
+		if w.Body.String() != test.expectedRequestBody {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v",
+				test.name, w.Body.String(), test.expectedRequestBody)
+		}
+	}
+}
+
+func TestHandler_Search(t *testing.T) {
+	testTable := []struct {
+		name                string
+		url                 string
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			"positive",
+			"/users/search?q=jhon&mode=fuzzy&limit=1",
+			http.StatusOK,
+			`{"users":[{"id":"1","name":"John","age":"24","friend_ids":["2"],"score":0.5}],"next_cursor":"eyJrIjoiMCIsImlkIjoiMSJ9"}`,
+		},
+		{
+			"without query",
+			"/users/search?mode=fuzzy",
+			http.StatusBadRequest,
+			"q is required",
+		},
+		{
+			"unknown mode",
+			"/users/search?q=jhon&mode=regex",
+			http.StatusBadRequest,
+			"unknown mode regex",
+		},
+		{
+			"stale cursor",
+			"/users/search?q=jhon&cursor=" + (&models.Cursor{Key: "x", ID: "1"}).Encode(),
+			http.StatusBadRequest,
+			"invalid cursor",
+		},
+		{
+			"repository error",
+			"/users/search?q=helen",
+			http.StatusInternalServerError,
+			"can't search users: connection refused",
+		},
+	}
+
+	log := logging.GetLogger()
+	repository := mocks.NewRepository(t)
+	repository.
+		On("Search", mock.Anything, models.SearchQuery{Text: "jhon", Mode: models.SearchFuzzy, Limit: 1}).
+		Return(&models.SearchPage{
+			Results: []*models.SearchResult{{User: &models.UserModel{ID: "1", Name: "John", Age: "24", FriendIDs: []string{"2"}}, Score: 0.5}},
+			Next:    &models.Cursor{Key: "0", ID: "1"},
+		}, nil)
+	repository.
+		On("Search", mock.Anything, models.SearchQuery{Text: "jhon", Mode: models.SearchPrefix, Limit: defaultSearchLimit, After: &models.Cursor{Key: "x", ID: "1"}}).
+		Return(nil, models.ErrInvalidCursor)
+	repository.
+		On("Search", mock.Anything, models.SearchQuery{Text: "helen", Mode: models.SearchPrefix, Limit: defaultSearchLimit}).
+		Return(nil, fmt.Errorf("can't search users: connection refused"))
+
+	router := chi.NewRouter()
+	NewHandler(repository, log).Register(router)
+
+	for _, test := range testTable {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.name, w.Code, test.expectedStatusCode)
+		}
+
+		if w.Body.String() != test.expectedRequestBody {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v",
+				test.name, w.Body.String(), test.expectedRequestBody)
+		}
+	}
+}
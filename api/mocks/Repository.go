@@ -14,6 +14,20 @@ type Repository struct {
 	mock.Mock
 }
 
+// Block provides a mock function with given fields: ctx, id, targetID
+func (_m *Repository) Block(ctx context.Context, id string, targetID string) error {
+	ret := _m.Called(ctx, id, targetID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, id, targetID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Create provides a mock function with given fields: ctx, user
 func (_m *Repository) Create(ctx context.Context, user *models.UserModel) error {
 	ret := _m.Called(ctx, user)
@@ -52,6 +66,32 @@ func (_m *Repository) Delete(ctx context.Context, id string) (string, error) {
 	return r0, r1
 }
 
+// FindAll provides a mock function with given fields: ctx
+func (_m *Repository) FindAll(ctx context.Context) ([]*models.UserModel, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*models.UserModel
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]*models.UserModel, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []*models.UserModel); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*models.UserModel)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindFriend provides a mock function with given fields: ctx, id
 func (_m *Repository) FindFriend(ctx context.Context, id string) ([]*models.UserModel, error) {
 	ret := _m.Called(ctx, id)
@@ -78,6 +118,110 @@ func (_m *Repository) FindFriend(ctx context.Context, id string) ([]*models.User
 	return r0, r1
 }
 
+// FindFriendsOfFriends provides a mock function with given fields: ctx, id, limit
+func (_m *Repository) FindFriendsOfFriends(ctx context.Context, id string, limit int) ([]*models.Suggestion, error) {
+	ret := _m.Called(ctx, id, limit)
+
+	var r0 []*models.Suggestion
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) ([]*models.Suggestion, error)); ok {
+		return rf(ctx, id, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) []*models.Suggestion); ok {
+		r0 = rf(ctx, id, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*models.Suggestion)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(ctx, id, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindFriendsPage provides a mock function with given fields: ctx, id, q
+func (_m *Repository) FindFriendsPage(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, error) {
+	ret := _m.Called(ctx, id, q)
+
+	var r0 *models.FriendsPage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.FriendsQuery) (*models.FriendsPage, error)); ok {
+		return rf(ctx, id, q)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.FriendsQuery) *models.FriendsPage); ok {
+		r0 = rf(ctx, id, q)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.FriendsPage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, models.FriendsQuery) error); ok {
+		r1 = rf(ctx, id, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindUser provides a mock function with given fields: ctx, id
+func (_m *Repository) FindUser(ctx context.Context, id string) (*models.UserModel, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *models.UserModel
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.UserModel, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.UserModel); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.UserModel)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindUsers provides a mock function with given fields: ctx, ids
+func (_m *Repository) FindUsers(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 []*models.UserModel
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string) ([]*models.UserModel, error)); ok {
+		return rf(ctx, ids)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []*models.UserModel); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*models.UserModel)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, ids)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // MakeFriends provides a mock function with given fields: ctx, sourceId, targetId
 func (_m *Repository) MakeFriends(ctx context.Context, sourceId string, targetId string) (string, error) {
 	ret := _m.Called(ctx, sourceId, targetId)
@@ -116,6 +260,70 @@ func (_m *Repository) MakeID() string {
 	return r0
 }
 
+// Search provides a mock function with given fields: ctx, q
+func (_m *Repository) Search(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 *models.SearchPage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.SearchQuery) (*models.SearchPage, error)); ok {
+		return rf(ctx, q)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.SearchQuery) *models.SearchPage); ok {
+		r0 = rf(ctx, q)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.SearchPage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.SearchQuery) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Unblock provides a mock function with given fields: ctx, id, targetID
+func (_m *Repository) Unblock(ctx context.Context, id string, targetID string) error {
+	ret := _m.Called(ctx, id, targetID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, id, targetID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Unfriend provides a mock function with given fields: ctx, id, friendID
+func (_m *Repository) Unfriend(ctx context.Context, id string, friendID string) (string, error) {
+	ret := _m.Called(ctx, id, friendID)
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (string, error)); ok {
+		return rf(ctx, id, friendID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(ctx, id, friendID)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, id, friendID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // UpdateAge provides a mock function with given fields: ctx, id, age
 func (_m *Repository) UpdateAge(ctx context.Context, id string, age string) error {
 	ret := _m.Called(ctx, id, age)
@@ -130,6 +338,32 @@ func (_m *Repository) UpdateAge(ctx context.Context, id string, age string) erro
 	return r0
 }
 
+// UpdateFriendship provides a mock function with given fields: ctx, id, friendID, update
+func (_m *Repository) UpdateFriendship(ctx context.Context, id string, friendID string, update models.FriendshipUpdate) (*models.Friendship, error) {
+	ret := _m.Called(ctx, id, friendID, update)
+
+	var r0 *models.Friendship
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.FriendshipUpdate) (*models.Friendship, error)); ok {
+		return rf(ctx, id, friendID, update)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, models.FriendshipUpdate) *models.Friendship); ok {
+		r0 = rf(ctx, id, friendID, update)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Friendship)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, models.FriendshipUpdate) error); ok {
+		r1 = rf(ctx, id, friendID, update)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewRepository interface {
 	mock.TestingT
 	Cleanup(func())
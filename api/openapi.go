@@ -0,0 +1,344 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Swagger UI показывает /openapi.json, скрипты берет из swaggerUI или, пока их там нет, с unpkg
+//
+//go:embed swagger.html
+var swaggerPage []byte
+
+// swaggerCDN - адрес swagger-ui-dist в swagger.html, версия та же, что в go:generate
+const swaggerCDN = "https://unpkg.com/swagger-ui-dist@5.17.14/"
+
+// swaggerUI - файлы swagger-ui-dist, встроенные в бинарник
+//
+//go:generate sh -c "curl -fsSL https://registry.npmjs.org/swagger-ui-dist/-/swagger-ui-dist-5.17.14.tgz | tar -xz -C swagger-ui --strip-components=1 package/swagger-ui.css package/swagger-ui-bundle.js package/LICENSE"
+//go:embed swagger-ui
+var swaggerUI embed.FS
+
+// docsPage - страница Swagger UI со встроенными файлами, если они есть
+var docsPage = swaggerDocsPage(swaggerUI)
+
+func swaggerDocsPage(files fs.FS) []byte {
+	if _, err := fs.Stat(files, "swagger-ui/swagger-ui-bundle.js"); err != nil {
+		return swaggerPage
+	}
+	// адрес относительно /docs, как и openapi.json
+	return bytes.ReplaceAll(swaggerPage, []byte(swaggerCDN), []byte("docs/"))
+}
+
+type openAPIHandler struct {
+	logger *logging.Logger
+}
+
+func NewOpenAPIHandler(logger *logging.Logger) *openAPIHandler {
+	return &openAPIHandler{
+		logger: logger,
+	}
+}
+
+func (h *openAPIHandler) Register(router chi.Router) {
+	router.Get("/openapi.json", h.Spec)
+	router.Get("/docs", h.Docs)
+	router.Get("/docs/{file}", h.DocsFile)
+}
+
+func (h *openAPIHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+	h.logger.HandlerLog(r, http.StatusOK, "OpenAPI spec sent")
+}
+
+func (h *openAPIHandler) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(docsPage)
+	h.logger.HandlerLog(r, http.StatusOK, "Swagger UI sent")
+}
+
+// DocsFile отдает встроенный файл swagger-ui-dist
+func (h *openAPIHandler) DocsFile(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "file")
+	data, err := fs.ReadFile(swaggerUI, path.Join("swagger-ui", name))
+	if err != nil || path.Ext(name) != ".js" && path.Ext(name) != ".css" {
+		http.NotFound(w, r)
+		h.logger.HandlerErrorLog(r, http.StatusNotFound, "", errors.New("no swagger ui file "+name))
+		return
+	}
+	contentType := "text/css; charset=utf-8"
+	if path.Ext(name) == ".js" {
+		contentType = "text/javascript; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	// файлы меняются только вместе с версией сервиса
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	h.logger.HandlerLog(r, http.StatusOK, "Swagger UI file sent")
+}
+
+// schema - часть JSON Schema из OpenAPI 3, которой хватает для тел запросов сервиса
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+	AllOf      []*schema          `json:"allOf"`
+	Enum       []interface{}      `json:"enum"`
+	Nullable   bool               `json:"nullable"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinLength  *int               `json:"minLength"`
+	Pattern    string             `json:"pattern"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIDocument struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// route - путь из спецификации, разбитый на сегменты, {param} совпадает с любым сегментом
+type route struct {
+	segments []string
+	literals int
+	methods  map[string]*operation
+}
+
+// Validator проверяет JSON-тела запросов по схемам из openapi.json
+type Validator struct {
+	// пути спецификации указаны относительно base
+	base    string
+	routes  []*route
+	schemas map[string]*schema
+	logger  *logging.Logger
+}
+
+func NewValidator(logger *logging.Logger) (*Validator, error) {
+	doc := openAPIDocument{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		return nil, fmt.Errorf("can't parse openapi spec: %w", err)
+	}
+	v := &Validator{schemas: doc.Components.Schemas, logger: logger}
+	if len(doc.Servers) > 0 {
+		v.base = strings.TrimSuffix(doc.Servers[0].URL, "/")
+	}
+	for path, methods := range doc.Paths {
+		rt := &route{segments: strings.Split(strings.Trim(path, "/"), "/"), methods: make(map[string]*operation)}
+		for _, s := range rt.segments {
+			if !strings.HasPrefix(s, "{") {
+				rt.literals++
+			}
+		}
+		for method, op := range methods {
+			rt.methods[strings.ToUpper(method)] = op
+		}
+		v.routes = append(v.routes, rt)
+	}
+	// пути с большим числом постоянных сегментов проверяются первыми, как в chi
+	sort.Slice(v.routes, func(i, j int) bool {
+		return v.routes[i].literals > v.routes[j].literals
+	})
+	return v, nil
+}
+
+// find возвращает описание метода для пути запроса
+func (v *Validator) find(method, path string) *operation {
+	if !strings.HasPrefix(path, v.base+"/") {
+		return nil
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, v.base), "/"), "/")
+	for _, rt := range v.routes {
+		if len(rt.segments) != len(segments) {
+			continue
+		}
+		match := true
+		for i, s := range rt.segments {
+			if !strings.HasPrefix(s, "{") && s != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			if op, ok := rt.methods[method]; ok {
+				return op
+			}
+		}
+	}
+	return nil
+}
+
+// Middleware отвечает 400 на тело, не подходящее под схему. Запросы без описанного
+// тела и пути, которых нет в спецификации (в том числе старые маршруты), проходят без проверки
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := v.find(r.Method, r.URL.Path)
+		if op == nil || op.RequestBody == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		media, ok := op.RequestBody.Content["application/json"]
+		if !ok || media.Schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		content, err := readBody(r)
+		if err != nil {
+			writeRequestError(w, r, v.logger, err)
+			return
+		}
+
+		err = v.validateBody(content, op.RequestBody.Required, media.Schema)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Validation error \n" + err.Error()))
+			v.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(content))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *Validator) validateBody(content []byte, required bool, s *schema) error {
+	if len(bytes.TrimSpace(content)) == 0 {
+		if required {
+			return errors.New("request body is required")
+		}
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return fmt.Errorf("request body is not json: %w", err)
+	}
+	return v.validate(value, s, "body")
+}
+
+func (v *Validator) validate(value interface{}, s *schema, path string) error {
+	if s.Ref != "" {
+		ref, ok := v.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, s.Ref)
+		}
+		return v.validate(value, ref, path)
+	}
+	for _, sub := range s.AllOf {
+		if err := v.validate(value, sub, path); err != nil {
+			return err
+		}
+	}
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: must not be null", path)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s: is required", path, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			if field, ok := obj[name]; ok {
+				if err := v.validate(field, prop, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array", path)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				if err := v.validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+		if s.MinLength != nil && len([]rune(str)) < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern: %w", path, err)
+			}
+			if !re.MatchString(str) {
+				return fmt.Errorf("%s: must match %s", path, s.Pattern)
+			}
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok || (s.Type == "integer" && n != float64(int64(n))) {
+			return fmt.Errorf("%s: must be %s", path, s.Type)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s: must be >= %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s: must be <= %v", path, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: must be a boolean", path)
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if e == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: must be one of %v", path, s.Enum)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ast3am/educationProject/api/mocks"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestOpenAPI_Routes падает, если зарегистрированного маршрута нет в openapi.json
+func TestOpenAPI_Routes(t *testing.T) {
+	log := logging.GetLogger()
+	router := chi.NewRouter()
+	NewHandler(mocks.NewRepository(t), log).Register(router)
+	NewEventsHandler(nil, 0, nil, log).Register(router)
+	NewWebhooksHandler(nil, log).Register(router)
+	NewOutboxHandler(nil, log).Register(router)
+	NewAuditHandler(nil, log).Register(router)
+	NewGraphHandler(nil, log).Register(router)
+	NewAdminHandler(nil, log).Register(router)
+	NewRetentionHandler(nil, log).Register(router)
+	NewGraphQLHandler(mocks.NewRepository(t), nil, 0, nil, log).Register(router)
+	NewOpenAPIHandler(log).Register(router)
+
+	doc := openAPIDocument{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	registered := make(map[string]bool)
+	err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered[method+" "+route] = true
+		if _, ok := doc.Paths[route][strings.ToLower(method)]; !ok {
+			t.Errorf("route %s %s is missing from openapi.json", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, methods := range doc.Paths {
+		for method := range methods {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				t.Errorf("openapi.json describes unknown route %s %s", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestValidator_Middleware(t *testing.T) {
+	testTable := []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		expectedStatusCode int
+	}{
+		{"valid", "POST", "/api/v1/users/1/friends", `{"target_id":"2"}`, http.StatusOK},
+		{"missing field", "POST", "/api/v1/users/1/friends", `{}`, http.StatusBadRequest},
+		{"wrong type", "POST", "/api/v1/users/1/friends", `{"target_id":2}`, http.StatusBadRequest},
+		{"not json", "POST", "/api/v1/users", `name=John`, http.StatusBadRequest},
+		{"pattern", "PATCH", "/api/v1/users/1", `{"age":"old"}`, http.StatusBadRequest},
+		{"path parameter", "PATCH", "/api/v1/users/1", `{"age":"28"}`, http.StatusOK},
+		{"maximum", "PATCH", "/api/v1/users/1/friends/2", `{"closeness":1.5}`, http.StatusBadRequest},
+		{"nullable", "PATCH", "/api/v1/users/1/friends/2", `{"label":null,"closeness":0.5}`, http.StatusOK},
+		{"enum in array", "POST", "/api/v1/webhooks", `{"url":"http://a","events":["user.blocked"]}`, http.StatusBadRequest},
+		{"without body in spec", "GET", "/api/v1/users/1", ``, http.StatusOK},
+		{"unknown route", "POST", "/api/v1/unknown", `{`, http.StatusOK},
+		{"legacy route", "PUT", "/1", `{"new_age":"old"}`, http.StatusOK},
+	}
+
+	validator, err := NewValidator(logging.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// тело должно дойти до обработчика целиком
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		body = buf.Bytes()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, test := range testTable {
+		body = nil
+		req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+
+		validator.Middleware(next).ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: wrong status code: got %v want %v (%s)", test.name, w.Code, test.expectedStatusCode, w.Body.String())
+		}
+		if w.Code == http.StatusOK && string(body) != test.body {
+			t.Errorf("%s: handler got body %q want %q", test.name, body, test.body)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	router := chi.NewRouter()
+	NewOpenAPIHandler(logging.GetLogger()).Register(router)
+
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("wrong response: %v %v", w.Code, w.Header().Get("Content-Type"))
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc["openapi"] != "3.0.3" {
+		t.Errorf("wrong spec: %v", err)
+	}
+}
+
+func TestSwaggerDocsPage(t *testing.T) {
+	// без встроенных файлов страница ссылается на unpkg
+	if page := swaggerDocsPage(fstest.MapFS{}); !bytes.Equal(page, swaggerPage) {
+		t.Errorf("page without vendored files is changed")
+	}
+
+	vendored := fstest.MapFS{"swagger-ui/swagger-ui-bundle.js": {Data: []byte("bundle")}}
+	page := string(swaggerDocsPage(vendored))
+	if strings.Contains(page, swaggerCDN) || !strings.Contains(page, `src="docs/swagger-ui-bundle.js"`) ||
+		!strings.Contains(page, `href="docs/swagger-ui.css"`) {
+		t.Errorf("page does not use vendored files:\n%s", page)
+	}
+
+	router := chi.NewRouter()
+	NewOpenAPIHandler(logging.GetLogger()).Register(router)
+	testTable := []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"missing file", "/docs/missing.js", http.StatusNotFound},
+		{"not an asset", "/docs/README.md", http.StatusNotFound},
+	}
+	for _, test := range testTable {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: got status %d want %d", test.name, w.Code, test.expectedStatusCode)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+type OutboxStatus interface {
+	Status(ctx context.Context) (*models.OutboxStatus, error)
+}
+
+type outboxHandler struct {
+	outbox OutboxStatus
+	logger *logging.Logger
+}
+
+func NewOutboxHandler(outbox OutboxStatus, logger *logging.Logger) *outboxHandler {
+	return &outboxHandler{
+		outbox: outbox,
+		logger: logger,
+	}
+}
+
+func (h *outboxHandler) Register(router chi.Router) {
+	router.Get("/admin/outbox", h.Status)
+}
+
+// Status - сколько событий в outbox и сколько из них еще не получил каждый потребитель
+func (h *outboxHandler) Status(w http.ResponseWriter, r *http.Request) {
+	status, err := h.outbox.Status(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	content, err := json.Marshal(status)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	h.logger.HandlerLog(r, http.StatusOK, "Outbox status")
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"github.com/ast3am/educationProject/internal/ratelimit"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIKeyHeader - ключ клиента, если он есть среди известных, частота считается по нему
+const APIKeyHeader = "X-API-Key"
+
+// RateLimiter ограничивает частоту запросов клиента. Для маршрутов из rules у клиента
+// отдельная корзина на каждый маршрут, остальные запросы делят общую корзину с правилом def
+type RateLimiter struct {
+	store   *ratelimit.Store
+	routes  chi.Routes
+	def     ratelimit.Rule
+	rules   map[string]ratelimit.Rule
+	keys    map[string]struct{}
+	proxies []*net.IPNet
+	logger  *logging.Logger
+}
+
+// NewRateLimiter - ключи rules вида "POST /api/v1/users", путь - шаблон маршрута chi.
+// apiKeys - ключи API, которым выделяются собственные корзины. За прокси из trustedProxies
+// адрес клиента берется из X-Forwarded-For
+func NewRateLimiter(routes chi.Routes, store *ratelimit.Store, def ratelimit.Rule, rules map[string]ratelimit.Rule, apiKeys []string, trustedProxies []*net.IPNet, logger *logging.Logger) *RateLimiter {
+	keys := make(map[string]struct{}, len(apiKeys))
+	for _, key := range apiKeys {
+		keys[key] = struct{}{}
+	}
+	return &RateLimiter{
+		store:   store,
+		routes:  routes,
+		def:     def,
+		rules:   rules,
+		keys:    keys,
+		proxies: trustedProxies,
+		logger:  logger,
+	}
+}
+
+// clientKey - subject проверенного клиентского сертификата, иначе известный ключ API,
+// иначе адрес клиента. X-Actor и неизвестные ключи не учитываются: клиент может
+// менять их в каждом запросе. Middleware должен стоять после ClientCert
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if subject, ok := ClientSubject(r.Context()); ok {
+		return "cert:" + subject.String()
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		if _, ok := l.keys[key]; ok {
+			return "key:" + key
+		}
+	}
+	return "ip:" + clientIP(r, l.proxies)
+}
+
+// rule находит правило по шаблону маршрута, до маршрутизации шаблон ищется заново
+func (l *RateLimiter) rule(r *http.Request) (string, ratelimit.Rule) {
+	if len(l.rules) > 0 {
+		rctx := chi.NewRouteContext()
+		if l.routes.Match(rctx, r.Method, r.URL.Path) {
+			route := r.Method + " " + rctx.RoutePattern()
+			if rule, ok := l.rules[route]; ok {
+				return route, rule
+			}
+		}
+	}
+	return "", l.def
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, rule := l.rule(r)
+		if rule.Disabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := l.clientKey(r)
+		if route != "" {
+			key += " " + route
+		}
+		res := l.store.Take(key, rule)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+		w.Header().Set("RateLimit-Policy", strconv.Itoa(rule.Limit)+";w="+ceilSeconds(rule.Period))
+		if !res.Allowed {
+			err := errors.New("too many requests, retry later\n")
+			w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(err.Error()))
+			l.logger.HandlerErrorLog(r, http.StatusTooManyRequests, "rate limit exceeded "+route, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds - секунды для заголовков, округление вверх, чтобы повтор не пришел раньше времени
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
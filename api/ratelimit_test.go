@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"github.com/ast3am/educationProject/internal/ratelimit"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	router := chi.NewRouter()
+	limiter := NewRateLimiter(router, ratelimit.NewStore(), ratelimit.Rule{Limit: 3, Period: time.Minute},
+		map[string]ratelimit.Rule{
+			"POST /api/v1/users/{id}/friends": {Limit: 1, Period: time.Minute},
+			"GET /api/v1/healthz":             {Limit: 0, Period: time.Minute},
+		}, []string{"k1"}, nil, logging.GetLogger())
+	router.Use(Actor, limiter.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.Route(APIPrefix, func(r chi.Router) {
+		r.Get("/users/{id}", ok)
+		r.Post("/users/{id}/friends", ok)
+		r.Get("/healthz", ok)
+	})
+
+	do := func(method, url, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testTable := []struct {
+		name               string
+		method             string
+		url                string
+		remoteAddr         string
+		headers            map[string]string
+		expectedStatusCode int
+		expectedRemaining  string
+	}{
+		{"route rule", "POST", "/api/v1/users/1/friends", "10.0.0.1:1000", nil, http.StatusOK, "0"},
+		{"route rule for other id", "POST", "/api/v1/users/2/friends", "10.0.0.1:1001", nil, http.StatusTooManyRequests, "0"},
+		{"default rule is separate", "GET", "/api/v1/users/1", "10.0.0.1:1002", nil, http.StatusOK, "2"},
+		{"default rule", "GET", "/api/v1/users/2", "10.0.0.1:1003", nil, http.StatusOK, "1"},
+		{"unknown route uses default", "GET", "/api/v1/unknown", "10.0.0.1:1004", nil, http.StatusNotFound, "0"},
+		{"default exhausted", "GET", "/api/v1/users/1", "10.0.0.1:1005", nil, http.StatusTooManyRequests, "0"},
+		{"other ip", "GET", "/api/v1/users/1", "10.0.0.2:1000", nil, http.StatusOK, "2"},
+		{"api key", "GET", "/api/v1/users/1", "10.0.0.1:1006", map[string]string{APIKeyHeader: "k1"}, http.StatusOK, "2"},
+		{"actor is not a client key", "POST", "/api/v1/users/1/friends", "10.0.0.1:1007", map[string]string{ActorHeader: "admin"}, http.StatusTooManyRequests, "0"},
+		{"unknown api key", "GET", "/api/v1/users/1", "10.0.0.1:1010", map[string]string{APIKeyHeader: "random1"}, http.StatusTooManyRequests, "0"},
+		{"rotated api key", "GET", "/api/v1/users/1", "10.0.0.1:1011", map[string]string{APIKeyHeader: "random2", ActorHeader: "other"}, http.StatusTooManyRequests, "0"},
+		{"api key before actor", "POST", "/api/v1/users/1/friends", "10.0.0.1:1008", map[string]string{ActorHeader: "admin", APIKeyHeader: "k1"}, http.StatusOK, "0"},
+		{"disabled rule", "GET", "/api/v1/healthz", "10.0.0.1:1009", nil, http.StatusOK, ""},
+	}
+
+	for _, tc := range testTable {
+		rr := do(tc.method, tc.url, tc.remoteAddr, tc.headers)
+		if rr.Code != tc.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tc.name, rr.Code, tc.expectedStatusCode)
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != tc.expectedRemaining {
+			t.Errorf("%s: wrong RateLimit-Remaining: got %q want %q", tc.name, got, tc.expectedRemaining)
+		}
+	}
+
+	rr := do("POST", "/api/v1/users/3/friends", "10.0.0.1:2000", nil)
+	for header, want := range map[string]string{
+		"Retry-After":      "60",
+		"RateLimit-Limit":  "1",
+		"RateLimit-Reset":  "60",
+		"RateLimit-Policy": "1;w=60",
+	} {
+		if got := rr.Header().Get(header); got != want {
+			t.Errorf("wrong %s: got %q want %q", header, got, want)
+		}
+	}
+}
+
+func TestRateLimiter_ClientKey(t *testing.T) {
+	proxies, err := ParseNetworks([]string{"192.0.2.0/24", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRateLimiter(chi.NewRouter(), ratelimit.NewStore(), ratelimit.Rule{Limit: 1, Period: time.Minute},
+		nil, []string{"k1"}, proxies, logging.GetLogger())
+	withCert := httptest.NewRequest("GET", "/", nil)
+	withCert = withCert.WithContext(context.WithValue(withCert.Context(), clientSubjectKey{}, pkix.Name{CommonName: "billing"}))
+	withCert.Header.Set(APIKeyHeader, "k1")
+
+	// forwarded - запрос от прокси remoteAddr с X-Forwarded-For
+	forwarded := func(remoteAddr string, values ...string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for _, v := range values {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		return req
+	}
+
+	testTable := []struct {
+		name     string
+		req      *http.Request
+		apiKey   string
+		expected string
+	}{
+		{"verified certificate", withCert, "", "cert:CN=billing"},
+		{"known api key", forwarded("192.0.2.1:1234", "203.0.113.7"), "k1", "key:k1"},
+		{"unknown api key", forwarded("198.51.100.1:1234"), "k2", "ip:198.51.100.1"},
+		{"no key", forwarded("198.51.100.1:1234"), "", "ip:198.51.100.1"},
+		{"trusted proxy", forwarded("192.0.2.1:1234", "203.0.113.7"), "", "ip:203.0.113.7"},
+		{"proxy chain", forwarded("192.0.2.1:1234", "203.0.113.7, 10.0.0.5"), "", "ip:203.0.113.7"},
+		{"several headers", forwarded("192.0.2.1:1234", "203.0.113.7", "10.0.0.5"), "", "ip:203.0.113.7"},
+		{"spoofed by client", forwarded("192.0.2.1:1234", "1.1.1.1, 203.0.113.7"), "", "ip:203.0.113.7"},
+		{"untrusted peer", forwarded("198.51.100.1:1234", "203.0.113.7"), "", "ip:198.51.100.1"},
+		{"only proxies", forwarded("192.0.2.1:1234", "10.0.0.5"), "", "ip:10.0.0.5"},
+		{"no header", forwarded("192.0.2.1:1234"), "", "ip:192.0.2.1"},
+		{"invalid address", forwarded("192.0.2.1:1234", "unknown"), "", "ip:192.0.2.1"},
+	}
+	for _, tc := range testTable {
+		if tc.apiKey != "" {
+			tc.req.Header.Set(APIKeyHeader, tc.apiKey)
+		}
+		if got := limiter.clientKey(tc.req); got != tc.expected {
+			t.Errorf("%s: wrong client key: got %q want %q", tc.name, got, tc.expected)
+		}
+	}
+}
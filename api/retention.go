@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+type Restorer interface {
+	Restore(ctx context.Context, id string) error
+}
+
+type retentionHandler struct {
+	restorer Restorer
+	logger   *logging.Logger
+}
+
+func NewRetentionHandler(restorer Restorer, logger *logging.Logger) *retentionHandler {
+	return &retentionHandler{
+		restorer: restorer,
+		logger:   logger,
+	}
+}
+
+func (h *retentionHandler) Register(router chi.Router) {
+	router.Post("/users/{id}/restore", h.Restore)
+}
+
+// Restore возвращает удаленного пользователя вместе с его друзьями,
+// после окончания срока хранения отвечает 410
+func (h *retentionHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := h.restorer.Restore(r.Context(), id)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, models.ErrRetentionExpired) {
+			status = http.StatusGone
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, status, "", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("пользователь " + id + " восстановлен"))
+	h.logger.HandlerLog(r, http.StatusOK, "User restored")
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/api"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// метаданные запроса, аналоги заголовков X-Actor и X-Request-Id в HTTP API
+const (
+	actorKey     = "x-actor"
+	requestIDKey = "x-request-id"
+)
+
+// requestContext переносит исполнителя и id запроса из метаданных в контекст,
+// чтобы журнал аудита видел их так же, как для HTTP-запросов. При mTLS исполнитель
+// из x-actor не проверен, журнал записывает его отдельно от subject сертификата
+func requestContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	actor := ""
+	if v := md.Get(actorKey); len(v) > 0 {
+		actor = v[0]
+	}
+	ctx = api.WithActor(ctx, actor)
+	if v := md.Get(requestIDKey); len(v) > 0 && v[0] != "" {
+		ctx = context.WithValue(ctx, middleware.RequestIDKey, v[0])
+	}
+	// при mTLS subject клиента доступен через api.ClientSubject, как в HTTP
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			ctx = api.WithClientSubject(ctx, info.State)
+		}
+	}
+	return ctx
+}
+
+func logCall(logger *logging.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+	event := logger.Info()
+	if err != nil {
+		event = logger.Error().Err(err)
+	}
+	event.Str("method", method).
+		Str("code", code.String()).
+		Dur("duration", time.Since(start)).
+		Msg("gRPC call")
+}
+
+func UnaryInterceptor(logger *logging.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(requestContext(ctx), req)
+		logCall(logger, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func StreamInterceptor(logger *logging.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, &serverStream{ServerStream: stream, ctx: requestContext(stream.Context())})
+		logCall(logger, info.FullMethod, start, err)
+		return err
+	}
+}
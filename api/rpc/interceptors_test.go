@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/ast3am/educationProject/api"
+	"github.com/ast3am/educationProject/internal/audit"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/user/db"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequestContext_ClientSubject(t *testing.T) {
+	subject := pkix.Name{CommonName: "billing", Organization: []string{"educationProject"}}
+	testTable := []struct {
+		name     string
+		auth     credentials.AuthInfo
+		expected string
+	}{
+		{"plaintext", nil, ""},
+		{"tls without client certificate", credentials.TLSInfo{}, ""},
+		{"unverified certificate", credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: subject}},
+		}}, ""},
+		{"verified certificate", credentials.TLSInfo{State: tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: subject}},
+			VerifiedChains:   [][]*x509.Certificate{{{Subject: subject}, {Subject: pkix.Name{CommonName: "CA"}}}},
+		}}, "CN=billing,O=educationProject"},
+	}
+	for _, test := range testTable {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: test.auth})
+		got := ""
+		if s, ok := api.ClientSubject(requestContext(ctx)); ok {
+			got = s.String()
+		}
+		if got != test.expected {
+			t.Errorf("%s: got subject %q want %q", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestRequestContext_Actor(t *testing.T) {
+	subject := pkix.Name{CommonName: "billing"}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(actorKey, "admin"))
+
+	store, err := audit.NewFileStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	log := &logging.Logger{Logger: zerolog.Nop()}
+	users := db.NewRepository(ctx, map[string]*models.UserModel{"1": {ID: "1", Name: "John", Age: "24"}}, log)
+	if err = api.NewAuditRepository(users, store, log).UpdateAge(requestContext(ctx), "1", "25"); err != nil {
+		t.Fatal(err)
+	}
+
+	// x-actor сохраняется, но исполнитель - subject проверенного сертификата
+	entries, err := store.Find(ctx, models.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "CN=billing" || !entries[0].ActorVerified || entries[0].ClaimedActor != "admin" {
+		t.Errorf("wrong audit entries: got %+v", entries)
+	}
+}
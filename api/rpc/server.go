@@ -0,0 +1,212 @@
+// Package rpc - gRPC-сервер UserService поверх того же репозитория, что и HTTP API
+package rpc
+
+//go:generate protoc -I ../proto --go_out=../proto/userpb --go_opt=paths=source_relative --go-grpc_out=../proto/userpb --go-grpc_opt=paths=source_relative user.proto
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/api/proto/userpb"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+)
+
+const defaultPageSize = 100
+
+type Repository interface {
+	Create(ctx context.Context, user *models.UserModel) error
+	FindUser(ctx context.Context, id string) (*models.UserModel, error)
+	FindFriendsPage(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, error)
+	UpdateAge(ctx context.Context, id, age string) error
+	Delete(ctx context.Context, id string) (string, error)
+	MakeFriends(ctx context.Context, sourceId, targetId string) (string, error)
+	Unfriend(ctx context.Context, id, friendID string) (string, error)
+	MakeID() string
+}
+
+type server struct {
+	userpb.UnimplementedUserServiceServer
+	repository Repository
+	logger     *logging.Logger
+}
+
+func NewServer(repository Repository, logger *logging.Logger) *server {
+	return &server{
+		repository: repository,
+		logger:     logger,
+	}
+}
+
+func (s *server) Register(g *grpc.Server) {
+	userpb.RegisterUserServiceServer(g, s)
+}
+
+// statusCodes - gRPC-коды вида ошибки репозитория, неизвестные ошибки - Internal
+var statusCodes = []struct {
+	kind error
+	code codes.Code
+}{
+	{models.ErrNotFound, codes.NotFound},
+	{models.ErrSelfFriendship, codes.InvalidArgument},
+	{models.ErrInvalidCursor, codes.InvalidArgument},
+	{models.ErrUserExists, codes.AlreadyExists},
+	{models.ErrAlreadyFriends, codes.AlreadyExists},
+	{models.ErrNotFriends, codes.FailedPrecondition},
+	{models.ErrNotBlocked, codes.FailedPrecondition},
+	{models.ErrRetentionExpired, codes.FailedPrecondition},
+	{models.ErrBlocked, codes.PermissionDenied},
+}
+
+// statusError переводит ошибку репозитория в gRPC-статус по ее виду.
+// Отмена и таймаут запроса сохраняют свои коды
+func statusError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	code := codes.Internal
+	for _, c := range statusCodes {
+		if errors.Is(err, c.kind) {
+			code = c.code
+			break
+		}
+	}
+	return status.Error(code, strings.TrimSpace(err.Error()))
+}
+
+func newUser(u *models.UserModel) *userpb.User {
+	return &userpb.User{
+		Id:        u.ID,
+		Name:      u.Name,
+		Age:       u.Age,
+		FriendIds: u.FriendIDs,
+	}
+}
+
+func newFriendship(f *models.Friendship) *userpb.Friendship {
+	meta := &userpb.Friendship{
+		Initiator: f.Initiator,
+		Label:     f.Label,
+		Closeness: f.Closeness,
+	}
+	if !f.CreatedAt.IsZero() {
+		meta.Since = timestamppb.New(f.CreatedAt)
+	}
+	return meta
+}
+
+func (s *server) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	if req.GetName() == "" || req.GetAge() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name and age are required")
+	}
+	u := &models.UserModel{ID: s.repository.MakeID(), Name: req.GetName(), Age: req.GetAge(), FriendIDs: []string{}}
+	if err := s.repository.Create(ctx, u); err != nil {
+		return nil, statusError(err)
+	}
+	return newUser(u), nil
+}
+
+func (s *server) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	u, err := s.repository.FindUser(ctx, req.GetId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newUser(u), nil
+}
+
+func (s *server) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if req.Age == nil {
+		return nil, status.Error(codes.InvalidArgument, "nothing to update")
+	}
+	if err := s.repository.UpdateAge(ctx, req.GetId(), req.GetAge()); err != nil {
+		return nil, statusError(err)
+	}
+	u, err := s.repository.FindUser(ctx, req.GetId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newUser(u), nil
+}
+
+func (s *server) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	text, err := s.repository.Delete(ctx, req.GetId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &userpb.DeleteUserResponse{Message: text}, nil
+}
+
+func (s *server) MakeFriends(ctx context.Context, req *userpb.MakeFriendsRequest) (*userpb.MakeFriendsResponse, error) {
+	if req.GetSourceId() == "" || req.GetTargetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "source_id and target_id are required")
+	}
+	text, err := s.repository.MakeFriends(ctx, req.GetSourceId(), req.GetTargetId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &userpb.MakeFriendsResponse{Message: text}, nil
+}
+
+func (s *server) Unfriend(ctx context.Context, req *userpb.UnfriendRequest) (*userpb.UnfriendResponse, error) {
+	if req.GetId() == "" || req.GetFriendId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id and friend_id are required")
+	}
+	text, err := s.repository.Unfriend(ctx, req.GetId(), req.GetFriendId())
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &userpb.UnfriendResponse{Message: text}, nil
+}
+
+// ListFriends читает друзей страницами по page_size и отправляет их по одному,
+// пока клиент не отменит поток или друзья не закончатся
+func (s *server) ListFriends(req *userpb.ListFriendsRequest, stream userpb.UserService_ListFriendsServer) error {
+	if req.GetId() == "" {
+		return status.Error(codes.InvalidArgument, "id is required")
+	}
+	q := models.FriendsQuery{Limit: int(req.GetPageSize()), Sort: models.SortBySince}
+	if q.Limit <= 0 {
+		q.Limit = defaultPageSize
+	}
+	switch req.GetSort() {
+	case "":
+	case models.SortByName, models.SortByAge, models.SortBySince:
+		q.Sort = req.GetSort()
+	default:
+		return status.Error(codes.InvalidArgument, "unknown sort "+req.GetSort())
+	}
+
+	ctx := stream.Context()
+	for {
+		page, err := s.repository.FindFriendsPage(ctx, req.GetId(), q)
+		if err != nil {
+			return statusError(err)
+		}
+		for _, f := range page.Friends {
+			friend := &userpb.Friend{User: newUser(f)}
+			if meta, ok := page.Friendships[f.ID]; ok {
+				friend.Friendship = newFriendship(meta)
+			}
+			if err = stream.Send(friend); err != nil {
+				return err
+			}
+		}
+		if page.Next == nil {
+			return nil
+		}
+		q.After = page.Next
+	}
+}
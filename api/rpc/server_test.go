@@ -0,0 +1,215 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/api/proto/userpb"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/user/db"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+func testClient(t *testing.T) userpb.UserServiceClient {
+	ctx := context.Background()
+	log := &logging.Logger{Logger: zerolog.Nop()}
+	repository := db.NewRepository(ctx, make(map[string]*models.UserModel), log)
+
+	listener := bufconn.Listen(1 << 20)
+	g := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryInterceptor(log)),
+		grpc.ChainStreamInterceptor(StreamInterceptor(log)),
+	)
+	NewServer(repository, log).Register(g)
+	go g.Serve(listener)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUserServiceClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t)
+
+	ids := make([]string, 0, 4)
+	for _, name := range []string{"John", "Nate", "Helen", "Anna"} {
+		u, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Name: name, Age: "20"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, u.Id)
+	}
+	for _, id := range ids[1:] {
+		if _, err := client.MakeFriends(ctx, &userpb.MakeFriendsRequest{SourceId: ids[0], TargetId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	age := "30"
+	u, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: ids[0], Age: &age})
+	if err != nil || u.Age != "30" || len(u.FriendIds) != 3 {
+		t.Fatalf("wrong updated user: %v, %v", u, err)
+	}
+
+	// поток читается страницами по 2, друзья приходят в порядке имени
+	stream, err := client.ListFriends(ctx, &userpb.ListFriendsRequest{Id: ids[0], Sort: models.SortByName, PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		f, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Friendship == nil || f.Friendship.Initiator != ids[0] || f.Friendship.Since == nil {
+			t.Errorf("wrong friendship: %v", f.Friendship)
+		}
+		names = append(names, f.User.Name)
+	}
+	if len(names) != 3 || names[0] != "Anna" || names[1] != "Helen" || names[2] != "Nate" {
+		t.Errorf("wrong friends: %v", names)
+	}
+
+	if _, err = client.Unfriend(ctx, &userpb.UnfriendRequest{Id: ids[0], FriendId: ids[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	u, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: ids[0]})
+	if err != nil || len(u.FriendIds) != 1 || u.FriendIds[0] != ids[3] {
+		t.Errorf("wrong friends after unfriend and delete: %v, %v", u, err)
+	}
+}
+
+func TestServer_StatusCodes(t *testing.T) {
+	ctx := context.Background()
+	client := testClient(t)
+
+	john, _ := client.CreateUser(ctx, &userpb.CreateUserRequest{Name: "John", Age: "24"})
+	nate, _ := client.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Nate", Age: "25"})
+	anna, _ := client.CreateUser(ctx, &userpb.CreateUserRequest{Name: "Anna", Age: "26"})
+	if _, err := client.MakeFriends(ctx, &userpb.MakeFriendsRequest{SourceId: john.Id, TargetId: anna.Id}); err != nil {
+		t.Fatal(err)
+	}
+	age := "30"
+
+	testTable := []struct {
+		name     string
+		call     func() error
+		expected codes.Code
+	}{
+		{"create without name", func() error {
+			_, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Age: "24"})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown user", func() error {
+			_, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: "42"})
+			return err
+		}, codes.NotFound},
+		{"update unknown user", func() error {
+			_, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: "42", Age: &age})
+			return err
+		}, codes.NotFound},
+		{"delete unknown user", func() error {
+			_, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: "42"})
+			return err
+		}, codes.NotFound},
+		{"befriend unknown user", func() error {
+			_, err := client.MakeFriends(ctx, &userpb.MakeFriendsRequest{SourceId: john.Id, TargetId: "42"})
+			return err
+		}, codes.NotFound},
+		{"befriend self", func() error {
+			_, err := client.MakeFriends(ctx, &userpb.MakeFriendsRequest{SourceId: john.Id, TargetId: john.Id})
+			return err
+		}, codes.InvalidArgument},
+		{"already friends", func() error {
+			_, err := client.MakeFriends(ctx, &userpb.MakeFriendsRequest{SourceId: anna.Id, TargetId: john.Id})
+			return err
+		}, codes.AlreadyExists},
+		{"unfriend unknown user", func() error {
+			_, err := client.Unfriend(ctx, &userpb.UnfriendRequest{Id: "42", FriendId: john.Id})
+			return err
+		}, codes.NotFound},
+		{"update without fields", func() error {
+			_, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: john.Id})
+			return err
+		}, codes.InvalidArgument},
+		{"not friends", func() error {
+			_, err := client.Unfriend(ctx, &userpb.UnfriendRequest{Id: john.Id, FriendId: nate.Id})
+			return err
+		}, codes.FailedPrecondition},
+		{"friends of unknown user", func() error {
+			stream, err := client.ListFriends(ctx, &userpb.ListFriendsRequest{Id: "42"})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.NotFound},
+		{"unknown sort", func() error {
+			stream, err := client.ListFriends(ctx, &userpb.ListFriendsRequest{Id: john.Id, Sort: "height"})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, test := range testTable {
+		if code := status.Code(test.call()); code != test.expected {
+			t.Errorf("%s: wrong code: got %v want %v", test.name, code, test.expected)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	testTable := []struct {
+		name     string
+		err      error
+		expected codes.Code
+	}{
+		{"not found", models.NewError(models.ErrNotFound, "Пользователь 42 не найден\n"), codes.NotFound},
+		{"self friendship", models.NewError(models.ErrSelfFriendship, "Пользователь 1 не может дружить сам с собой\n"), codes.InvalidArgument},
+		{"invalid cursor", models.ErrInvalidCursor, codes.InvalidArgument},
+		{"user exists", models.NewError(models.ErrUserExists, "пользователь с id 1 уже существует"), codes.AlreadyExists},
+		{"already friends", models.NewError(models.ErrAlreadyFriends, "Пользователи 1 2 уже друзья\n"), codes.AlreadyExists},
+		{"not friends", models.NewError(models.ErrNotFriends, "Пользователи 1 2 не друзья\n"), codes.FailedPrecondition},
+		{"not blocked", models.NewError(models.ErrNotBlocked, "Пользователь 2 не заблокирован\n"), codes.FailedPrecondition},
+		{"retention expired", fmt.Errorf("%w: пользователь 1 удален", models.ErrRetentionExpired), codes.FailedPrecondition},
+		{"blocked", fmt.Errorf("%w: 1, 2\n", models.ErrBlocked), codes.PermissionDenied},
+		{"database error", errors.New("can't find user: connection refused"), codes.Internal},
+		{"canceled", fmt.Errorf("can't find user: %w", context.Canceled), codes.Canceled},
+		{"deadline", fmt.Errorf("can't find user: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+	}
+	for _, test := range testTable {
+		err := statusError(test.err)
+		if code := status.Code(err); code != test.expected {
+			t.Errorf("%s: wrong code: got %v want %v", test.name, code, test.expected)
+		}
+	}
+	// текст ошибки доходит до клиента без перевода строки
+	if msg := status.Convert(statusError(models.NewError(models.ErrNotFound, "Пользователь 42 не найден\n"))).Message(); msg != "Пользователь 42 не найден" {
+		t.Errorf("wrong message: got %q", msg)
+	}
+}
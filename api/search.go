@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// parseSearchQuery разбирает ?q=&mode=prefix|contains|fuzzy&limit=&cursor=
+func parseSearchQuery(r *http.Request) (models.SearchQuery, error) {
+	values := r.URL.Query()
+	q := models.SearchQuery{Text: strings.TrimSpace(values.Get("q")), Mode: models.SearchPrefix, Limit: defaultSearchLimit}
+	if q.Text == "" {
+		return q, errors.New("q is required")
+	}
+
+	switch mode := values.Get("mode"); mode {
+	case "":
+	case models.SearchPrefix, models.SearchContains, models.SearchFuzzy:
+		q.Mode = mode
+	default:
+		return q, errors.New("unknown mode " + mode)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, errors.New("limit must be a positive number")
+		}
+		if n > maxSearchLimit {
+			n = maxSearchLimit
+		}
+		q.Limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := models.DecodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.After = after
+	}
+	return q, nil
+}
+
+type SearchResultResponse struct {
+	*UserResponse
+	Score float64 `json:"score"`
+}
+
+type SearchPageResponse struct {
+	Users      []*SearchResultResponse `json:"users"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+func NewSearchPageResponse(page *models.SearchPage) *SearchPageResponse {
+	resp := &SearchPageResponse{Users: make([]*SearchResultResponse, 0, len(page.Results))}
+	for _, r := range page.Results {
+		resp.Users = append(resp.Users, &SearchResultResponse{UserResponse: NewUserResponse(r.User), Score: r.Score})
+	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+	return resp
+}
+
+func (p *SearchPageResponse) CSVHeader() []string {
+	return append(csvHeader(userCSVFields), "score")
+}
+
+func (p *SearchPageResponse) CSVRows() [][]string {
+	rows := make([][]string, 0, len(p.Users))
+	for _, u := range p.Users {
+		rows = append(rows, append(u.CSVRow(userCSVFields), strconv.FormatFloat(u.Score, 'f', -1, 64)))
+	}
+	return rows
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecurityOptions - заголовки безопасности для браузеров
+type SecurityOptions struct {
+	// HSTS отдается только по HTTPS, 0 - не отдавать
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// X-Frame-Options: DENY или SAMEORIGIN, пусто - не отдавать
+	FrameOptions string
+	// прокси, которым доверяем X-Forwarded-Proto и X-Forwarded-For, от остальных они не учитываются
+	TrustedProxies []*net.IPNet
+}
+
+// ParseNetworks разбирает адреса и подсети вида 10.0.0.1 или 10.0.0.0/8
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", v)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// trusted - пришел ли запрос напрямую от доверенного прокси
+func trusted(r *http.Request, proxies []*net.IPNet) bool {
+	return trustedIP(remoteHost(r), proxies)
+}
+
+func trustedIP(host string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP - адрес клиента. За доверенным прокси берется из X-Forwarded-For: адреса
+// перебираются справа налево, пока их добавляют доверенные прокси. Левее первого
+// недоверенного адреса все мог прислать сам клиент
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	host := remoteHost(r)
+	if !trustedIP(host, proxies) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !trustedIP(ip, proxies) {
+			break
+		}
+	}
+	return host
+}
+
+// SecurityHeaders добавляет HSTS, X-Content-Type-Options и X-Frame-Options ко всем ответам
+func SecurityHeaders(opts SecurityOptions) func(http.Handler) http.Handler {
+	hsts := "max-age=" + strconv.Itoa(int(opts.HSTSMaxAge.Seconds()))
+	if opts.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			if opts.FrameOptions != "" {
+				header.Set("X-Frame-Options", opts.FrameOptions)
+			}
+			// по HTTP браузер HSTS игнорирует. X-Forwarded-Proto может прислать любой
+			// клиент, поэтому он учитывается только от доверенного прокси
+			https := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" && trusted(r, opts.TrustedProxies)
+			if opts.HSTSMaxAge > 0 && https {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
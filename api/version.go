@@ -0,0 +1,52 @@
+package api
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+// APIPrefix - префикс текущей версии API
+const APIPrefix = "/api/v1"
+
+type Registrar interface {
+	Register(router chi.Router)
+}
+
+// LegacyRegistrar - обработчик, у которого старые маршруты отличаются от маршрутов /api/v1
+type LegacyRegistrar interface {
+	RegisterLegacy(router chi.Router)
+}
+
+// Mount регистрирует обработчики под APIPrefix, а старые маршруты LegacyRegistrar - в корне.
+// Обработчики без старых маршрутов доступны только под APIPrefix.
+// Ответы на старые маршруты помечаются заголовками Deprecation и Sunset
+func Mount(router chi.Router, sunset time.Time, handlers ...Registrar) {
+	router.Route(APIPrefix, func(r chi.Router) {
+		for _, h := range handlers {
+			h.Register(r)
+		}
+	})
+	router.Group(func(r chi.Router) {
+		r.Use(Deprecated(sunset))
+		for _, h := range handlers {
+			if legacy, ok := h.(LegacyRegistrar); ok {
+				legacy.RegisterLegacy(r)
+			}
+		}
+	})
+}
+
+// Deprecated добавляет заголовки устаревшего маршрута (RFC 8594) и ссылку на описание новой версии
+func Deprecated(sunset time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Set("Link", "<"+APIPrefix+"/openapi.json>; rel=\"successor-version\"")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
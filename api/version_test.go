@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"github.com/ast3am/educationProject/api/mocks"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMount(t *testing.T) {
+	testTable := []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		expectedStatusCode int
+		deprecated         bool
+	}{
+		{"v1 make friends", "POST", "/api/v1/users/1/friends", `{"target_id":"2"}`, http.StatusOK, false},
+		{"legacy make friends", "POST", "/make_friends", `{"source_id":"1","target_id":"2"}`, http.StatusOK, true},
+		{"v1 update age", "PATCH", "/api/v1/users/1", `{"age":"25"}`, http.StatusOK, false},
+		{"v1 update without age", "PATCH", "/api/v1/users/1", `{}`, http.StatusBadRequest, false},
+		{"legacy update age", "PUT", "/1", `{"new_age":"25"}`, http.StatusOK, true},
+		{"v1 delete", "DELETE", "/api/v1/users/1", ``, http.StatusOK, false},
+		{"legacy delete", "DELETE", "/user", `{"target_id":"1"}`, http.StatusOK, true},
+		{"legacy route is not in v1", "POST", "/api/v1/make_friends", `{"source_id":"1","target_id":"2"}`, http.StatusNotFound, false},
+		{"v1 route is not legacy", "DELETE", "/users/1", ``, http.StatusMethodNotAllowed, false},
+		{"v1 spec", "GET", "/api/v1/openapi.json", ``, http.StatusOK, false},
+		// путь совпадает только со старым PUT /{id}
+		{"handler without legacy routes", "GET", "/openapi.json", ``, http.StatusMethodNotAllowed, false},
+	}
+
+	log := logging.GetLogger()
+	repository := mocks.NewRepository(t)
+	repository.
+		On("MakeFriends", mock.Anything, "1", "2").Return("пользователи 1 и 2 теперь друзья", nil).
+		On("UpdateAge", mock.Anything, "1", "25").Return(nil).
+		On("Delete", mock.Anything, "1").Return("John", nil)
+
+	sunset := time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)
+	router := chi.NewRouter()
+	Mount(router, sunset, NewHandler(repository, log), NewOpenAPIHandler(log))
+
+	for _, test := range testTable {
+		req := httptest.NewRequest(test.method, test.url, bytes.NewBufferString(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.name, w.Code, test.expectedStatusCode)
+		}
+		deprecated := w.Header().Get("Deprecation") == "true"
+		if deprecated != test.deprecated {
+			t.Errorf("%s: wrong Deprecation header: %q", test.name, w.Header().Get("Deprecation"))
+		}
+		if test.deprecated && w.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" {
+			t.Errorf("%s: wrong Sunset header: %q", test.name, w.Header().Get("Sunset"))
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+)
+
+type Webhooks interface {
+	Create(ctx context.Context, w *models.Webhook) error
+	List(ctx context.Context) ([]*models.Webhook, error)
+	Delete(ctx context.Context, id string) error
+	Deliveries(ctx context.Context, webhookID string) ([]*models.WebhookDelivery, error)
+	DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error)
+}
+
+// события, на которые можно подписаться
+var webhookEvents = map[string]bool{
+	models.EventUserCreated:       true,
+	models.EventUserDeleted:       true,
+	models.EventUserUpdated:       true,
+	models.EventFriendshipCreated: true,
+	models.EventFriendshipDeleted: true,
+}
+
+type webhooksHandler struct {
+	webhooks Webhooks
+	logger   *logging.Logger
+}
+
+func NewWebhooksHandler(webhooks Webhooks, logger *logging.Logger) *webhooksHandler {
+	return &webhooksHandler{
+		webhooks: webhooks,
+		logger:   logger,
+	}
+}
+
+func (h *webhooksHandler) Register(router chi.Router) {
+	router.Post("/webhooks", h.Create)
+	router.Get("/webhooks", h.List)
+	router.Get("/webhooks/dead-letters", h.DeadLetters)
+	router.Delete("/webhooks/{id}", h.Delete)
+	router.Get("/webhooks/{id}/deliveries", h.Deliveries)
+}
+
+func (h *webhooksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	hook := models.Webhook{}
+	if err := decodeJSON(r, &hook); err != nil {
+		writeRequestError(w, r, h.logger, err)
+		return
+	}
+	if err := validateWebhook(&hook); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusBadRequest, "", err)
+		return
+	}
+
+	if err := h.webhooks.Create(r.Context(), &hook); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+	// секрет в ответах не возвращается
+	hook.Secret = ""
+	writeResponse(w, r, h.logger, http.StatusCreated, hook, "Webhook created")
+}
+
+func validateWebhook(hook *models.Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https url")
+	}
+	for _, t := range hook.Events {
+		if !webhookEvents[t] {
+			return errors.New("unknown event type " + t)
+		}
+	}
+	return nil
+}
+
+func (h *webhooksHandler) List(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.webhooks.List(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+	writeResponse(w, r, h.logger, http.StatusOK, hooks, "Webhooks found")
+}
+
+func (h *webhooksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.webhooks.Delete(r.Context(), id); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	h.logger.HandlerLog(r, http.StatusNoContent, "Webhook deleted")
+}
+
+// Deliveries - журнал попыток доставки вебхука
+func (h *webhooksHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.webhooks.Deliveries(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeResponse(w, r, h.logger, http.StatusOK, deliveries, "Webhook deliveries found")
+}
+
+func (h *webhooksHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.webhooks.DeadLetters(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		h.logger.HandlerErrorLog(r, http.StatusInternalServerError, "", err)
+		return
+	}
+	writeResponse(w, r, h.logger, http.StatusOK, deliveries, "Dead letters found")
+}
+
+// writeError - 404 для неизвестного вебхука, 500 для сбоя хранилища
+func (h *webhooksHandler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, models.ErrWebhookNotFound) {
+		status = http.StatusNotFound
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(err.Error()))
+	h.logger.HandlerErrorLog(r, status, "", err)
+}
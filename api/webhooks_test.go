@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bytes"
+	"github.com/ast3am/educationProject/internal/webhooks"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhooksHandler_Create(t *testing.T) {
+	testTable := []struct {
+		name                string
+		inputBody           string
+		expectedStatusCode  int
+		expectedRequestBody string
+	}{
+		{
+			"positive",
+			`{"url":"http://partner.local/hook","events":["friendship.created"],"secret":"s3cret"}`,
+			http.StatusCreated,
+			`"url":"http://partner.local/hook","events":["friendship.created"],"created_at"`,
+		},
+		{
+			"relative url",
+			`{"url":"/hook"}`,
+			http.StatusBadRequest,
+			"url must be an absolute http or https url",
+		},
+		{
+			"unknown event",
+			`{"url":"https://partner.local/hook","events":["user.blocked"]}`,
+			http.StatusBadRequest,
+			"unknown event type user.blocked",
+		},
+	}
+
+	log := logging.GetLogger()
+	dispatcher := webhooks.NewDispatcher(webhooks.Config{MaxAttempts: 1, Timeout: time.Second}, webhooks.NewMemoryStore(time.Hour), log)
+	router := chi.NewRouter()
+	NewWebhooksHandler(dispatcher, log).Register(router)
+
+	for _, test := range testTable {
+		req := httptest.NewRequest("POST", "/webhooks", bytes.NewBufferString(test.inputBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.expectedStatusCode {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.name, w.Code, test.expectedStatusCode)
+		}
+		if !bytes.Contains(w.Body.Bytes(), []byte(test.expectedRequestBody)) || bytes.Contains(w.Body.Bytes(), []byte("s3cret")) {
+			t.Errorf("%s: handler returned unexpected body: got %v want %v",
+				test.name, w.Body.String(), test.expectedRequestBody)
+		}
+	}
+}
+
+func TestWebhooksHandler_NotFound(t *testing.T) {
+	log := logging.GetLogger()
+	dispatcher := webhooks.NewDispatcher(webhooks.Config{MaxAttempts: 1, Timeout: time.Second}, webhooks.NewMemoryStore(time.Hour), log)
+	router := chi.NewRouter()
+	NewWebhooksHandler(dispatcher, log).Register(router)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("DELETE", "/webhooks/42", nil),
+		httptest.NewRequest("GET", "/webhooks/42/deliveries", nil),
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound || w.Body.String() != "webhook 42 not found" {
+			t.Errorf("%s %s: got %d %q want %d", req.Method, req.URL, w.Code, w.Body.String(), http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/ast3am/educationProject/api"
+	"github.com/ast3am/educationProject/pkg/logging"
+)
+
+// check [--fix] - проверка списков друзей в коллекции
+func runCheck(ctx context.Context, checker api.Checker, log *logging.Logger, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "repair found inconsistencies")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := checker.Check(ctx, *fix)
+	if err != nil {
+		log.Err(err).Msg("consistency check failed")
+		return 1
+	}
+
+	for _, issue := range report.Issues {
+		log.Warn().
+			Str("type", issue.Type).
+			Str("user", issue.UserID).
+			Str("friend", issue.FriendID).
+			Int("count", issue.Count).
+			Bool("fixed", issue.Fixed).
+			Msg("inconsistency")
+	}
+	log.Info().Int("users", report.Users).Int("issues", len(report.Issues)).Int("fixed", report.Fixed).Msg("consistency check finished")
+
+	if len(report.Issues) > report.Fixed {
+		return 1
+	}
+	return 0
+}
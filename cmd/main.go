@@ -2,37 +2,223 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"errors"
 	"github.com/ast3am/educationProject/api"
+	"github.com/ast3am/educationProject/api/rpc"
+	"github.com/ast3am/educationProject/internal/audit"
+	"github.com/ast3am/educationProject/internal/certs"
+	"github.com/ast3am/educationProject/internal/config"
+	"github.com/ast3am/educationProject/internal/events"
+	"github.com/ast3am/educationProject/internal/graph"
+	"github.com/ast3am/educationProject/internal/migrations"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/outbox"
+	"github.com/ast3am/educationProject/internal/ratelimit"
+	"github.com/ast3am/educationProject/internal/retention"
 	"github.com/ast3am/educationProject/internal/user/db"
+	"github.com/ast3am/educationProject/internal/webhooks"
 	"github.com/ast3am/educationProject/pkg/logging"
 	"github.com/ast3am/educationProject/pkg/mongodb"
 	"github.com/go-chi/chi/v5"
-	"github.com/rs/zerolog/log"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
 	"net/http"
+	"os"
 	"time"
 )
 
+type storage interface {
+	api.Repository
+	api.Checker
+	retention.Repository
+	EnableOutbox(collection string)
+	RequireTransactions(ctx context.Context, allow bool) error
+	EnsureSchema(ctx context.Context, action string) (*models.SchemaReport, error)
+	Migrations() []migrations.Migration
+}
+
+// startupTimeout - время на подготовку коллекций при запуске
+const startupTimeout = 5 * time.Second
+
 func main() {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
-	defer func() {
-		log.Info().Msg("canceling context")
-		cancel()
-	}()
 	log := logging.GetLogger()
 	log.Info().Msg("started")
+	cfg := config.GetConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("invalid configuration")
+	}
 	router := chi.NewRouter()
 	//resultMap := make(map[string]*user.UserModel)
 	//repository := db.NewRepository(ctx, resultMap, log)
-	mongoDB, err := mongodb.NewClient(ctx, "localhost", "27017", "SomeBase")
+	// повторы подключения занимают больше таймаута запуска, он отсчитывается после них
+	mongoDB, err := mongodb.NewClient(context.Background(), mongoOptions(cfg), log)
 	if err != nil {
-		fmt.Println("error")
+		log.Fatal().Err(err).Msg("can't connect to mongodb")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer func() {
+		log.Info().Msg("canceling context")
+		cancel()
+	}()
+	if len(os.Args) > 1 && os.Args[1] == "migrate-friends" {
+		code := runMigrateFriends(context.Background(), mongoDB, cfg, log, os.Args[2:])
+		cancel()
+		os.Exit(code)
 	}
-	mongoRepository := db.NewMongoRepository(mongoDB, "1", log)
-	handler := api.NewHandler(mongoRepository, log)
-	handler.Register(router)
-	start(router)
+
+	var mongoRepository storage
+	switch cfg.Mongo.FriendsLayout {
+	case "edges":
+		mongoRepository, err = db.NewMongoEdgeRepository(ctx, mongoDB, cfg.Mongo.Collection, cfg.Mongo.EdgesCollection, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't create edge repository")
+		}
+	default:
+		mongoRepository = db.NewMongoRepository(mongoDB, cfg.Mongo.Collection, log)
+	}
+	log.Info().Msgf("friends layout: %s", cfg.Mongo.FriendsLayout)
+	// события пишутся в outbox вместе с данными, в шину их передает relay
+	mongoRepository.EnableOutbox(cfg.Outbox.Collection)
+	// без транзакций событие в outbox может разойтись с изменением данных
+	err = mongoRepository.RequireTransactions(ctx, cfg.Mongo.AllowNoTransactions)
+	if errors.Is(err, db.ErrNoTransactions) {
+		log.Fatal().Err(err).Msg("run mongodb as a replica set or set MONGO_ALLOW_NO_TRANSACTIONS=true")
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't check mongodb transactions")
+	}
+	migrator, err := migrations.NewMigrator(db.NewMigrationState(mongoDB, cfg.Mongo.MigrationsCollection),
+		mongoRepository.Migrations(), cfg.Mongo.MigrationsLockTTL, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't create migrator")
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		code := runMigrate(context.Background(), migrator, log, os.Args[2:])
+		cancel()
+		os.Exit(code)
+	}
+	if cfg.Mongo.MigrateOnStart {
+		if err = migrateOnStart(context.Background(), migrator, log); err != nil {
+			log.Fatal().Err(err).Msg("can't migrate user data")
+		}
+	}
+	// миграции могут идти дольше таймаута, следующие шаги получают его заново
+	cancel()
+	ctx, cancel = context.WithTimeout(context.Background(), startupTimeout)
+	schema, err := mongoRepository.EnsureSchema(ctx, cfg.Mongo.ValidationAction)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't prepare user collection")
+	}
+	log.Info().Str("validation_action", schema.ValidationAction).Msg("user schema validator installed")
+	for _, index := range schema.Indexes {
+		log.Info().Str("collection", index.Collection).Str("index", index.Name).
+			Strs("keys", index.Keys).Bool("unique", index.Unique).Msg("index ready")
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		code := runCheck(context.Background(), mongoRepository, log, os.Args[2:])
+		cancel()
+		os.Exit(code)
+	}
+
+	var auditLog api.AuditLog
+	switch cfg.Audit.Store {
+	case "file":
+		auditLog, err = audit.NewFileStore(cfg.Audit.File)
+	default:
+		auditLog, err = audit.NewMongoStore(ctx, mongoDB, cfg.Audit.Collection)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't create audit log")
+	}
+	router.Use(middleware.RequestID, api.Compress(cfg.API.CompressMinSize), api.Actor, api.ClientCert,
+		api.BodyLimit(cfg.API.MaxBodySize))
+	trustedProxies, err := api.ParseNetworks(cfg.Security.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid SECURITY_TRUSTED_PROXIES")
+	}
+	limiter, err := newRateLimiter(router, cfg, trustedProxies, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't create rate limiter")
+	}
+	router.Use(limiter.Middleware)
+	if cfg.OpenAPI.Validate {
+		validator, err := api.NewValidator(log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("can't create request validator")
+		}
+		router.Use(validator.Middleware)
+	}
+
+	bus := events.NewBus(cfg.Events.History, cfg.Events.Buffer)
+	repository := api.NewAuditRepository(mongoRepository, auditLog, log)
+	handler := api.NewHandler(repository, log)
+	corsOptions := api.CORSOptions{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+	}
+	// WebSocket не подчиняется CORS, источники подключений проверяются по тому же списку
+	checkOrigin := api.CheckOrigin(corsOptions)
+	eventsHandler := api.NewEventsHandler(bus, cfg.Events.Heartbeat, checkOrigin, log)
+	webhookStore, err := webhooks.NewMongoStore(ctx, mongoDB, cfg.Webhooks.Collection, cfg.Webhooks.DeliveriesCollection,
+		cfg.Webhooks.HistoryRetention)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't create webhook store")
+	}
+	dispatcher := webhooks.NewDispatcher(webhooks.Config{
+		MaxAttempts: cfg.Webhooks.MaxAttempts,
+		Backoff:     cfg.Webhooks.Backoff,
+		Timeout:     cfg.Webhooks.Timeout,
+		Workers:     cfg.Webhooks.Workers,
+	}, webhookStore, log)
+	webhooksHandler := api.NewWebhooksHandler(dispatcher, log)
+
+	relay, err := outbox.NewRelay(ctx, mongoDB, cfg.Outbox.Collection, cfg.Outbox.Retention, cfg.Outbox.PollInterval,
+		cfg.Outbox.Lease, cfg.Outbox.Batch, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't create outbox relay")
+	}
+	// шина у каждого экземпляра своя, событие получают подписчики всех экземпляров
+	relay.AddLocalConsumer("events", func(ctx context.Context, e *models.Event) error {
+		bus.Publish(e)
+		return nil
+	})
+	// вебхук отправляет один экземпляр и отмечает доставленным только после всех попыток
+	relay.AddConsumer("webhooks", dispatcher.Handle)
+	outboxHandler := api.NewOutboxHandler(relay, log)
+	go relay.Run(context.Background())
+	auditHandler := api.NewAuditHandler(auditLog, log)
+	graphStats := graph.NewService(mongoRepository, cfg.Graph.CacheTTL, cfg.Graph.RefreshMinAge, cfg.Graph.TopN)
+	graphHandler := api.NewGraphHandler(graphStats, log)
+	adminHandler := api.NewAdminHandler(mongoRepository, log)
+	purger := retention.NewService(mongoRepository, cfg.User.Retention, cfg.User.PurgeInterval, log)
+	retentionHandler := api.NewRetentionHandler(purger, log)
+	openAPIHandler := api.NewOpenAPIHandler(log)
+	graphQLHandler := api.NewGraphQLHandler(repository, bus, cfg.Events.Heartbeat, checkOrigin, log)
+	api.Mount(router, cfg.API.LegacySunset, handler, eventsHandler, webhooksHandler, outboxHandler,
+		auditHandler, graphHandler, adminHandler, retentionHandler, openAPIHandler, graphQLHandler)
+	go purger.Run(context.Background())
+	tlsConfig, err := newTLSConfig(cfg, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't load TLS certificate")
+	}
+	go startGRPC(rpc.NewServer(repository, log), cfg.GRPCListen, tlsConfig, log)
+	start(router, cfg.Listen, tlsConfig, chi.Chain(
+		api.SecurityHeaders(api.SecurityOptions{
+			HSTSMaxAge:            cfg.Security.HSTSMaxAge,
+			HSTSIncludeSubdomains: cfg.Security.HSTSIncludeSubdomains,
+			FrameOptions:          cfg.Security.FrameOptions,
+			TrustedProxies:        trustedProxies,
+		}),
+		api.CORS(corsOptions),
+	))
 
 }
 
@@ -40,10 +226,92 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Hello, my http service is running"))
 }
 
-func start(r chi.Router) {
+// start оборачивает маршрутизатор в middlewares, которые должны срабатывать
+// до поиска маршрута, как ответ на preflight CORS. С tlsConfig сервер работает по HTTPS
+func start(r chi.Router, addr string, tlsConfig *tls.Config, middlewares chi.Middlewares) {
 	r.Get("/", IndexHandler)
-	err := http.ListenAndServe(":8080", r)
+	srv := &http.Server{Addr: addr, Handler: middlewares.Handler(r), TLSConfig: tlsConfig}
+	var err error
+	if tlsConfig != nil {
+		// сертификат берется из tlsConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil {
 		panic(err)
 	}
 }
+
+// newRateLimiter собирает правила ограничения частоты запросов из конфигурации
+func newRateLimiter(router chi.Routes, cfg *config.Config, trustedProxies []*net.IPNet, log *logging.Logger) (*api.RateLimiter, error) {
+	def, err := ratelimit.ParseRule(cfg.RateLimit.Default)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]ratelimit.Rule, len(cfg.RateLimit.Routes))
+	for route, v := range cfg.RateLimit.Routes {
+		if rules[route], err = ratelimit.ParseRule(v); err != nil {
+			return nil, err
+		}
+	}
+	return api.NewRateLimiter(router, ratelimit.NewStore(), def, rules, cfg.RateLimit.APIKeys, trustedProxies, log), nil
+}
+
+func mongoOptions(cfg *config.Config) mongodb.Options {
+	return mongodb.Options{
+		URI:                    cfg.Mongo.URI,
+		Host:                   cfg.Mongo.Host,
+		Port:                   cfg.Mongo.Port,
+		Database:               cfg.Mongo.Database,
+		Username:               cfg.Mongo.Username,
+		Password:               cfg.Mongo.Password,
+		AuthSource:             cfg.Mongo.AuthSource,
+		ReplicaSet:             cfg.Mongo.ReplicaSet,
+		TLS:                    cfg.Mongo.TLS,
+		TLSCAFile:              cfg.Mongo.TLSCAFile,
+		MinPoolSize:            cfg.Mongo.MinPoolSize,
+		MaxPoolSize:            cfg.Mongo.MaxPoolSize,
+		ConnectTimeout:         cfg.Mongo.ConnectTimeout,
+		ServerSelectionTimeout: cfg.Mongo.ServerSelectionTimeout,
+		SocketTimeout:          cfg.Mongo.SocketTimeout,
+		AppName:                cfg.Mongo.AppName,
+		ConnectAttempts:        cfg.Mongo.ConnectAttempts,
+		ConnectBackoff:         cfg.Mongo.ConnectBackoff,
+	}
+}
+
+// newTLSConfig - nil без сертификата, иначе настройки с перечитыванием файлов
+func newTLSConfig(cfg *config.Config, log *logging.Logger) (*tls.Config, error) {
+	if cfg.TLS.CertFile == "" && cfg.TLS.KeyFile == "" {
+		return nil, nil
+	}
+	reloader, err := certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, cfg.TLS.ReloadInterval, log)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Run(context.Background())
+	return reloader.Config(cfg.TLS.ClientAuthOptional), nil
+}
+
+// startGRPC запускает gRPC-сервер рядом с HTTP, аудит и логирование общие.
+// С tlsConfig gRPC использует те же сертификат и mTLS, что и HTTPS
+func startGRPC(srv interface{ Register(g *grpc.Server) }, addr string, tlsConfig *tls.Config, log *logging.Logger) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("can't listen for gRPC")
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(rpc.UnaryInterceptor(log)),
+		grpc.ChainStreamInterceptor(rpc.StreamInterceptor(log)),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	g := grpc.NewServer(opts...)
+	srv.Register(g)
+	log.Info().Msgf("gRPC listening on %s", addr)
+	if err = g.Serve(listener); err != nil {
+		log.Fatal().Err(err).Msg("gRPC server stopped")
+	}
+}
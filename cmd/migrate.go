@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"github.com/ast3am/educationProject/internal/config"
+	"github.com/ast3am/educationProject/internal/migrations"
+	"github.com/ast3am/educationProject/internal/user/db"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strconv"
+)
+
+// migrate-friends [--drop-arrays] - перенос друзей из массивов в коллекцию связей
+func runMigrateFriends(ctx context.Context, database *mongo.Database, cfg *config.Config, log *logging.Logger, args []string) int {
+	flags := flag.NewFlagSet("migrate-friends", flag.ContinueOnError)
+	drop := flags.Bool("drop-arrays", false, "remove friends arrays from user documents after migration")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	repository, err := db.NewMongoEdgeRepository(ctx, database, cfg.Mongo.Collection, cfg.Mongo.EdgesCollection, log)
+	if err != nil {
+		log.Err(err).Msg("can't create edge repository")
+		return 1
+	}
+	if _, err = repository.MigrateFromArrays(ctx, *drop); err != nil {
+		log.Err(err).Msg("migration failed")
+		return 1
+	}
+	return 0
+}
+
+// migrate status | up [version] | down [version] - версионированные миграции данных.
+// up без версии применяет все, down без версии откатывает последнюю
+func runMigrate(ctx context.Context, migrator *migrations.Migrator, log *logging.Logger, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	action := flags.Arg(0)
+	target := 0
+	if action == "down" {
+		target = -1
+	}
+	if flags.NArg() > 1 {
+		v, err := strconv.Atoi(flags.Arg(1))
+		if err != nil || v < 0 {
+			log.Error().Str("version", flags.Arg(1)).Msg("version must be a non-negative number")
+			return 2
+		}
+		target = v
+	}
+
+	var err error
+	switch action {
+	case "status":
+		var statuses []migrations.Status
+		statuses, err = migrator.Status(ctx)
+		for _, s := range statuses {
+			log.Info().
+				Int("version", s.Version).
+				Str("description", s.Description).
+				Bool("applied", s.Applied).
+				Time("applied_at", s.AppliedAt).
+				Bool("known", s.Known).
+				Msg("migration")
+		}
+	case "up":
+		var done []int
+		done, err = migrator.Up(ctx, target)
+		log.Info().Ints("versions", done).Msg("migrations applied")
+	case "down":
+		var done []int
+		done, err = migrator.Down(ctx, target)
+		log.Info().Ints("versions", done).Msg("migrations rolled back")
+	default:
+		log.Error().Str("action", action).Msg("usage: migrate status | up [version] | down [version]")
+		return 2
+	}
+	if err != nil {
+		log.Err(err).Msg("migrate failed")
+		return 1
+	}
+	return 0
+}
+
+// migrateOnStart применяет новые миграции. Если их уже выполняет другой
+// экземпляр, сервис стартует без ожидания
+func migrateOnStart(ctx context.Context, migrator *migrations.Migrator, log *logging.Logger) error {
+	done, err := migrator.Up(ctx, 0)
+	if errors.Is(err, migrations.ErrLocked) {
+		log.Warn().Msg("migrations are run by another instance")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) > 0 {
+		log.Info().Ints("versions", done).Msg("migrations applied")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"os"
+	"sync"
+)
+
+// fileStore дописывает записи в файл по одной JSON-строке, файл никогда не перезаписывается
+type fileStore struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileStore(path string) (*fileStore, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit file: %w", err)
+	}
+	return &fileStore{path: path, file: file}, nil
+}
+
+func (s *fileStore) Append(ctx context.Context, e *models.AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("can't encode audit entry: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("can't write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Find читает файл целиком, новые записи возвращаются первыми
+func (s *fileStore) Find(ctx context.Context, f models.AuditFilter) ([]*models.AuditEntry, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit file: %w", err)
+	}
+	defer file.Close()
+
+	entries := make([]*models.AuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		e := &models.AuditEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("can't decode audit entry: %w", err)
+		}
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read audit file: %w", err)
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+	return entries, nil
+}
+
+func (s *fileStore) Close() error {
+	return s.file.Close()
+}
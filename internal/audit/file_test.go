@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStore_Find(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []*models.AuditEntry{
+		{Time: start, Actor: "admin", Action: models.AuditCreate, Targets: []string{"1"}},
+		{Time: start.Add(time.Hour), Actor: "admin", Action: models.AuditCreate, Targets: []string{"2"}},
+		{Time: start.Add(2 * time.Hour), Actor: "1", Action: models.AuditMakeFriends, Targets: []string{"1", "2"}},
+		{Time: start.Add(3 * time.Hour), Actor: "admin", Action: models.AuditDelete, Targets: []string{"2"}},
+	}
+
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries[:2] {
+		if err = s.Append(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	// повторное открытие дописывает в конец
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, e := range entries[2:] {
+		if err = s.Append(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testTable := []struct {
+		name     string
+		filter   models.AuditFilter
+		expected []string
+	}{
+		{"all", models.AuditFilter{}, []string{"delete", "make_friends", "create", "create"}},
+		{"actor", models.AuditFilter{UserID: "1"}, []string{"make_friends", "create"}},
+		{"target", models.AuditFilter{UserID: "2"}, []string{"delete", "make_friends", "create"}},
+		{"period", models.AuditFilter{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, []string{"make_friends", "create"}},
+		{"limit", models.AuditFilter{Limit: 1}, []string{"delete"}},
+	}
+	for _, test := range testTable {
+		found, err := s.Find(ctx, test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions := make([]string, 0)
+		for _, e := range found {
+			actions = append(actions, e.Action)
+		}
+		if !reflect.DeepEqual(actions, test.expected) {
+			t.Errorf("%s: wrong entries: got %v want %v", test.name, actions, test.expected)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoStore(ctx context.Context, database *mongo.Database, collection string) (*mongoStore, error) {
+	s := &mongoStore{collection: database.Collection(collection)}
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "targets", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create audit indexes: %w", err)
+	}
+	return s, nil
+}
+
+func (s *mongoStore) Append(ctx context.Context, e *models.AuditEntry) error {
+	if _, err := s.collection.InsertOne(ctx, e); err != nil {
+		return fmt.Errorf("can't insert audit entry: %w", err)
+	}
+	return nil
+}
+
+func (s *mongoStore) Find(ctx context.Context, f models.AuditFilter) ([]*models.AuditEntry, error) {
+	filter := bson.M{}
+	if f.UserID != "" {
+		filter["$or"] = bson.A{bson.M{"actor": f.UserID}, bson.M{"targets": f.UserID}}
+	}
+	period := bson.M{}
+	if !f.From.IsZero() {
+		period["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		period["$lt"] = f.To
+	}
+	if len(period) > 0 {
+		filter["time"] = period
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetProjection(bson.M{"_id": 0})
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't find audit entries: %w", err)
+	}
+	entries := make([]*models.AuditEntry, 0)
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("can't decode audit entries: %w", err)
+	}
+	return entries, nil
+}
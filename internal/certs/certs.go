@@ -0,0 +1,186 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader держит сертификат сервера и CA клиентов и перечитывает их,
+// когда файлы меняются. Если новые файлы не читаются, остаются прежние
+type Reloader struct {
+	certFile string
+	keyFile  string
+	// caFile - CA для проверки клиентских сертификатов, пусто - без mTLS
+	caFile   string
+	interval time.Duration
+	logger   *logging.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	clients *x509.CertPool
+	stamps  map[string]stamp
+}
+
+// stamp - по времени изменения и размеру видно, что файл заменили
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewReloader(certFile, keyFile, caFile string, interval time.Duration, logger *logging.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: interval,
+		logger:   logger,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *Reloader) stat() (map[string]stamp, error) {
+	stamps := make(map[string]stamp)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("can't stat %s: %w", file, err)
+		}
+		stamps[file] = stamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// Reload читает сертификат, ключ и CA и подменяет их для новых соединений
+func (r *Reloader) Reload() error {
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("can't load certificate: %w", err)
+	}
+	var clients *x509.CertPool
+	if r.caFile != "" {
+		if clients, err = LoadCAs(r.caFile); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clients, r.stamps = &cert, clients, stamps
+	return nil
+}
+
+// changed - какой-то из файлов заменили после последней загрузки
+func (r *Reloader) changed() bool {
+	stamps, err := r.stat()
+	if err != nil {
+		// файл могут заменять прямо сейчас, проверим в следующий раз
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for file, s := range stamps {
+		if r.stamps[file] != s {
+			return true
+		}
+	}
+	return false
+}
+
+// Run проверяет файлы каждые interval до отмены ctx, interval 0 - файлы
+// не перечитываются и Run сразу возвращается
+func (r *Reloader) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			r.logger.Err(err).Msg("certificate reload failed, keeping previous certificate")
+			continue
+		}
+		r.logger.Info().Str("cert", r.certFile).Msg("certificate reloaded")
+	}
+}
+
+// Certificate - текущий сертификат сервера
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// Config - настройки TLS, которые на каждом рукопожатии берут текущие
+// сертификат и CA. С CA клиент обязан предъявить сертификат, если optional -
+// проверяется только предъявленный
+func (r *Reloader) Config(optional bool) *tls.Config {
+	// http.Server не заполняет NextProtos в конфиге для конкретного клиента
+	nextProtos := []string{"h2", "http/1.1"}
+	base := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: nextProtos}
+	// без GetCertificate http.Server ищет сертификат в файлах
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		cfg := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   nextProtos,
+			Certificates: []tls.Certificate{*r.cert},
+		}
+		if r.clients != nil {
+			cfg.ClientCAs = r.clients
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			if optional {
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+		}
+		return cfg, nil
+	}
+	return base
+}
+
+// LoadCAs читает PEM-файл с одним или несколькими сертификатами CA
+func LoadCAs(file string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("can't read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.New("no certificates in CA file " + file)
+	}
+	return pool, nil
+}
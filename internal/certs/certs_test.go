@@ -0,0 +1,237 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type issued struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	// keyPEM - закрытый ключ в PEM
+	keyPEM []byte
+}
+
+// issue выпускает сертификат, подписанный parent, без parent - самоподписанный CA
+func issue(t *testing.T, serial int64, name string, parent *issued, usage x509.ExtKeyUsage) *issued {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"educationProject"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &issued{
+		cert:   cert,
+		key:    key,
+		pem:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, 1, "test CA", nil, 0)
+	server := issue(t, 2, "server", ca, x509.ExtKeyUsageServerAuth)
+	client := issue(t, 3, "billing", ca, x509.ExtKeyUsageClientAuth)
+	stranger := issue(t, 4, "stranger", issue(t, 5, "other CA", nil, 0), x509.ExtKeyUsageClientAuth)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	past := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, server.pem, past)
+	writeFile(t, keyFile, server.keyPEM, past)
+	writeFile(t, caFile, ca.pem, past)
+
+	reloader, err := NewReloader(certFile, keyFile, caFile, 10*time.Millisecond, logging.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Run(ctx)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = reloader.Config(false)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCert *issued) (*http.Response, error) {
+		cfg := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			cfg.Certificates = []tls.Certificate{{
+				Certificate: [][]byte{clientCert.cert.Raw},
+				PrivateKey:  clientCert.key,
+			}}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		return c.Get(srv.URL)
+	}
+
+	resp, err := get(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "billing" {
+		t.Errorf("wrong client: got %q want billing", body)
+	}
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber; serial.Int64() != 2 {
+		t.Errorf("wrong server certificate: got serial %v want 2", serial)
+	}
+
+	if _, err = get(nil); err == nil {
+		t.Errorf("client without certificate is accepted")
+	}
+	if _, err = get(stranger); err == nil {
+		t.Errorf("client certificate from other CA is accepted")
+	}
+
+	// битый сертификат не заменяет рабочий
+	writeFile(t, certFile, []byte("garbage"), time.Now())
+	time.Sleep(50 * time.Millisecond)
+	if !bytes.Equal(reloader.Certificate().Certificate[0], server.cert.Raw) {
+		t.Errorf("broken certificate replaced the working one")
+	}
+
+	renewed := issue(t, 6, "server", ca, x509.ExtKeyUsageServerAuth)
+	now := time.Now().Add(time.Second)
+	writeFile(t, keyFile, renewed.keyPEM, now)
+	writeFile(t, certFile, renewed.pem, now)
+	deadline := time.Now().Add(time.Second)
+	for !bytes.Equal(reloader.Certificate().Certificate[0], renewed.cert.Raw) {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate is not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err = get(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if serial := resp.TLS.PeerCertificates[0].SerialNumber; serial.Int64() != 6 {
+		t.Errorf("wrong server certificate: got serial %v want 6", serial)
+	}
+}
+
+func TestReloader_Optional(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, 1, "test CA", nil, 0)
+	server := issue(t, 2, "server", ca, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, server.pem, time.Now())
+	writeFile(t, keyFile, server.keyPEM, time.Now())
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	reloader, err := NewReloader(certFile, keyFile, caFile, time.Minute, logging.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = reloader.Config(true)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	if _, err = NewReloader(certFile, keyFile, filepath.Join(dir, "missing.crt"), time.Minute, logging.GetLogger()); err == nil {
+		t.Errorf("missing CA file is accepted")
+	}
+	writeFile(t, caFile, []byte("no certificates"), time.Now())
+	_, err = NewReloader(certFile, keyFile, caFile, time.Minute, logging.GetLogger())
+	if expected := "no certificates in CA file " + caFile; err == nil || err.Error() != expected {
+		t.Errorf("got error %v want %q", err, expected)
+	}
+}
+
+func TestReloader_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, 1, "test CA", nil, 0)
+	server := issue(t, 2, "server", ca, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, certFile, server.pem, time.Now())
+	writeFile(t, keyFile, server.keyPEM, time.Now())
+
+	reloader, err := NewReloader(certFile, keyFile, "", 0, logging.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// с интервалом 0 Run не проверяет файлы и сразу возвращается
+	done := make(chan struct{})
+	go func() {
+		reloader.Run(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run with zero interval does not return")
+	}
+}
@@ -0,0 +1,334 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config собирается из переменных окружения, для всех параметров есть значения по умолчанию
+type Config struct {
+	Listen     string
+	GRPCListen string
+	Mongo      struct {
+		// строка подключения целиком, поля ниже ее дополняют и переопределяют
+		URI        string
+		Host       string
+		Port       string
+		Database   string
+		Collection string
+		Username   string
+		Password   string
+		AuthSource string
+		ReplicaSet string
+		TLS        bool
+		TLSCAFile  string
+		// 0 - значения драйвера по умолчанию
+		MinPoolSize            uint64
+		MaxPoolSize            uint64
+		ConnectTimeout         time.Duration
+		ServerSelectionTimeout time.Duration
+		SocketTimeout          time.Duration
+		AppName                string
+		// повторы проверки соединения при старте, пауза удваивается
+		ConnectAttempts int
+		ConnectBackoff  time.Duration
+		// array - друзья массивом в документе пользователя, edges - отдельная коллекция связей
+		FriendsLayout   string
+		EdgesCollection string
+		// error - документ не по схеме отклоняется, warn - только пишется в лог MongoDB
+		ValidationAction string
+		// состояние и блокировка миграций данных, при старте применяются все новые
+		MigrationsCollection string
+		MigrateOnStart       bool
+		MigrationsLockTTL    time.Duration
+		// разрешить сервер без транзакций: события и блокировки пишутся не атомарно с данными
+		AllowNoTransactions bool
+	}
+	Graph struct {
+		// 0 - без кэша, отрицательное значение - кэш до ручного сброса
+		CacheTTL time.Duration
+		// ?refresh=true пересчитывает статистику не чаще
+		RefreshMinAge time.Duration
+		TopN          int
+	}
+	User struct {
+		// сколько удаленный пользователь хранится до окончательного удаления
+		Retention     time.Duration
+		PurgeInterval time.Duration
+	}
+	Audit struct {
+		// mongo - коллекция Collection, file - файл File с записями по одной на строку
+		Store      string
+		Collection string
+		File       string
+	}
+	Events struct {
+		// сколько последних событий хранится для продолжения по Last-Event-ID
+		History   int
+		Buffer    int
+		Heartbeat time.Duration
+	}
+	Webhooks struct {
+		MaxAttempts int
+		// пауза перед повтором, удваивается с каждой попыткой
+		Backoff time.Duration
+		Timeout time.Duration
+		Workers int
+		// подписки и журнал доставок, законченные доставки хранятся HistoryRetention
+		Collection           string
+		DeliveriesCollection string
+		HistoryRetention     time.Duration
+	}
+	Outbox struct {
+		Collection   string
+		PollInterval time.Duration
+		Batch        int
+		// на сколько экземпляр захватывает событие общего потребителя, должно быть
+		// больше времени всех попыток доставки вебхука
+		Lease time.Duration
+		// записи старше Retention удаляются, даже если кто-то их не получил
+		Retention time.Duration
+	}
+	API struct {
+		// дата отключения маршрутов без /api/v1, отдается в заголовке Sunset
+		LegacySunset time.Time
+		// предел тела запроса в байтах, больший запрос получает 413
+		MaxBodySize int64
+		// ответы меньше этого размера в байтах отдаются без сжатия
+		CompressMinSize int
+	}
+	OpenAPI struct {
+		// проверять тела запросов по схемам из openapi.json
+		Validate bool
+	}
+	TLS struct {
+		// без сертификата и ключа сервер работает по HTTP
+		CertFile string
+		KeyFile  string
+		// CA клиентских сертификатов, пусто - без mTLS
+		ClientCAFile string
+		// проверять сертификат клиента, только если он предъявлен
+		ClientAuthOptional bool
+		// как часто проверять, не заменили ли файлы
+		ReloadInterval time.Duration
+	}
+	CORS struct {
+		// пустой список источников отключает CORS
+		AllowedOrigins   []string
+		AllowedMethods   []string
+		AllowedHeaders   []string
+		ExposedHeaders   []string
+		AllowCredentials bool
+		MaxAge           time.Duration
+	}
+	Security struct {
+		HSTSMaxAge            time.Duration
+		HSTSIncludeSubdomains bool
+		FrameOptions          string
+		// адреса и подсети прокси, которым доверяем X-Forwarded-Proto и X-Forwarded-For
+		TrustedProxies []string
+	}
+	RateLimit struct {
+		// правила вида 10/1m, 0/1m - без ограничения
+		Default string
+		// правила отдельных маршрутов: "POST /api/v1/users" -> "30/1m"
+		Routes map[string]string
+		// ключи X-API-Key с собственной корзиной, остальные клиенты считаются по адресу
+		APIKeys []string
+	}
+}
+
+func GetConfig() *Config {
+	cfg := &Config{}
+	cfg.Listen = getString("HTTP_LISTEN", ":8080")
+	cfg.GRPCListen = getString("GRPC_LISTEN", ":9090")
+	cfg.Mongo.URI = getString("MONGO_URI", "")
+	cfg.Mongo.Host = getString("MONGO_HOST", "localhost")
+	cfg.Mongo.Port = getString("MONGO_PORT", "27017")
+	// с MONGO_URI база по умолчанию берется из его пути
+	defaultDatabase := "SomeBase"
+	if cfg.Mongo.URI != "" {
+		defaultDatabase = ""
+	}
+	cfg.Mongo.Database = getString("MONGO_DATABASE", defaultDatabase)
+	cfg.Mongo.Username = getString("MONGO_USERNAME", "")
+	cfg.Mongo.Password = getString("MONGO_PASSWORD", "")
+	cfg.Mongo.AuthSource = getString("MONGO_AUTH_SOURCE", "")
+	cfg.Mongo.ReplicaSet = getString("MONGO_REPLICA_SET", "")
+	cfg.Mongo.TLS = getBool("MONGO_TLS", false)
+	cfg.Mongo.TLSCAFile = getString("MONGO_TLS_CA_FILE", "")
+	cfg.Mongo.MinPoolSize = uint64(getInt("MONGO_MIN_POOL_SIZE", 0))
+	cfg.Mongo.MaxPoolSize = uint64(getInt("MONGO_MAX_POOL_SIZE", 0))
+	cfg.Mongo.ConnectTimeout = getDuration("MONGO_CONNECT_TIMEOUT", 10*time.Second)
+	cfg.Mongo.ServerSelectionTimeout = getDuration("MONGO_SERVER_SELECTION_TIMEOUT", 5*time.Second)
+	cfg.Mongo.SocketTimeout = getDuration("MONGO_SOCKET_TIMEOUT", 0)
+	cfg.Mongo.AppName = getString("MONGO_APP_NAME", "educationProject")
+	cfg.Mongo.ConnectAttempts = getInt("MONGO_CONNECT_ATTEMPTS", 5)
+	cfg.Mongo.ConnectBackoff = getDuration("MONGO_CONNECT_BACKOFF", time.Second)
+	cfg.Mongo.Collection = getString("MONGO_COLLECTION", "1")
+	cfg.Mongo.FriendsLayout = getString("MONGO_FRIENDS_LAYOUT", "array")
+	cfg.Mongo.EdgesCollection = getString("MONGO_EDGES_COLLECTION", "friendships")
+	cfg.Mongo.ValidationAction = getString("MONGO_VALIDATION_ACTION", "error")
+	cfg.Mongo.MigrationsCollection = getString("MONGO_MIGRATIONS_COLLECTION", "migrations")
+	cfg.Mongo.MigrateOnStart = getBool("MONGO_MIGRATE_ON_START", true)
+	cfg.Mongo.MigrationsLockTTL = getDuration("MONGO_MIGRATIONS_LOCK_TTL", 5*time.Minute)
+	cfg.Mongo.AllowNoTransactions = getBool("MONGO_ALLOW_NO_TRANSACTIONS", false)
+	cfg.Graph.CacheTTL = getDuration("GRAPH_CACHE_TTL", time.Minute)
+	cfg.Graph.RefreshMinAge = getDuration("GRAPH_REFRESH_MIN_AGE", time.Minute)
+	cfg.Graph.TopN = getInt("GRAPH_TOP_N", 10)
+	cfg.User.Retention = getDuration("USER_RETENTION", 30*24*time.Hour)
+	cfg.User.PurgeInterval = getDuration("USER_PURGE_INTERVAL", time.Hour)
+	cfg.Audit.Store = getString("AUDIT_STORE", "mongo")
+	cfg.Audit.Collection = getString("AUDIT_COLLECTION", "audit")
+	cfg.Audit.File = getString("AUDIT_FILE", "audit.jsonl")
+	cfg.Events.History = getInt("EVENTS_HISTORY", 1000)
+	cfg.Events.Buffer = getInt("EVENTS_BUFFER", 64)
+	cfg.Events.Heartbeat = getDuration("EVENTS_HEARTBEAT", 15*time.Second)
+	cfg.Webhooks.MaxAttempts = getInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	cfg.Webhooks.Backoff = getDuration("WEBHOOK_BACKOFF", time.Second)
+	cfg.Webhooks.Timeout = getDuration("WEBHOOK_TIMEOUT", 5*time.Second)
+	cfg.Webhooks.Workers = getInt("WEBHOOK_WORKERS", 4)
+	cfg.Webhooks.Collection = getString("WEBHOOK_COLLECTION", "webhooks")
+	cfg.Webhooks.DeliveriesCollection = getString("WEBHOOK_DELIVERIES_COLLECTION", "webhook_deliveries")
+	cfg.Webhooks.HistoryRetention = getDuration("WEBHOOK_HISTORY_RETENTION", 168*time.Hour)
+	cfg.Outbox.Collection = getString("OUTBOX_COLLECTION", "outbox")
+	cfg.Outbox.PollInterval = getDuration("OUTBOX_POLL_INTERVAL", 500*time.Millisecond)
+	cfg.Outbox.Batch = getInt("OUTBOX_BATCH", 100)
+	cfg.Outbox.Lease = getDuration("OUTBOX_LEASE", 5*time.Minute)
+	cfg.Outbox.Retention = getDuration("OUTBOX_RETENTION", 7*24*time.Hour)
+	cfg.API.LegacySunset = getDate("API_LEGACY_SUNSET", time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC))
+	cfg.API.MaxBodySize = int64(getInt("API_MAX_BODY_SIZE", 1<<20))
+	cfg.API.CompressMinSize = getInt("API_COMPRESS_MIN_SIZE", 1024)
+	cfg.OpenAPI.Validate = getBool("OPENAPI_VALIDATE", false)
+	cfg.TLS.CertFile = getString("TLS_CERT_FILE", "")
+	cfg.TLS.KeyFile = getString("TLS_KEY_FILE", "")
+	cfg.TLS.ClientCAFile = getString("TLS_CLIENT_CA_FILE", "")
+	cfg.TLS.ClientAuthOptional = getBool("TLS_CLIENT_AUTH_OPTIONAL", false)
+	cfg.TLS.ReloadInterval = getDuration("TLS_RELOAD_INTERVAL", 30*time.Second)
+	cfg.CORS.AllowedOrigins = getList("CORS_ALLOWED_ORIGINS", "")
+	cfg.CORS.AllowedMethods = getList("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE")
+	cfg.CORS.AllowedHeaders = getList("CORS_ALLOWED_HEADERS", "Accept,Content-Type,X-Actor,X-API-Key")
+	cfg.CORS.ExposedHeaders = getList("CORS_EXPOSED_HEADERS", "X-Next-Cursor,RateLimit-Limit,RateLimit-Remaining,"+
+		"RateLimit-Reset,RateLimit-Policy,Retry-After,Deprecation,Sunset,Link")
+	cfg.CORS.AllowCredentials = getBool("CORS_ALLOW_CREDENTIALS", false)
+	cfg.CORS.MaxAge = getDuration("CORS_MAX_AGE", 10*time.Minute)
+	cfg.Security.HSTSMaxAge = getDuration("SECURITY_HSTS_MAX_AGE", 365*24*time.Hour)
+	cfg.Security.HSTSIncludeSubdomains = getBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS", true)
+	cfg.Security.FrameOptions = getString("SECURITY_FRAME_OPTIONS", "DENY")
+	cfg.Security.TrustedProxies = getList("SECURITY_TRUSTED_PROXIES", "")
+	cfg.RateLimit.Default = getString("RATE_LIMIT_DEFAULT", "600/1m")
+	cfg.RateLimit.Routes = getMap("RATE_LIMIT_ROUTES", "POST /api/v1/users=30/1m,POST /create=30/1m,"+
+		"POST /api/v1/users/{id}/friends=60/1m,POST /make_friends=60/1m")
+	cfg.RateLimit.APIKeys = getList("RATE_LIMIT_API_KEYS", "")
+	return cfg
+}
+
+// Validate проверяет значения, с которыми сервис не сможет работать,
+// и перечисляет все неверные переменные
+func (c *Config) Validate() error {
+	var problems []string
+	positive := func(key string, v time.Duration) {
+		if v <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive, got %s", key, v))
+		}
+	}
+	positive("OUTBOX_POLL_INTERVAL", c.Outbox.PollInterval)
+	positive("OUTBOX_LEASE", c.Outbox.Lease)
+	positive("USER_PURGE_INTERVAL", c.User.PurgeInterval)
+	positive("EVENTS_HEARTBEAT", c.Events.Heartbeat)
+	positive("WEBHOOK_HISTORY_RETENTION", c.Webhooks.HistoryRetention)
+	if c.Outbox.Batch <= 0 {
+		problems = append(problems, fmt.Sprintf("OUTBOX_BATCH must be positive, got %d", c.Outbox.Batch))
+	}
+	if a := c.Mongo.ValidationAction; a != "error" && a != "warn" {
+		problems = append(problems, fmt.Sprintf("MONGO_VALIDATION_ACTION must be error or warn, got %q", a))
+	}
+	// 0 разрешает пересчет статистики графа на каждый ?refresh=true
+	if c.Graph.RefreshMinAge < 0 {
+		problems = append(problems, fmt.Sprintf("GRAPH_REFRESH_MIN_AGE must not be negative, got %s", c.Graph.RefreshMinAge))
+	}
+	// 0 отключает перечитывание сертификатов
+	if c.TLS.ReloadInterval < 0 {
+		problems = append(problems, fmt.Sprintf("TLS_RELOAD_INTERVAL must not be negative, got %s", c.TLS.ReloadInterval))
+	}
+	// браузер отправит cookie на любой сайт, которому ответ повторит его источник
+	if c.CORS.AllowCredentials {
+		for _, origin := range c.CORS.AllowedOrigins {
+			if origin == "*" {
+				problems = append(problems, "CORS_ALLOW_CREDENTIALS can't be used with CORS_ALLOWED_ORIGINS=*")
+				break
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func getString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func getInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// getMap разбирает список key=value через запятую
+func getMap(key, def string) map[string]string {
+	v := getString(key, def)
+	m := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return m
+}
+
+// getList разбирает список через запятую, пустая строка - пустой список
+func getList(key, def string) []string {
+	var list []string
+	for _, v := range strings.Split(getString(key, def), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getDate(key string, def time.Time) time.Time {
+	v, err := time.Parse("2006-01-02", os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+package config
+
+import "testing"
+
+func TestConfig_Validate(t *testing.T) {
+	testTable := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{"defaults", nil, ""},
+		{"zero outbox interval", map[string]string{"OUTBOX_POLL_INTERVAL": "0s"}, "OUTBOX_POLL_INTERVAL must be positive, got 0s"},
+		{"zero outbox lease", map[string]string{"OUTBOX_LEASE": "0s"}, "OUTBOX_LEASE must be positive, got 0s"},
+		{"zero purge interval", map[string]string{"USER_PURGE_INTERVAL": "0s"}, "USER_PURGE_INTERVAL must be positive, got 0s"},
+		{"negative heartbeat", map[string]string{"EVENTS_HEARTBEAT": "-15s"}, "EVENTS_HEARTBEAT must be positive, got -15s"},
+		{"zero webhook history", map[string]string{"WEBHOOK_HISTORY_RETENTION": "0s"}, "WEBHOOK_HISTORY_RETENTION must be positive, got 0s"},
+		{"reload disabled", map[string]string{"TLS_RELOAD_INTERVAL": "0s"}, ""},
+		{"negative reload interval", map[string]string{"TLS_RELOAD_INTERVAL": "-1s"}, "TLS_RELOAD_INTERVAL must not be negative, got -1s"},
+		{"negative graph refresh age", map[string]string{"GRAPH_REFRESH_MIN_AGE": "-1m"}, "GRAPH_REFRESH_MIN_AGE must not be negative, got -1m0s"},
+		{"validation warn", map[string]string{"MONGO_VALIDATION_ACTION": "warn"}, ""},
+		{"unknown validation action", map[string]string{"MONGO_VALIDATION_ACTION": "ignore"}, `MONGO_VALIDATION_ACTION must be error or warn, got "ignore"`},
+		{"negative outbox batch", map[string]string{"OUTBOX_BATCH": "-1"}, "OUTBOX_BATCH must be positive, got -1"},
+		{"credentials for any origin", map[string]string{"CORS_ALLOW_CREDENTIALS": "true", "CORS_ALLOWED_ORIGINS": "https://a.example.com,*"},
+			"CORS_ALLOW_CREDENTIALS can't be used with CORS_ALLOWED_ORIGINS=*"},
+		{"credentials for listed origins", map[string]string{"CORS_ALLOW_CREDENTIALS": "true", "CORS_ALLOWED_ORIGINS": "https://*.example.com"}, ""},
+		{"all problems", map[string]string{"OUTBOX_POLL_INTERVAL": "-1s", "OUTBOX_BATCH": "0"},
+			"OUTBOX_POLL_INTERVAL must be positive, got -1s; OUTBOX_BATCH must be positive, got 0"},
+	}
+	for _, test := range testTable {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			err := GetConfig().Validate()
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != test.expected {
+				t.Errorf("got error %q want %q", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"sync"
+	"time"
+)
+
+// Bus раздает события подписчикам внутри процесса и хранит последние history событий
+// для продолжения подписки после переподключения
+type Bus struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []*models.Event
+	size    int
+	buffer  int
+	subs    map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	userID string
+	ch     chan *models.Event
+}
+
+func NewBus(history, buffer int) *Bus {
+	return &Bus{
+		size:   history,
+		buffer: buffer,
+		subs:   make(map[*subscriber]struct{}),
+	}
+}
+
+// Publish присваивает событию ID и рассылает его. Подписчик, который не успевает
+// читать, отключается: его канал закрывается, и он может продолжить с последнего ID
+func (b *Bus) Publish(e *models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if b.size > 0 {
+		if len(b.history) == b.size {
+			copy(b.history, b.history[1:])
+			b.history = b.history[:b.size-1]
+		}
+		b.history = append(b.history, e)
+	}
+	for s := range b.subs {
+		if !e.Concerns(s.userID) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+// Subscribe возвращает сохраненные события после afterID и канал новых событий пользователя.
+// cancel нужно вызвать, когда подписка больше не нужна
+func (b *Bus) Subscribe(userID string, afterID uint64) (replay []*models.Event, events <-chan *models.Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	replay = make([]*models.Event, 0)
+	if afterID > 0 {
+		for _, e := range b.history {
+			if e.ID > afterID && e.Concerns(userID) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	s := &subscriber{userID: userID, ch: make(chan *models.Event, b.buffer)}
+	b.subs[s] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(s)
+	}
+	return replay, s.ch, cancel
+}
+
+func (b *Bus) remove(s *subscriber) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
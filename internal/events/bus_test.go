@@ -0,0 +1,61 @@
+package events
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"reflect"
+	"testing"
+)
+
+func ids(events []*models.Event) []uint64 {
+	res := make([]uint64, 0, len(events))
+	for _, e := range events {
+		res = append(res, e.ID)
+	}
+	return res
+}
+
+func TestBus(t *testing.T) {
+	b := NewBus(3, 1)
+	_, all, cancelAll := b.Subscribe("", 0)
+	defer cancelAll()
+	_, user, cancelUser := b.Subscribe("2", 0)
+
+	b.Publish(&models.Event{Type: models.EventUserCreated, UserIDs: []string{"1"}})
+	if e := <-all; e.ID != 1 || e.Time.IsZero() {
+		t.Errorf("wrong first event: got %+v", e)
+	}
+	b.Publish(&models.Event{Type: models.EventFriendshipCreated, UserIDs: []string{"1", "2"}})
+	if e := <-user; e.ID != 2 {
+		t.Errorf("wrong event for user: got %d want 2", e.ID)
+	}
+	// буфер на одно событие уже занят, подписчик отключается
+	b.Publish(&models.Event{Type: models.EventUserDeleted, UserIDs: []string{"3"}})
+	if _, ok := <-all; !ok {
+		t.Fatalf("second event lost")
+	}
+	if _, ok := <-all; ok {
+		t.Errorf("slow subscriber was not closed")
+	}
+	cancelUser()
+	cancelUser()
+
+	b.Publish(&models.Event{Type: models.EventUserUpdated, UserIDs: []string{"2"}})
+	testTable := []struct {
+		name     string
+		user     string
+		after    uint64
+		expected []uint64
+	}{
+		{"new subscription", "", 0, []uint64{}},
+		{"resume", "", 2, []uint64{3, 4}},
+		{"history limit", "", 1, []uint64{2, 3, 4}},
+		{"user", "2", 1, []uint64{2, 4}},
+	}
+	for _, test := range testTable {
+		replay, _, cancel := b.Subscribe(test.user, test.after)
+		cancel()
+		if got := ids(replay); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: wrong replay: got %v want %v", test.name, got, test.expected)
+		}
+	}
+}
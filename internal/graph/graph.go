@@ -0,0 +1,206 @@
+package graph
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"sort"
+)
+
+// Source - минимальный набор методов репозитория, нужный для построения графа
+type Source interface {
+	FindAll(ctx context.Context) ([]*models.UserModel, error)
+}
+
+// Graph - неориентированный граф дружбы, вершины отсортированы по id
+type Graph struct {
+	ids   []string
+	names map[string]string
+	adj   map[string]map[string]struct{}
+}
+
+func New() *Graph {
+	return &Graph{
+		names: make(map[string]string),
+		adj:   make(map[string]map[string]struct{}),
+	}
+}
+
+// Load строит граф по данным любого репозитория
+func Load(ctx context.Context, src Source) (*Graph, error) {
+	users, err := src.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g := New()
+	for _, u := range users {
+		g.AddUser(u.ID, u.Name)
+	}
+	for _, u := range users {
+		for _, friendID := range u.FriendIDs {
+			// ссылки на несуществующих пользователей в граф не попадают
+			if _, ok := g.adj[friendID]; ok {
+				g.AddEdge(u.ID, friendID)
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *Graph) AddUser(id, name string) {
+	if _, ok := g.adj[id]; ok {
+		return
+	}
+	g.adj[id] = make(map[string]struct{})
+	g.names[id] = name
+	i := sort.SearchStrings(g.ids, id)
+	g.ids = append(g.ids, "")
+	copy(g.ids[i+1:], g.ids[i:])
+	g.ids[i] = id
+}
+
+func (g *Graph) AddEdge(a, b string) {
+	if a == b {
+		return
+	}
+	g.AddUser(a, "")
+	g.AddUser(b, "")
+	g.adj[a][b] = struct{}{}
+	g.adj[b][a] = struct{}{}
+}
+
+func (g *Graph) Users() int {
+	return len(g.ids)
+}
+
+func (g *Graph) Edges() int {
+	sum := 0
+	for _, n := range g.adj {
+		sum += len(n)
+	}
+	return sum / 2
+}
+
+func (g *Graph) Degree(id string) int {
+	return len(g.adj[id])
+}
+
+// neighbours возвращает соседей в детерминированном порядке
+func (g *Graph) neighbours(id string) []string {
+	res := make([]string, 0, len(g.adj[id]))
+	for n := range g.adj[id] {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Components возвращает размеры компонент связности по убыванию
+func (g *Graph) Components() []int {
+	visited := make(map[string]bool, len(g.ids))
+	sizes := make([]int, 0)
+	for _, start := range g.ids {
+		if visited[start] {
+			continue
+		}
+		size := 0
+		stack := []string{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			size++
+			for n := range g.adj[v] {
+				if !visited[n] {
+					visited[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	return sizes
+}
+
+// DegreeHistogram - количество пользователей для каждой степени
+func (g *Graph) DegreeHistogram() map[int]int {
+	hist := make(map[int]int)
+	for _, id := range g.ids {
+		hist[g.Degree(id)]++
+	}
+	return hist
+}
+
+// Clustering - локальный коэффициент кластеризации вершины
+func (g *Graph) Clustering(id string) float64 {
+	n := g.neighbours(id)
+	k := len(n)
+	if k < 2 {
+		return 0
+	}
+	links := 0
+	for i := 0; i < k; i++ {
+		for j := i + 1; j < k; j++ {
+			if _, ok := g.adj[n[i]][n[j]]; ok {
+				links++
+			}
+		}
+	}
+	return 2 * float64(links) / float64(k*(k-1))
+}
+
+func (g *Graph) AverageClustering() float64 {
+	if len(g.ids) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, id := range g.ids {
+		sum += g.Clustering(id)
+	}
+	return sum / float64(len(g.ids))
+}
+
+// Betweenness считает центральность по посредничеству алгоритмом Брандеса
+func (g *Graph) Betweenness() map[string]float64 {
+	cb := make(map[string]float64, len(g.ids))
+	for _, id := range g.ids {
+		cb[id] = 0
+	}
+	for _, s := range g.ids {
+		stack := make([]string, 0, len(g.ids))
+		pred := make(map[string][]string)
+		sigma := map[string]float64{s: 1}
+		dist := map[string]int{s: 0}
+		queue := []string{s}
+		for len(queue) > 0 {
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack, v)
+			for _, w := range g.neighbours(v) {
+				if _, ok := dist[w]; !ok {
+					dist[w] = dist[v] + 1
+					queue = append(queue, w)
+				}
+				if dist[w] == dist[v]+1 {
+					sigma[w] += sigma[v]
+					pred[w] = append(pred[w], v)
+				}
+			}
+		}
+		delta := make(map[string]float64)
+		for i := len(stack) - 1; i >= 0; i-- {
+			w := stack[i]
+			for _, v := range pred[w] {
+				delta[v] += sigma[v] / sigma[w] * (1 + delta[w])
+			}
+			if w != s {
+				cb[w] += delta[w]
+			}
+		}
+	}
+	// граф неориентированный, каждый путь посчитан дважды
+	for id := range cb {
+		cb[id] /= 2
+	}
+	return cb
+}
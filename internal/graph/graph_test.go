@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testGraph: треугольник 1-2-3, хвост 3-4 и отдельная пара 5-6
+func testGraph() *Graph {
+	g := New()
+	for _, e := range [][2]string{{"1", "2"}, {"2", "3"}, {"1", "3"}, {"3", "4"}, {"5", "6"}} {
+		g.AddEdge(e[0], e[1])
+	}
+	g.AddUser("7", "")
+	return g
+}
+
+func TestGraph_Stats(t *testing.T) {
+	stats := testGraph().Stats(2)
+
+	if stats.Users != 7 || stats.Edges != 5 {
+		t.Errorf("wrong counts: got %d users %d edges", stats.Users, stats.Edges)
+	}
+	if !reflect.DeepEqual(stats.ComponentSizes, []int{4, 2, 1}) {
+		t.Errorf("wrong component sizes: got %v", stats.ComponentSizes)
+	}
+	if !reflect.DeepEqual(stats.DegreeHistogram, map[int]int{0: 1, 1: 3, 2: 2, 3: 1}) {
+		t.Errorf("wrong degree histogram: got %v", stats.DegreeHistogram)
+	}
+	// у 1 и 2 коэффициент 1, у 3 - 1/3, у остальных 0
+	if want := (1 + 1 + 1.0/3) / 7; math.Abs(stats.AverageClustering-want) > 1e-9 {
+		t.Errorf("wrong clustering: got %v want %v", stats.AverageClustering, want)
+	}
+	if stats.TopDegree[0].ID != "3" || stats.TopDegree[0].Value != 3 || len(stats.TopDegree) != 2 {
+		t.Errorf("wrong top degree: got %+v", stats.TopDegree)
+	}
+	// через 3 проходят кратчайшие пути 1-4 и 2-4
+	if stats.TopBetweenness[0].ID != "3" || stats.TopBetweenness[0].Value != 2 {
+		t.Errorf("wrong top betweenness: got %+v", stats.TopBetweenness)
+	}
+}
+
+type testSource map[string][]string
+
+func (s testSource) FindAll(ctx context.Context) ([]*models.UserModel, error) {
+	users := make([]*models.UserModel, 0)
+	for id := range s {
+		users = append(users, &models.UserModel{ID: id, Name: "user" + id, FriendIDs: s[id]})
+	}
+	return users, nil
+}
+
+func TestService_Stats(t *testing.T) {
+	// ссылка на удаленного пользователя 9 не должна попасть в граф
+	src := testSource{"1": {"2", "9"}, "2": {"1"}}
+	service := NewService(src, -1, 0, 10)
+
+	stats, err := service.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users != 2 || stats.Edges != 1 {
+		t.Errorf("wrong counts: got %d users %d edges", stats.Users, stats.Edges)
+	}
+
+	src["3"] = []string{}
+	cached, _ := service.Stats(context.Background())
+	if cached != stats {
+		t.Errorf("expected cached stats")
+	}
+
+	service.Invalidate()
+	fresh, _ := service.Stats(context.Background())
+	if fresh.Users != 3 {
+		t.Errorf("expected fresh stats after invalidate, got %d users", fresh.Users)
+	}
+}
+
+func TestService_Refresh(t *testing.T) {
+	src := testSource{"1": {"2"}, "2": {"1"}}
+	service := NewService(src, -1, time.Hour, 10)
+	if !service.Refresh() {
+		t.Errorf("refresh without cached stats is skipped")
+	}
+	stats, err := service.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// результат моложе minRefresh не пересчитывается
+	src["3"] = []string{}
+	if service.Refresh() {
+		t.Errorf("refresh of fresh stats is not skipped")
+	}
+	if cached, _ := service.Stats(context.Background()); cached != stats {
+		t.Errorf("expected cached stats after skipped refresh")
+	}
+
+	service.minRefresh = 0
+	if !service.Refresh() {
+		t.Errorf("refresh of old stats is skipped")
+	}
+	if fresh, _ := service.Stats(context.Background()); fresh.Users != 3 {
+		t.Errorf("expected fresh stats after refresh, got %d users", fresh.Users)
+	}
+}
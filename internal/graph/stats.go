@@ -0,0 +1,122 @@
+package graph
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Stats struct {
+	Users             int         `json:"users"`
+	Edges             int         `json:"edges"`
+	Components        int         `json:"components"`
+	ComponentSizes    []int       `json:"component_sizes"`
+	DegreeHistogram   map[int]int `json:"degree_histogram"`
+	AverageClustering float64     `json:"average_clustering"`
+	TopDegree         []Ranked    `json:"top_degree"`
+	TopBetweenness    []Ranked    `json:"top_betweenness"`
+	GeneratedAt       time.Time   `json:"generated_at"`
+}
+
+type Ranked struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+func (g *Graph) Stats(topN int) *Stats {
+	sizes := g.Components()
+	degree := make(map[string]float64, len(g.ids))
+	for _, id := range g.ids {
+		degree[id] = float64(g.Degree(id))
+	}
+	return &Stats{
+		Users:             g.Users(),
+		Edges:             g.Edges(),
+		Components:        len(sizes),
+		ComponentSizes:    sizes,
+		DegreeHistogram:   g.DegreeHistogram(),
+		AverageClustering: g.AverageClustering(),
+		TopDegree:         g.top(degree, topN),
+		TopBetweenness:    g.top(g.Betweenness(), topN),
+		GeneratedAt:       time.Now().UTC(),
+	}
+}
+
+// top сортирует по убыванию значения, при равенстве - по id
+func (g *Graph) top(values map[string]float64, n int) []Ranked {
+	res := make([]Ranked, 0, len(values))
+	for _, id := range g.ids {
+		res = append(res, Ranked{ID: id, Name: g.names[id], Value: values[id]})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Value > res[j].Value
+	})
+	if n >= 0 && len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// Service кэширует посчитанную статистику.
+// ttl == 0 отключает кэш, ttl < 0 хранит результат до вызова Invalidate.
+// Refresh сбрасывает кэш, только если результат старше minRefresh
+type Service struct {
+	source     Source
+	ttl        time.Duration
+	minRefresh time.Duration
+	topN       int
+	mu         sync.Mutex
+	cached     *Stats
+	computed   time.Time
+	expires    time.Time
+}
+
+func NewService(source Source, ttl, minRefresh time.Duration, topN int) *Service {
+	return &Service{
+		source:     source,
+		ttl:        ttl,
+		minRefresh: minRefresh,
+		topN:       topN,
+	}
+}
+
+func (s *Service) Stats(ctx context.Context) (*Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cached != nil && (s.ttl < 0 || time.Now().Before(s.expires)) {
+		return s.cached, nil
+	}
+
+	g, err := Load(ctx, s.source)
+	if err != nil {
+		return nil, err
+	}
+	stats := g.Stats(s.topN)
+	if s.ttl != 0 {
+		s.cached = stats
+		s.computed = time.Now()
+		s.expires = s.computed.Add(s.ttl)
+	}
+	return stats, nil
+}
+
+func (s *Service) Invalidate() {
+	s.mu.Lock()
+	s.cached = nil
+	s.mu.Unlock()
+}
+
+// Refresh - сброс кэша по запросу клиента. Расчет дорогой, поэтому результат
+// моложе minRefresh остается, иначе частые запросы нагружали бы сервис
+func (s *Service) Refresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && time.Since(s.computed) < s.minRefresh {
+		return false
+	}
+	s.cached = nil
+	return true
+}
@@ -0,0 +1,124 @@
+package loader
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"sync"
+	"time"
+)
+
+// Fetch читает пользователей одним запросом, отсутствующие id пропускаются
+type Fetch func(ctx context.Context, ids []string) ([]*models.UserModel, error)
+
+// Users собирает запросы пользователей, пришедшие в течение wait, в один вызов Fetch
+// и запоминает результаты. Загрузчик создается на один запрос клиента, поэтому
+// кэш не нужно сбрасывать после изменений
+type Users struct {
+	fetch    Fetch
+	wait     time.Duration
+	maxBatch int
+
+	mu    sync.Mutex
+	cache map[string]*result
+	batch *batch
+}
+
+type result struct {
+	user *models.UserModel
+	err  error
+	done chan struct{}
+}
+
+type batch struct {
+	ctx     context.Context
+	ids     []string
+	results []*result
+}
+
+func NewUsers(fetch Fetch, wait time.Duration, maxBatch int) *Users {
+	return &Users{
+		fetch:    fetch,
+		wait:     wait,
+		maxBatch: maxBatch,
+		cache:    make(map[string]*result),
+	}
+}
+
+// Load возвращает пользователя по id, nil - пользователь не найден
+func (l *Users) Load(ctx context.Context, id string) (*models.UserModel, error) {
+	return l.await(ctx, l.enqueue(ctx, id))
+}
+
+// LoadMany возвращает найденных пользователей в порядке ids
+func (l *Users) LoadMany(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	results := make([]*result, len(ids))
+	for i, id := range ids {
+		results[i] = l.enqueue(ctx, id)
+	}
+	users := make([]*models.UserModel, 0, len(ids))
+	for _, r := range results {
+		u, err := l.await(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (l *Users) enqueue(ctx context.Context, id string) *result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r, ok := l.cache[id]; ok {
+		return r
+	}
+	r := &result{done: make(chan struct{})}
+	l.cache[id] = r
+
+	if l.batch == nil {
+		b := &batch{ctx: ctx}
+		l.batch = b
+		time.AfterFunc(l.wait, func() {
+			l.mu.Lock()
+			if l.batch != b {
+				// пачка уже отправлена по размеру
+				l.mu.Unlock()
+				return
+			}
+			l.batch = nil
+			l.mu.Unlock()
+			l.dispatch(b)
+		})
+	}
+	l.batch.ids = append(l.batch.ids, id)
+	l.batch.results = append(l.batch.results, r)
+	if l.maxBatch > 0 && len(l.batch.ids) >= l.maxBatch {
+		b := l.batch
+		l.batch = nil
+		go l.dispatch(b)
+	}
+	return r
+}
+
+func (l *Users) dispatch(b *batch) {
+	users, err := l.fetch(b.ctx, b.ids)
+	byID := make(map[string]*models.UserModel, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	for i, r := range b.results {
+		r.user, r.err = byID[b.ids[i]], err
+		close(r.done)
+	}
+}
+
+func (l *Users) await(ctx context.Context, r *result) (*models.UserModel, error) {
+	select {
+	case <-r.done:
+		return r.user, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fetchLog struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (f *fetchLog) fetch(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	f.mu.Lock()
+	f.batches = append(f.batches, append([]string{}, ids...))
+	f.mu.Unlock()
+	users := make([]*models.UserModel, 0, len(ids))
+	for _, id := range ids {
+		if id != "404" {
+			users = append(users, &models.UserModel{ID: id})
+		}
+	}
+	return users, nil
+}
+
+func TestUsers_Batch(t *testing.T) {
+	f := &fetchLog{}
+	l := NewUsers(f.fetch, 5*time.Millisecond, 100)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2", "3", "2", "404"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			u, err := l.Load(ctx, id)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if id == "404" && u != nil || id != "404" && (u == nil || u.ID != id) {
+				t.Errorf("wrong user for %s: %+v", id, u)
+			}
+		}(id)
+	}
+	wg.Wait()
+	if len(f.batches) != 1 || len(f.batches[0]) != 4 {
+		t.Fatalf("want one batch of 4 ids, got %v", f.batches)
+	}
+
+	// повторное чтение берется из кэша
+	users, err := l.LoadMany(ctx, []string{"3", "404", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ID != "3" || users[1].ID != "1" {
+		t.Errorf("wrong users: %+v", users)
+	}
+	if len(f.batches) != 1 {
+		t.Errorf("cached ids fetched again: %v", f.batches)
+	}
+}
+
+func TestUsers_MaxBatch(t *testing.T) {
+	f := &fetchLog{}
+	l := NewUsers(f.fetch, time.Hour, 2)
+
+	users, err := l.LoadMany(context.Background(), []string{"1", "2", "3", "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 4 {
+		t.Errorf("want 4 users, got %d", len(users))
+	}
+	want := [][]string{{"1", "2"}, {"3", "4"}}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.batches) != 2 || !reflect.DeepEqual(f.batches[0], want[0]) && !reflect.DeepEqual(f.batches[0], want[1]) {
+		t.Errorf("wrong batches: %v", f.batches)
+	}
+}
+
+func TestUsers_Error(t *testing.T) {
+	failed := errors.New("db is down")
+	l := NewUsers(func(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+		return nil, failed
+	}, time.Millisecond, 0)
+
+	if _, err := l.LoadMany(context.Background(), []string{"1", "2"}); !errors.Is(err, failed) {
+		t.Errorf("want fetch error, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryState - состояние миграций в памяти процесса для тестов и хранилища userMap
+type MemoryState struct {
+	mu        sync.Mutex
+	records   map[int]Record
+	owner     string
+	expiresAt time.Time
+	// now подменяется в тестах
+	now func() time.Time
+}
+
+func NewMemoryState() *MemoryState {
+	return &MemoryState{records: make(map[int]Record), now: time.Now}
+}
+
+func (s *MemoryState) Lock(ctx context.Context, owner string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.owner != "" && s.owner != owner && now.Before(s.expiresAt) {
+		return ErrLocked
+	}
+	s.owner, s.expiresAt = owner, now.Add(ttl)
+	return nil
+}
+
+func (s *MemoryState) Unlock(ctx context.Context, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owner == owner {
+		s.owner = ""
+	}
+	return nil
+}
+
+func (s *MemoryState) Applied(ctx context.Context) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Version < records[j].Version })
+	return records, nil
+}
+
+func (s *MemoryState) Save(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[r.Version] = r
+	return nil
+}
+
+func (s *MemoryState) Remove(ctx context.Context, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, version)
+	return nil
+}
@@ -0,0 +1,258 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrLocked - миграции сейчас выполняет другой экземпляр
+var ErrLocked = errors.New("migrations are locked by another instance")
+
+// Migration - версионированный шаг изменения данных. Down отменяет Up,
+// без Down шаг нельзя откатить
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// Record - запись о примененной миграции в хранилище состояния
+type Record struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+}
+
+// State хранит примененные миграции и блокировку, которая не дает двум
+// экземплярам мигрировать одновременно
+type State interface {
+	// Lock захватывает или продлевает блокировку owner на ttl, занятая
+	// другим владельцем и не истекшая блокировка - ErrLocked
+	Lock(ctx context.Context, owner string, ttl time.Duration) error
+	Unlock(ctx context.Context, owner string) error
+	Applied(ctx context.Context) ([]Record, error)
+	Save(ctx context.Context, r Record) error
+	Remove(ctx context.Context, version int) error
+}
+
+// Status - миграция и ее состояние. Known=false - миграция есть в хранилище,
+// но не в коде, например после отката версии сервиса
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	Known       bool
+}
+
+// DefaultLockTTL - через сколько блокировка упавшего экземпляра считается брошенной
+const DefaultLockTTL = 5 * time.Minute
+
+type Migrator struct {
+	state      State
+	migrations []Migration
+	owner      string
+	lockTTL    time.Duration
+	logger     *logging.Logger
+}
+
+// NewMigrator проверяет, что версии положительные и не повторяются, и сортирует шаги.
+// Пока идут миграции, блокировка продлевается каждую треть lockTTL
+func NewMigrator(state State, migrations []Migration, lockTTL time.Duration, logger *logging.Logger) (*Migrator, error) {
+	if lockTTL <= 0 {
+		return nil, fmt.Errorf("migrations lock ttl must be positive, got %s", lockTTL)
+	}
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration version must be positive, got %d", m.Version)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d has no up step", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+	}
+	host, _ := os.Hostname()
+	return &Migrator{
+		state:      state,
+		migrations: sorted,
+		owner:      host + "/" + strconv.Itoa(os.Getpid()) + "/" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		lockTTL:    lockTTL,
+		logger:     logger,
+	}, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]Record, error) {
+	records, err := m.state.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't read applied migrations: %w", err)
+	}
+	applied := make(map[int]Record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Status - все миграции из кода и из хранилища по возрастанию версии
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		r, ok := applied[mig.Version]
+		statuses = append(statuses, Status{
+			Version: mig.Version, Description: mig.Description, Applied: ok, AppliedAt: r.AppliedAt, Known: true,
+		})
+		delete(applied, mig.Version)
+	}
+	for _, r := range applied {
+		statuses = append(statuses, Status{Version: r.Version, Description: r.Description, Applied: true, AppliedAt: r.AppliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// withLock выполняет fn под блокировкой и снимает ее в конце. Пока fn работает,
+// блокировка продлевается в фоне, чтобы долгий шаг не пережил ее срок. Если продлить
+// не удалось, контекст fn отменяется
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := m.state.Lock(ctx, m.owner, m.lockTTL); err != nil {
+		if errors.Is(err, ErrLocked) {
+			return err
+		}
+		return fmt.Errorf("can't lock migrations: %w", err)
+	}
+	defer func() {
+		if err := m.state.Unlock(context.Background(), m.owner); err != nil {
+			m.logger.Err(err).Msg("can't unlock migrations")
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		renewErr error
+	)
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(m.lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := m.state.Lock(ctx, m.owner, m.lockTTL); err != nil {
+					renewErr = err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := fn(ctx)
+	close(done)
+	wg.Wait()
+	if renewErr != nil {
+		return fmt.Errorf("can't extend migrations lock: %w", renewErr)
+	}
+	return err
+}
+
+// Up применяет неприменные миграции с версией до target по возрастанию,
+// target 0 - все. Возвращает примененные версии
+func (m *Migrator) Up(ctx context.Context, target int) ([]int, error) {
+	var done []int
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if target > 0 && mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err = mig.Up(ctx); err != nil {
+				return fmt.Errorf("migration %d up failed: %w", mig.Version, err)
+			}
+			r := Record{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now().UTC().Truncate(time.Millisecond)}
+			if err = m.state.Save(ctx, r); err != nil {
+				return fmt.Errorf("can't save migration %d: %w", mig.Version, err)
+			}
+			m.logger.Info().Int("version", mig.Version).Str("description", mig.Description).Msg("migration applied")
+			done = append(done, mig.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down откатывает примененные миграции с версией больше target по убыванию,
+// target меньше 0 - только последнюю. Возвращает откаченные версии
+func (m *Migrator) Down(ctx context.Context, target int) ([]int, error) {
+	var done []int
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		if target < 0 {
+			if len(versions) == 0 {
+				return nil
+			}
+			target = versions[0] - 1
+		}
+
+		byVersion := make(map[int]Migration, len(m.migrations))
+		for _, mig := range m.migrations {
+			byVersion[mig.Version] = mig
+		}
+		for _, v := range versions {
+			if v <= target {
+				break
+			}
+			mig, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but unknown to this version of the service", v)
+			}
+			if mig.Down == nil {
+				return fmt.Errorf("migration %d can't be rolled back", v)
+			}
+			if err = mig.Down(ctx); err != nil {
+				return fmt.Errorf("migration %d down failed: %w", v, err)
+			}
+			if err = m.state.Remove(ctx, v); err != nil {
+				return fmt.Errorf("can't remove migration %d: %w", v, err)
+			}
+			m.logger.Info().Int("version", v).Str("description", mig.Description).Msg("migration rolled back")
+			done = append(done, v)
+		}
+		return nil
+	})
+	return done, err
+}
@@ -0,0 +1,222 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testMigrations - шаги, которые пишут в журнал, что и в каком порядке выполнялось
+func testMigrations(log *[]string, versions ...int) []Migration {
+	list := make([]Migration, 0, len(versions))
+	for _, v := range versions {
+		v := v
+		list = append(list, Migration{
+			Version:     v,
+			Description: "step",
+			Up: func(ctx context.Context) error {
+				*log = append(*log, "up "+strconv.Itoa(v))
+				return nil
+			},
+			Down: func(ctx context.Context) error {
+				*log = append(*log, "down "+strconv.Itoa(v))
+				return nil
+			},
+		})
+	}
+	return list
+}
+
+func newTestMigrator(t *testing.T, state State, list []Migration, lockTTL time.Duration) *Migrator {
+	m, err := NewMigrator(state, list, lockTTL, &logging.Logger{Logger: zerolog.Nop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestNewMigrator_Validation(t *testing.T) {
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	var log []string
+	testTable := []struct {
+		name       string
+		migrations []Migration
+		lockTTL    time.Duration
+		expected   string
+	}{
+		{"duplicate", testMigrations(&log, 1, 2, 1), DefaultLockTTL, "duplicate migration version 1"},
+		{"zero version", testMigrations(&log, 0), DefaultLockTTL, "migration version must be positive, got 0"},
+		{"no up", []Migration{{Version: 1}}, DefaultLockTTL, "migration 1 has no up step"},
+		{"no lock ttl", testMigrations(&log, 1), 0, "migrations lock ttl must be positive, got 0s"},
+	}
+	for _, test := range testTable {
+		_, err := NewMigrator(NewMemoryState(), test.migrations, test.lockTTL, logger)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: got error %v want %q", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	m := newTestMigrator(t, NewMemoryState(), testMigrations(&log, 3, 1, 2), DefaultLockTTL)
+
+	steps := []struct {
+		name     string
+		run      func() ([]int, error)
+		expected []int
+	}{
+		{"up to 2", func() ([]int, error) { return m.Up(ctx, 2) }, []int{1, 2}},
+		{"up to latest", func() ([]int, error) { return m.Up(ctx, 0) }, []int{3}},
+		{"repeated up", func() ([]int, error) { return m.Up(ctx, 0) }, nil},
+		{"down last", func() ([]int, error) { return m.Down(ctx, -1) }, []int{3}},
+		{"down all", func() ([]int, error) { return m.Down(ctx, 0) }, []int{2, 1}},
+	}
+	for _, step := range steps {
+		done, err := step.run()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !reflect.DeepEqual(done, step.expected) {
+			t.Errorf("%s: got versions %v want %v", step.name, done, step.expected)
+		}
+	}
+	expected := []string{"up 1", "up 2", "up 3", "down 3", "down 2", "down 1"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("wrong order of steps: got %v want %v", log, expected)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Errorf("migration %d is still applied", s.Version)
+		}
+	}
+}
+
+func TestMigrator_Status(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	state := NewMemoryState()
+	// версия 5 применена более новой версией сервиса
+	state.Save(ctx, Record{Version: 5, Description: "future"})
+	m := newTestMigrator(t, state, testMigrations(&log, 1, 2), DefaultLockTTL)
+	if _, err := m.Up(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("wrong number of statuses: got %d want 3", len(statuses))
+	}
+	if !statuses[0].Applied || statuses[0].AppliedAt.IsZero() {
+		t.Errorf("migration 1 is not applied: %+v", statuses[0])
+	}
+	if statuses[1].Applied {
+		t.Errorf("migration 2 is applied: %+v", statuses[1])
+	}
+	if expected := (Status{Version: 5, Description: "future", Applied: true}); statuses[2] != expected {
+		t.Errorf("wrong status of unknown migration: got %+v want %+v", statuses[2], expected)
+	}
+
+	// неизвестную версию откатить нечем
+	_, err = m.Down(ctx, 0)
+	if expected := "migration 5 is applied but unknown to this version of the service"; err == nil || err.Error() != expected {
+		t.Errorf("got error %v want %q", err, expected)
+	}
+}
+
+func TestMigrator_Failure(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	list := testMigrations(&log, 1, 3)
+	list = append(list, Migration{Version: 2, Up: func(ctx context.Context) error {
+		return errors.New("broken")
+	}})
+	state := NewMemoryState()
+	m := newTestMigrator(t, state, list, DefaultLockTTL)
+
+	done, err := m.Up(ctx, 0)
+	if err == nil || err.Error() != "migration 2 up failed: broken" {
+		t.Errorf("got error %v want migration 2 up failed", err)
+	}
+	if !reflect.DeepEqual(done, []int{1}) {
+		t.Errorf("got versions %v want [1]", done)
+	}
+	if records, _ := state.Applied(ctx); len(records) != 1 {
+		t.Errorf("wrong number of applied migrations: got %d want 1", len(records))
+	}
+
+	// без Down шаг не откатывается
+	state.Save(ctx, Record{Version: 2})
+	_, err = m.Down(ctx, -1)
+	if err == nil || err.Error() != "migration 2 can't be rolled back" {
+		t.Errorf("got error %v want migration 2 can't be rolled back", err)
+	}
+	// блокировка снята и после ошибки
+	if err = state.Lock(ctx, "other", time.Minute); err != nil {
+		t.Errorf("lock is not released: %v", err)
+	}
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	state := NewMemoryState()
+	now := time.Now()
+	state.now = func() time.Time { return now }
+	m := newTestMigrator(t, state, testMigrations(&log, 1), DefaultLockTTL)
+
+	state.Lock(ctx, "other", time.Minute)
+	if _, err := m.Up(ctx, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("got error %v want %v", err, ErrLocked)
+	}
+	if len(log) != 0 {
+		t.Errorf("migrations ran under foreign lock: %v", log)
+	}
+
+	// блокировка упавшего экземпляра истекает
+	now = now.Add(2 * time.Minute)
+	done, err := m.Up(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(done, []int{1}) {
+		t.Errorf("got versions %v want [1]", done)
+	}
+	if err = state.Lock(ctx, "other", time.Minute); err != nil {
+		t.Errorf("lock is not released: %v", err)
+	}
+}
+
+func TestMigrator_LockRenewal(t *testing.T) {
+	ctx := context.Background()
+	state := NewMemoryState()
+	var foreign error
+	// шаг длится дольше срока блокировки, но она продлевается
+	slow := Migration{Version: 1, Up: func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		foreign = state.Lock(ctx, "other", time.Minute)
+		return nil
+	}}
+	m := newTestMigrator(t, state, []Migration{slow}, 30*time.Millisecond)
+
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(foreign, ErrLocked) {
+		t.Errorf("other instance took the lock during a long step: %v", foreign)
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// действия, которые попадают в журнал аудита
+const (
+	AuditCreate      = "create"
+	AuditMakeFriends = "make_friends"
+	AuditDelete      = "delete"
+	AuditUpdateAge   = "update_age"
+	AuditUnfriend    = "unfriend"
+)
+
+// AuditEntry - запись журнала: кто, что и с кем сделал, состояние пользователей до и после.
+// Actor проверен (ActorVerified), если это subject клиентского сертификата. Исполнитель,
+// которого назвал клиент при проверенном сертификате, не проверяется и пишется в ClaimedActor
+type AuditEntry struct {
+	Time          time.Time    `json:"time" bson:"time"`
+	Actor         string       `json:"actor" bson:"actor"`
+	ActorVerified bool         `json:"actor_verified" bson:"actor_verified"`
+	ClaimedActor  string       `json:"claimed_actor,omitempty" bson:"claimed_actor,omitempty"`
+	Action        string       `json:"action" bson:"action"`
+	Targets       []string     `json:"targets" bson:"targets"`
+	Before        []*UserModel `json:"before,omitempty" bson:"before,omitempty"`
+	After         []*UserModel `json:"after,omitempty" bson:"after,omitempty"`
+	RequestID     string       `json:"request_id,omitempty" bson:"request_id,omitempty"`
+}
+
+// AuditFilter - пустые поля не ограничивают выборку
+type AuditFilter struct {
+	// UserID ищется и среди исполнителей, и среди затронутых пользователей
+	UserID string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// Match проверяет запись без учета Limit
+func (f AuditFilter) Match(e *AuditEntry) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Time.Before(f.To) {
+		return false
+	}
+	if f.UserID == "" || e.Actor == f.UserID {
+		return true
+	}
+	for _, id := range e.Targets {
+		if id == f.UserID {
+			return true
+		}
+	}
+	return false
+}
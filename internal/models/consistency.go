@@ -0,0 +1,25 @@
+package models
+
+// типы несогласованностей в списках друзей
+const (
+	IssueAsymmetric    = "asymmetric_friendship"
+	IssueDangling      = "dangling_friend"
+	IssueDuplicate     = "duplicate_friend"
+	IssueSelf          = "self_friendship"
+	IssueBlocked       = "blocked_friendship"
+	IssueDuplicateUser = "duplicate_user_id"
+)
+
+type ConsistencyIssue struct {
+	Type     string `json:"type"`
+	UserID   string `json:"user_id"`
+	FriendID string `json:"friend_id,omitempty"`
+	Count    int    `json:"count,omitempty"`
+	Fixed    bool   `json:"fixed"`
+}
+
+type ConsistencyReport struct {
+	Users  int                `json:"users"`
+	Issues []ConsistencyIssue `json:"issues"`
+	Fixed  int                `json:"fixed"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "errors"
+
+var (
+	// ErrNotFound - пользователя нет или он удален
+	ErrNotFound = errors.New("пользователь не найден")
+	// ErrUserExists - пользователь с таким id уже есть
+	ErrUserExists = errors.New("пользователь уже существует")
+	// ErrSelfFriendship - пользователь не может дружить сам с собой
+	ErrSelfFriendship = errors.New("пользователь не может дружить сам с собой")
+	// ErrAlreadyFriends - пользователи уже друзья
+	ErrAlreadyFriends = errors.New("пользователи уже друзья")
+	// ErrNotFriends - пользователи не друзья
+	ErrNotFriends = errors.New("пользователи не друзья")
+	// ErrBlocked - один из пользователей заблокировал другого
+	ErrBlocked = errors.New("пользователь заблокирован")
+	// ErrNotBlocked - пользователь не заблокирован тем, кто снимает блокировку
+	ErrNotBlocked = errors.New("пользователь не заблокирован")
+	// ErrRetentionExpired - удаленного пользователя уже нельзя восстановить
+	ErrRetentionExpired = errors.New("срок восстановления истек")
+	// ErrWebhookNotFound - вебхука нет или он удален
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrInvalidCursor - курсор страницы не относится к этой выдаче
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Error - ошибка репозитория с текстом для клиента. errors.Is находит
+// по ней вид ошибки Kind, а текст остается прежним
+type Error struct {
+	Kind error
+	Text string
+}
+
+func NewError(kind error, text string) error {
+	return &Error{Kind: kind, Text: text}
+}
+
+func (e *Error) Error() string {
+	return e.Text
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// типы доменных событий
+const (
+	EventUserCreated       = "user.created"
+	EventUserDeleted       = "user.deleted"
+	EventUserUpdated       = "user.updated"
+	EventFriendshipCreated = "friendship.created"
+	EventFriendshipDeleted = "friendship.deleted"
+)
+
+// Event - доменное событие, ID растет монотонно в пределах процесса.
+// Key - постоянный id записи в outbox, по нему получатель отбрасывает повторы
+type Event struct {
+	ID      uint64     `json:"id" bson:"-"`
+	Key     string     `json:"key,omitempty" bson:"-"`
+	Type    string     `json:"type" bson:"type"`
+	Time    time.Time  `json:"time" bson:"time"`
+	UserIDs []string   `json:"user_ids" bson:"user_ids"`
+	User    *UserModel `json:"user,omitempty" bson:"user,omitempty"`
+}
+
+// Concerns - касается ли событие пользователя, пустой id подходит под любое событие
+func (e *Event) Concerns(userID string) bool {
+	if userID == "" {
+		return true
+	}
+	for _, id := range e.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// варианты сортировки списка друзей
+const (
+	SortByName  = "name"
+	SortByAge   = "age"
+	SortBySince = "since"
+)
+
+// FriendsQuery - параметры постраничного чтения друзей.
+// Fields - поля модели хранения (id, name, age, friends), пустой список - все поля
+type FriendsQuery struct {
+	Limit  int
+	After  *Cursor
+	Sort   string
+	Fields []string
+}
+
+// Friendships - метаданные дружбы владельца списка с каждым из друзей страницы
+type FriendsPage struct {
+	Friends     []*UserModel
+	Friendships map[string]*Friendship
+	Next        *Cursor
+}
+
+// Cursor - позиция последнего отданного друга: значение ключа сортировки и id
+type Cursor struct {
+	Key string `json:"k"`
+	ID  string `json:"id"`
+}
+
+func (c *Cursor) Encode() string {
+	content, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	content, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(content, c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Friendship - метаданные дружбы, общие для обоих пользователей.
+// У дружбы, созданной до появления метаданных, CreatedAt и Initiator пустые
+type Friendship struct {
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	Initiator string    `json:"initiator" bson:"initiator"`
+	Label     string    `json:"label,omitempty" bson:"label,omitempty"`
+	Closeness *float64  `json:"closeness,omitempty" bson:"closeness,omitempty"`
+}
+
+func NewFriendship(initiator string) *Friendship {
+	return &Friendship{CreatedAt: time.Now().UTC().Truncate(time.Millisecond), Initiator: initiator}
+}
+
+// FriendshipUpdate - изменяемые поля дружбы, nil - поле не меняется
+type FriendshipUpdate struct {
+	Label     *string  `json:"label"`
+	Closeness *float64 `json:"closeness"`
+}
+
+func (f *Friendship) Apply(update FriendshipUpdate) {
+	if update.Label != nil {
+		f.Label = *update.Label
+	}
+	if update.Closeness != nil {
+		closeness := *update.Closeness
+		f.Closeness = &closeness
+	}
+}
+
+// Suggestion - друг друга с количеством общих друзей
+type Suggestion struct {
+	User   *UserModel
+	Mutual int
+}
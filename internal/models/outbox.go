@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OutboxStatus - Instance отличает экземпляры сервиса, у локальных потребителей
+// Pending считается только по событиям после запуска экземпляра
+type OutboxStatus struct {
+	Instance  string                 `json:"instance"`
+	Records   int64                  `json:"records"`
+	Consumers []OutboxConsumerStatus `json:"consumers"`
+}
+
+// OutboxConsumerStatus - Delivered и LastDeliveredAt считаются с запуска процесса
+type OutboxConsumerStatus struct {
+	Name            string     `json:"name"`
+	Pending         int64      `json:"pending"`
+	Delivered       int        `json:"delivered"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
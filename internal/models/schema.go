@@ -0,0 +1,17 @@
+package models
+
+// IndexStatus - индекс коллекции, как его вернул MongoDB
+type IndexStatus struct {
+	Collection string
+	Name       string
+	// ключи в порядке индекса, например friends:1
+	Keys   []string
+	Unique bool
+}
+
+// SchemaReport - состояние коллекций после подготовки при старте
+type SchemaReport struct {
+	// действие валидатора $jsonSchema: error или warn
+	ValidationAction string
+	Indexes          []IndexStatus
+}
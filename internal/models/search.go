@@ -0,0 +1,26 @@
+package models
+
+// режимы поиска по имени
+const (
+	SearchPrefix   = "prefix"
+	SearchContains = "contains"
+	SearchFuzzy    = "fuzzy"
+)
+
+// SearchQuery - поиск по имени, After - курсор с позицией последнего найденного в выдаче
+type SearchQuery struct {
+	Text  string
+	Mode  string
+	Limit int
+	After *Cursor
+}
+
+type SearchResult struct {
+	User  *UserModel
+	Score float64
+}
+
+type SearchPage struct {
+	Results []*SearchResult
+	Next    *Cursor
+}
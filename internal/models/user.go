@@ -1,8 +1,51 @@
 package models
 
+import "time"
+
+// UserModel - модель хранения, друзья хранятся только как список id
 type UserModel struct {
-	ID      string       `json:"id" bson:"id"`
-	Name    string       `json:"name" bson:"name"`
-	Age     string       `json:"age" bson:"age"`
-	Friends []*UserModel `json:"friends" bson:"-"`
+	ID        string   `json:"id" bson:"id"`
+	Name      string   `json:"name" bson:"name"`
+	Age       string   `json:"age" bson:"age"`
+	FriendIDs []string `json:"friends" bson:"friends,omitempty"`
+	// метаданные дружбы по id друга
+	Friendships map[string]*Friendship `json:"-" bson:"friendships,omitempty"`
+	// id пользователей, которых заблокировал этот пользователь
+	Blocked []string `json:"-" bson:"blocked,omitempty"`
+	// время мягкого удаления, удаленный пользователь скрыт из всех чтений
+	DeletedAt *time.Time `json:"-" bson:"deleted_at,omitempty"`
+}
+
+// Copy возвращает копию, не разделяющую список друзей с оригиналом
+func (u *UserModel) Copy() *UserModel {
+	c := *u
+	c.FriendIDs = append(make([]string, 0, len(u.FriendIDs)), u.FriendIDs...)
+	if u.DeletedAt != nil {
+		deletedAt := *u.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	if u.Blocked != nil {
+		c.Blocked = append(make([]string, 0, len(u.Blocked)), u.Blocked...)
+	}
+	if u.Friendships != nil {
+		c.Friendships = make(map[string]*Friendship, len(u.Friendships))
+		for id, f := range u.Friendships {
+			meta := *f
+			c.Friendships[id] = &meta
+		}
+	}
+	return &c
+}
+
+// HideFriends убирает из списка друзей и метаданных пользователей, для которых hidden вернул true
+func (u *UserModel) HideFriends(hidden func(id string) bool) {
+	visible := u.FriendIDs[:0]
+	for _, id := range u.FriendIDs {
+		if hidden(id) {
+			delete(u.Friendships, id)
+			continue
+		}
+		visible = append(visible, id)
+	}
+	u.FriendIDs = visible
 }
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// состояния доставки вебхука
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook - подписка внешней системы на события, пустой Events - все события
+type Webhook struct {
+	ID        string    `json:"id" bson:"_id"`
+	URL       string    `json:"url" bson:"url"`
+	Events    []string  `json:"events" bson:"events"`
+	Secret    string    `json:"secret,omitempty" bson:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Wants - подписан ли вебхук на события этого типа
+func (w *Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, t := range w.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookAttempt struct {
+	Time       time.Time `json:"time" bson:"time"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+// WebhookDelivery - доставка события вебхуку, FinishedAt заполняется, когда
+// доставка удалась или закончились попытки
+type WebhookDelivery struct {
+	ID          string           `json:"id" bson:"_id"`
+	WebhookID   string           `json:"webhook_id" bson:"webhook_id"`
+	Event       *Event           `json:"event" bson:"event"`
+	Status      string           `json:"status" bson:"status"`
+	Attempts    []WebhookAttempt `json:"attempts" bson:"attempts"`
+	NextAttempt *time.Time       `json:"next_attempt,omitempty" bson:"next_attempt,omitempty"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// Copy возвращает копию, не разделяющую попытки с оригиналом
+func (d *WebhookDelivery) Copy() *WebhookDelivery {
+	c := *d
+	c.Attempts = append([]WebhookAttempt{}, d.Attempts...)
+	return &c
+}
@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// MemoryStore - outbox в памяти процесса для тестов и хранилища userMap
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// NewMemoryRelay - relay поверх MemoryStore, несколько relay на одном хранилище
+// ведут себя как экземпляры сервиса с общей базой
+func NewMemoryRelay(store *MemoryStore, interval, lease time.Duration, batch int, logger *logging.Logger) *Relay {
+	return newRelay(store, interval, lease, batch, logger)
+}
+
+// Add записывает событие, как MongoDB-репозиторий в транзакции изменения
+func (s *MemoryStore) Add(e *models.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, NewRecord(e))
+}
+
+func delivered(rec *Record, consumer string) bool {
+	for _, name := range rec.DeliveredTo {
+		if name == consumer {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) pending(rec *Record, consumer string, since time.Time) bool {
+	return !delivered(rec, consumer) && rec.CreatedAt.After(since)
+}
+
+func (s *MemoryStore) Pending(ctx context.Context, consumer string, since time.Time, limit int) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []Record
+	for _, rec := range s.records {
+		if len(records) < limit && s.pending(rec, consumer, since) {
+			records = append(records, *rec)
+		}
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, consumer, owner string, lease time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	for _, rec := range s.records {
+		if claim, ok := rec.Claims[consumer]; delivered(rec, consumer) || ok && now.Before(claim.Until) {
+			continue
+		}
+		if rec.Claims == nil {
+			rec.Claims = make(map[string]Claim)
+		}
+		rec.Claims[consumer] = Claim{By: owner, Until: now.Add(lease)}
+		copied := *rec
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, id primitive.ObjectID, consumer, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range s.records {
+		if rec.ID == id && rec.Claims[consumer].By == owner {
+			delete(rec.Claims, consumer)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delivered(ctx context.Context, id primitive.ObjectID, consumer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range s.records {
+		if rec.ID == id && !delivered(rec, consumer) {
+			rec.DeliveredTo = append(rec.DeliveredTo, consumer)
+			delete(rec.Claims, consumer)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Count(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.records)), nil
+}
+
+func (s *MemoryStore) CountPending(ctx context.Context, consumer string, since time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, rec := range s.records {
+		if s.pending(rec, consumer, since) {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// indexOptionsConflict - код ошибки создания индекса, который уже есть с другими параметрами
+const indexOptionsConflict = 85
+
+type mongoStore struct {
+	records *mongo.Collection
+}
+
+// ensureIndexes создает индексы outbox. Если срок хранения изменился,
+// у существующего TTL-индекса он меняется через collMod
+func (s *mongoStore) ensureIndexes(ctx context.Context, retention time.Duration) error {
+	ttl := bson.D{{Key: "created_at", Value: 1}}
+	seconds := int32(retention.Seconds())
+	_, err := s.records.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    ttl,
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexOptionsConflict {
+		err = s.records.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: s.records.Name()},
+			{Key: "index", Value: bson.D{{Key: "keyPattern", Value: ttl}, {Key: "expireAfterSeconds", Value: seconds}}},
+		}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("can't create outbox ttl index: %w", err)
+	}
+	_, err = s.records.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "delivered_to", Value: 1}}})
+	if err != nil {
+		return fmt.Errorf("can't create outbox indexes: %w", err)
+	}
+	return nil
+}
+
+// pendingFilter - записи, которые потребитель еще не получил, созданные после since
+func pendingFilter(consumer string, since time.Time) bson.M {
+	filter := bson.M{"delivered_to": bson.M{"$ne": consumer}}
+	if !since.IsZero() {
+		filter["created_at"] = bson.M{"$gt": since}
+	}
+	return filter
+}
+
+func (s *mongoStore) Pending(ctx context.Context, consumer string, since time.Time, limit int) ([]Record, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := s.records.Find(ctx, pendingFilter(consumer, since), opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't find outbox records: %w", err)
+	}
+	var records []Record
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("can't decode outbox records: %w", err)
+	}
+	return records, nil
+}
+
+// Claim ставит захват одним findOneAndUpdate, поэтому два экземпляра не возьмут одну запись
+func (s *mongoStore) Claim(ctx context.Context, consumer, owner string, lease time.Duration) (*Record, error) {
+	now := time.Now().UTC()
+	claim := "claims." + consumer
+	filter := pendingFilter(consumer, time.Time{})
+	filter["$or"] = bson.A{
+		bson.M{claim: bson.M{"$exists": false}},
+		bson.M{claim + ".claimed_until": bson.M{"$lte": now}},
+	}
+	update := bson.M{"$set": bson.M{claim: Claim{By: owner, Until: now.Add(lease)}}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "_id", Value: 1}}).SetReturnDocument(options.After)
+	rec := &Record{}
+	err := s.records.FindOneAndUpdate(ctx, filter, update, opts).Decode(rec)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't claim outbox record: %w", err)
+	}
+	return rec, nil
+}
+
+func (s *mongoStore) Release(ctx context.Context, id primitive.ObjectID, consumer, owner string) error {
+	claim := "claims." + consumer
+	filter := bson.M{"_id": id, claim + ".claimed_by": owner}
+	if _, err := s.records.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{claim: ""}}); err != nil {
+		return fmt.Errorf("can't release outbox record: %w", err)
+	}
+	return nil
+}
+
+func (s *mongoStore) Delivered(ctx context.Context, id primitive.ObjectID, consumer string) error {
+	update := bson.M{
+		"$addToSet": bson.M{"delivered_to": consumer},
+		"$unset":    bson.M{"claims." + consumer: ""},
+	}
+	if _, err := s.records.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return fmt.Errorf("can't save outbox checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *mongoStore) Count(ctx context.Context) (int64, error) {
+	total, err := s.records.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("can't count outbox records: %w", err)
+	}
+	return total, nil
+}
+
+func (s *mongoStore) CountPending(ctx context.Context, consumer string, since time.Time) (int64, error) {
+	pending, err := s.records.CountDocuments(ctx, pendingFilter(consumer, since))
+	if err != nil {
+		return 0, fmt.Errorf("can't count pending records: %w", err)
+	}
+	return pending, nil
+}
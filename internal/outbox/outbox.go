@@ -0,0 +1,270 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+)
+
+// Record - событие в outbox. Пишется в той же транзакции, что и изменение данных,
+// DeliveredTo - потребители, которые уже получили событие, Claims - захваты общих
+// потребителей по их именам
+type Record struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Event       models.Event       `bson:"event"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	DeliveredTo []string           `bson:"delivered_to"`
+	Claims      map[string]Claim   `bson:"claims,omitempty"`
+}
+
+// Claim - экземпляр сервиса, который обрабатывает запись, и срок, после которого
+// запись может взять другой экземпляр
+type Claim struct {
+	By    string    `bson:"claimed_by"`
+	Until time.Time `bson:"claimed_until"`
+}
+
+func NewRecord(e *models.Event) *Record {
+	now := time.Now().UTC()
+	if e.Time.IsZero() {
+		e.Time = now
+	}
+	return &Record{
+		ID:          primitive.NewObjectID(),
+		Event:       *e,
+		CreatedAt:   now,
+		DeliveredTo: []string{},
+	}
+}
+
+// Consumer получает событие, ошибка оставляет событие в очереди до следующего прохода
+type Consumer func(ctx context.Context, e *models.Event) error
+
+// Store - записи outbox, захваты и отметки о доставке
+type Store interface {
+	// Pending - до limit недоставленных потребителю записей, созданных после since, в порядке записи
+	Pending(ctx context.Context, consumer string, since time.Time, limit int) ([]Record, error)
+	// Claim захватывает для owner на lease самую раннюю недоставленную потребителю запись,
+	// которую не держит другой экземпляр. Если таких записей нет, возвращает nil
+	Claim(ctx context.Context, consumer, owner string, lease time.Duration) (*Record, error)
+	// Release снимает захват owner, запись достанется следующему Claim
+	Release(ctx context.Context, id primitive.ObjectID, consumer, owner string) error
+	// Delivered отмечает запись доставленной потребителю и снимает захват
+	Delivered(ctx context.Context, id primitive.ObjectID, consumer string) error
+	Count(ctx context.Context) (int64, error)
+	CountPending(ctx context.Context, consumer string, since time.Time) (int64, error)
+}
+
+// consumer - потребитель под именем key в outbox. Локальный потребитель получает
+// только записи, созданные после запуска relay
+type consumer struct {
+	key    string
+	handle Consumer
+	local  bool
+}
+
+// Relay раздает события из outbox потребителям. Отметка о доставке ставится
+// отдельно для каждого потребителя после успешной обработки события, поэтому
+// доставка не реже одного раза: после падения между обработкой и отметкой событие
+// придет повторно с тем же Key, и потребитель должен отбросить повтор.
+//
+// Общий потребитель (AddConsumer) получает каждое событие на одном из экземпляров
+// сервиса: экземпляр захватывает запись на lease, и другие ее не берут, пока захват
+// не снят или не истек. Порядок событий между экземплярами не сохраняется.
+// Локальный потребитель (AddLocalConsumer) получает события на каждом экземпляре
+type Relay struct {
+	store    Store
+	instance string
+	started  time.Time
+	interval time.Duration
+	lease    time.Duration
+	batch    int
+	logger   *logging.Logger
+
+	names     []string
+	consumers map[string]*consumer
+
+	mu    sync.Mutex
+	state map[string]*models.OutboxConsumerStatus
+}
+
+// NewRelay создает индексы outbox, записи старше retention удаляются Mongo
+func NewRelay(ctx context.Context, database *mongo.Database, collection string, retention, interval, lease time.Duration, batch int, logger *logging.Logger) (*Relay, error) {
+	store := &mongoStore{records: database.Collection(collection)}
+	if err := store.ensureIndexes(ctx, retention); err != nil {
+		return nil, err
+	}
+	return newRelay(store, interval, lease, batch, logger), nil
+}
+
+func newRelay(store Store, interval, lease time.Duration, batch int, logger *logging.Logger) *Relay {
+	return &Relay{
+		store:     store,
+		instance:  primitive.NewObjectID().Hex(),
+		started:   time.Now().UTC(),
+		interval:  interval,
+		lease:     lease,
+		batch:     batch,
+		logger:    logger,
+		consumers: make(map[string]*consumer),
+		state:     make(map[string]*models.OutboxConsumerStatus),
+	}
+}
+
+// AddConsumer регистрирует общего для всех экземпляров потребителя, вызывается до Run
+func (r *Relay) AddConsumer(name string, c Consumer) {
+	r.add(name, &consumer{key: name, handle: c})
+}
+
+// AddLocalConsumer регистрирует потребителя, который получает события на этом экземпляре,
+// например для шины подписчиков процесса. Вызывается до Run
+func (r *Relay) AddLocalConsumer(name string, c Consumer) {
+	r.add(name, &consumer{key: name + "@" + r.instance, handle: c, local: true})
+}
+
+func (r *Relay) add(name string, c *consumer) {
+	r.names = append(r.names, name)
+	r.consumers[name] = c
+	r.state[name] = &models.OutboxConsumerStatus{Name: name}
+}
+
+// since - с какого момента потребитель получает записи, нулевое время - все записи
+func (r *Relay) since(c *consumer) time.Time {
+	if c.local {
+		return r.started
+	}
+	return time.Time{}
+}
+
+// Run передает события каждому потребителю в своей горутине до отмены ctx,
+// так что долгая доставка одного потребителя не задерживает остальных
+func (r *Relay) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, name := range r.names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			r.run(ctx, name)
+		}(name)
+	}
+	wg.Wait()
+}
+
+// run раз в interval передает потребителю недоставленные события
+func (r *Relay) run(ctx context.Context, name string) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		// полная пачка - значит, могли остаться еще события
+		for {
+			n, err := r.relay(ctx, name)
+			if err != nil {
+				r.logger.Err(err).Str("consumer", name).Msg("outbox relay failed")
+			}
+			if err != nil || n < r.batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay передает потребителю одну пачку событий
+func (r *Relay) relay(ctx context.Context, name string) (int, error) {
+	c := r.consumers[name]
+	if !c.local {
+		return r.relayClaimed(ctx, name)
+	}
+	records, err := r.store.Pending(ctx, c.key, r.since(c), r.batch)
+	if err != nil {
+		return 0, err
+	}
+	for i, rec := range records {
+		if err = r.deliver(ctx, name, rec); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
+
+// relayClaimed передает общему потребителю до batch событий, захватывая их по одному
+func (r *Relay) relayClaimed(ctx context.Context, name string) (int, error) {
+	for i := 0; i < r.batch; i++ {
+		rec, err := r.store.Claim(ctx, name, r.instance, r.lease)
+		if err != nil || rec == nil {
+			return i, err
+		}
+		if err = r.deliver(ctx, name, *rec); err != nil {
+			// запись достанется следующему проходу этого или другого экземпляра
+			if releaseErr := r.store.Release(ctx, rec.ID, name, r.instance); releaseErr != nil {
+				r.logger.Err(releaseErr).Str("consumer", name).Msg("can't release outbox record")
+			}
+			return i, err
+		}
+	}
+	return r.batch, nil
+}
+
+// deliver передает событие потребителю и отмечает его доставленным
+func (r *Relay) deliver(ctx context.Context, name string, rec Record) error {
+	c := r.consumers[name]
+	e := rec.Event
+	e.Key = rec.ID.Hex()
+	if err := c.handle(ctx, &e); err != nil {
+		r.failed(name, err)
+		return fmt.Errorf("consumer rejected event %s: %w", e.Key, err)
+	}
+	if err := r.store.Delivered(ctx, rec.ID, c.key); err != nil {
+		r.failed(name, err)
+		return err
+	}
+	r.delivered(name)
+	return nil
+}
+
+func (r *Relay) failed(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state[name].LastError = err.Error()
+}
+
+func (r *Relay) delivered(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	s := r.state[name]
+	s.Delivered++
+	s.LastDeliveredAt = &now
+	s.LastError = ""
+}
+
+// Status - размер outbox и отставание каждого потребителя
+func (r *Relay) Status(ctx context.Context) (*models.OutboxStatus, error) {
+	total, err := r.store.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := &models.OutboxStatus{Instance: r.instance, Records: total, Consumers: make([]models.OutboxConsumerStatus, 0, len(r.names))}
+	for _, name := range r.names {
+		c := r.consumers[name]
+		pending, err := r.store.CountPending(ctx, c.key, r.since(c))
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		s := *r.state[name]
+		r.mu.Unlock()
+		s.Pending = pending
+		status.Consumers = append(status.Consumers, s)
+	}
+	return status, nil
+}
@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Нужен запущенный MongoDB: MONGO_TEST_URI=mongodb://localhost:27017 go test ./internal/outbox
+func testDatabase(t *testing.T) *mongo.Database {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database("test_outbox")
+	database.Drop(ctx)
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return database
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	relay, err := NewRelay(ctx, database, "outbox", time.Hour, time.Millisecond, time.Minute, 2, &logging.Logger{Logger: zerolog.Nop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		rec := NewRecord(&models.Event{Type: models.EventUserCreated, UserIDs: []string{id}})
+		if _, err = relay.store.(*mongoStore).records.InsertOne(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string][]string{}
+	keys := map[string]bool{}
+	fail := true
+	relay.AddConsumer("events", func(ctx context.Context, e *models.Event) error {
+		got["events"] = append(got["events"], e.UserIDs[0])
+		keys[e.Key] = true
+		return nil
+	})
+	// второй потребитель отказывается от первого события один раз
+	relay.AddConsumer("webhooks", func(ctx context.Context, e *models.Event) error {
+		if fail {
+			fail = false
+			return errors.New("receiver is down")
+		}
+		got["webhooks"] = append(got["webhooks"], e.UserIDs[0])
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		for _, name := range relay.names {
+			relay.relay(ctx, name)
+		}
+	}
+
+	expected := map[string][]string{"events": {"1", "2", "3"}, "webhooks": {"1", "2", "3"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong deliveries: got %v want %v", got, expected)
+	}
+	if len(keys) != 3 {
+		t.Errorf("event keys are not unique: got %v", keys)
+	}
+
+	status, err := relay.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Records != 3 || status.Consumers[0].Pending != 0 || status.Consumers[1].Pending != 0 || status.Consumers[1].LastError != "" {
+		t.Errorf("wrong status: got %+v", status)
+	}
+}
+
+func TestNewRelay_RetentionChange(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	if _, err := NewRelay(ctx, database, "outbox", time.Hour, time.Second, time.Minute, 10, logger); err != nil {
+		t.Fatal(err)
+	}
+	// другой OUTBOX_RETENTION после перезапуска
+	if _, err := NewRelay(ctx, database, "outbox", 2*time.Hour, time.Second, time.Minute, 10, logger); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := database.Collection("outbox").Indexes().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var specs []struct {
+		Name               string `bson:"name"`
+		ExpireAfterSeconds int32  `bson:"expireAfterSeconds"`
+	}
+	if err = cursor.All(ctx, &specs); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range specs {
+		if spec.Name == "created_at_1" && spec.ExpireAfterSeconds != 7200 {
+			t.Errorf("ttl is not updated: got %d want 7200", spec.ExpireAfterSeconds)
+		}
+	}
+}
+
+func TestMongoStore_Claim(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	store := &mongoStore{records: database.Collection("outbox")}
+	if err := store.ensureIndexes(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2"} {
+		if _, err := store.records.InsertOne(ctx, NewRecord(&models.Event{Type: models.EventUserCreated, UserIDs: []string{id}})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// два экземпляра получают разные записи, третьему ничего не остается
+	first, err := store.Claim(ctx, "webhooks", "a", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Claim(ctx, "webhooks", "b", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == nil || second == nil || first.ID == second.ID || first.Claims["webhooks"].By != "a" {
+		t.Fatalf("wrong claims: got %+v, %+v", first, second)
+	}
+	if rec, err := store.Claim(ctx, "webhooks", "c", time.Minute); err != nil || rec != nil {
+		t.Errorf("claimed record is claimed again: got %+v, %v", rec, err)
+	}
+	// другой потребитель захватывает записи независимо
+	if rec, err := store.Claim(ctx, "audit", "c", time.Minute); err != nil || rec == nil || rec.ID != first.ID {
+		t.Errorf("claim of another consumer: got %+v, %v", rec, err)
+	}
+
+	// после lease запись берет другой экземпляр
+	time.Sleep(60 * time.Millisecond)
+	taken, err := store.Claim(ctx, "webhooks", "c", time.Minute)
+	if err != nil || taken == nil || taken.ID != first.ID {
+		t.Errorf("expired claim is not taken: got %+v, %v", taken, err)
+	}
+	// отпустить запись может только ее владелец
+	if err = store.Release(ctx, second.ID, "webhooks", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := store.Claim(ctx, "webhooks", "a", time.Minute); rec != nil {
+		t.Errorf("foreign release removed the claim: got %+v", rec)
+	}
+	if err = store.Delivered(ctx, second.ID, "webhooks"); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := store.CountPending(ctx, "webhooks", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 {
+		t.Errorf("wrong pending: got %d want 1", pending)
+	}
+}
@@ -0,0 +1,234 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore - outbox в памяти, failDelivered ломает сохранение отметки о доставке
+type fakeStore struct {
+	*MemoryStore
+	failDelivered error
+}
+
+func newFakeStore(userIDs ...string) *fakeStore {
+	s := &fakeStore{MemoryStore: NewMemoryStore()}
+	for _, id := range userIDs {
+		s.Add(&models.Event{Type: models.EventUserCreated, UserIDs: []string{id}})
+	}
+	return s
+}
+
+func (s *fakeStore) Delivered(ctx context.Context, id primitive.ObjectID, consumer string) error {
+	if s.failDelivered != nil {
+		return s.failDelivered
+	}
+	return s.MemoryStore.Delivered(ctx, id, consumer)
+}
+
+func TestRelay_Consumers(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore("1", "2", "3")
+	relay := newRelay(store, time.Millisecond, time.Minute, 2, &logging.Logger{Logger: zerolog.Nop()})
+
+	got := map[string][]string{}
+	fail := true
+	relay.AddConsumer("events", func(ctx context.Context, e *models.Event) error {
+		got["events"] = append(got["events"], e.UserIDs[0])
+		return nil
+	})
+	// второй потребитель отказывается от первого события один раз
+	relay.AddConsumer("webhooks", func(ctx context.Context, e *models.Event) error {
+		if fail {
+			fail = false
+			return errors.New("receiver is down")
+		}
+		got["webhooks"] = append(got["webhooks"], e.UserIDs[0])
+		return nil
+	})
+
+	n, err := relay.relay(ctx, "events")
+	if err != nil || n != 2 {
+		t.Errorf("first batch: got %d, %v want 2, nil", n, err)
+	}
+	if _, err = relay.relay(ctx, "webhooks"); err == nil {
+		t.Errorf("rejected event is not reported")
+	}
+	status, err := relay.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Records != 3 || status.Consumers[0].Pending != 1 || status.Consumers[1].Pending != 3 ||
+		status.Consumers[1].LastError != "receiver is down" {
+		t.Errorf("wrong status: got %+v", status)
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, name := range relay.names {
+			relay.relay(ctx, name)
+		}
+	}
+	expected := map[string][]string{"events": {"1", "2", "3"}, "webhooks": {"1", "2", "3"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong deliveries: got %v want %v", got, expected)
+	}
+	status, _ = relay.Status(ctx)
+	if status.Consumers[0].Pending != 0 || status.Consumers[1].Pending != 0 || status.Consumers[1].LastError != "" ||
+		status.Consumers[0].Delivered != 3 {
+		t.Errorf("wrong status after delivery: got %+v", status)
+	}
+}
+
+// без сохраненной отметки событие приходит повторно с тем же ключом
+func TestRelay_AtLeastOnce(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore("1")
+	store.failDelivered = errors.New("connection lost")
+	relay := newRelay(store, time.Millisecond, time.Minute, 10, &logging.Logger{Logger: zerolog.Nop()})
+	var keys []string
+	relay.AddConsumer("events", func(ctx context.Context, e *models.Event) error {
+		keys = append(keys, e.Key)
+		return nil
+	})
+
+	if _, err := relay.relay(ctx, "events"); err == nil {
+		t.Errorf("checkpoint error is not reported")
+	}
+	store.failDelivered = nil
+	if _, err := relay.relay(ctx, "events"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.relay(ctx, "events"); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != keys[1] || keys[0] != store.records[0].ID.Hex() {
+		t.Errorf("wrong deliveries: got keys %v", keys)
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	store := newFakeStore("1", "2", "3", "4", "5")
+	relay := newRelay(store, time.Hour, time.Minute, 2, &logging.Logger{Logger: zerolog.Nop()})
+	var mu sync.Mutex
+	var got []string
+	relay.AddConsumer("events", func(ctx context.Context, e *models.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, e.UserIDs[0])
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	// полные пачки читаются сразу, без ожидания interval
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 5 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if expected := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong deliveries: got %v want %v", got, expected)
+	}
+}
+
+// два экземпляра с общим outbox: общий потребитель получает событие один раз,
+// локальный - на каждом экземпляре, но только события после своего запуска
+func TestRelay_Instances(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore("0")
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	relays := []*Relay{
+		newRelay(store, time.Millisecond, time.Minute, 10, logger),
+		newRelay(store, time.Millisecond, time.Minute, 10, logger),
+	}
+	var mu sync.Mutex
+	got := map[string][]string{}
+	for i, relay := range relays {
+		local := []string{"first", "second"}[i]
+		relay.AddLocalConsumer("events", func(ctx context.Context, e *models.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[local] = append(got[local], e.UserIDs[0])
+			return nil
+		})
+		relay.AddConsumer("webhooks", func(ctx context.Context, e *models.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got["webhooks"] = append(got["webhooks"], e.UserIDs[0])
+			return nil
+		})
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		store.Add(&models.Event{Type: models.EventUserCreated, UserIDs: []string{id}})
+	}
+
+	var wg sync.WaitGroup
+	for _, relay := range relays {
+		wg.Add(1)
+		go func(relay *Relay) {
+			defer wg.Done()
+			for _, name := range relay.names {
+				if _, err := relay.relay(ctx, name); err != nil {
+					t.Error(err)
+				}
+			}
+		}(relay)
+	}
+	wg.Wait()
+
+	sort.Strings(got["webhooks"])
+	expected := map[string][]string{"first": {"1", "2", "3"}, "second": {"1", "2", "3"}, "webhooks": {"0", "1", "2", "3"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong deliveries: got %v want %v", got, expected)
+	}
+	status, err := relays[0].Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Instance == relays[1].instance || status.Consumers[0].Pending != 0 || status.Consumers[1].Pending != 0 {
+		t.Errorf("wrong status: got %+v", status)
+	}
+}
+
+// запись, захваченную упавшим экземпляром, другой берет после окончания lease
+func TestRelay_ExpiredClaim(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore("1")
+	if _, err := store.Claim(ctx, "webhooks", "crashed", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	relay := newRelay(store, time.Millisecond, time.Minute, 10, &logging.Logger{Logger: zerolog.Nop()})
+	n := 0
+	relay.AddConsumer("webhooks", func(ctx context.Context, e *models.Event) error {
+		n++
+		return nil
+	})
+
+	if _, err := relay.relay(ctx, "webhooks"); err != nil || n != 0 {
+		t.Errorf("claimed record is delivered: got %d, %v", n, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := relay.relay(ctx, "webhooks"); err != nil || n != 1 {
+		t.Errorf("expired claim is not taken: got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule - Limit запросов за Period. Корзина вмещает Limit токенов
+// и пополняется равномерно, полностью за Period
+type Rule struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRule разбирает правило вида 10/1m, 0 - без ограничения
+func ParseRule(s string) (Rule, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) != 2 {
+		return Rule{}, errors.New("rate limit must look like 10/1m: " + s)
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 0 {
+		return Rule{}, errors.New("wrong rate limit count: " + s)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return Rule{}, errors.New("wrong rate limit period: " + s)
+	}
+	return Rule{Limit: limit, Period: period}, nil
+}
+
+func (r Rule) Disabled() bool {
+	return r.Limit == 0
+}
+
+// пополнение токенов в секунду
+func (r Rule) rate() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// Result - состояние корзины после запроса
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// через сколько корзина наполнится полностью
+	Reset time.Duration
+	// через сколько появится токен, только для отклоненного запроса
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rule   Rule
+}
+
+// fill добавляет токены, накопившиеся с последнего запроса
+func (b *bucket) fill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.rule.Limit), b.tokens+elapsed*b.rule.rate())
+		b.last = now
+	}
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.fill(now)
+	return b.tokens >= float64(b.rule.Limit)
+}
+
+// Store хранит корзины в памяти процесса. Полные корзины ничем не отличаются
+// от новых, поэтому раз в sweepEvery они удаляются
+type Store struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+const sweepEvery = time.Minute
+
+func NewStore() *Store {
+	return &Store{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take забирает токен из корзины key
+func (s *Store) Take(key string, rule Rule) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok || b.rule != rule {
+		b = &bucket{tokens: float64(rule.Limit), last: now, rule: rule}
+		s.buckets[key] = b
+	}
+	b.fill(now)
+
+	res := Result{Limit: rule.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rule.rate())
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(rule.Limit) - b.tokens) / rule.rate())
+	return res
+}
+
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepEvery {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.full(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Len - число корзин, которые сейчас хранятся
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func testStore() (*Store, *time.Time) {
+	now := time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC)
+	s := NewStore()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestParseRule(t *testing.T) {
+	testTable := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{"10/1m", Rule{Limit: 10, Period: time.Minute}, false},
+		{" 0/1s ", Rule{Limit: 0, Period: time.Second}, false},
+		{"10", Rule{}, true},
+		{"x/1m", Rule{}, true},
+		{"-1/1m", Rule{}, true},
+		{"10/0s", Rule{}, true},
+	}
+	for _, tc := range testTable {
+		got, err := ParseRule(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseRule(%q) = %+v, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestStore_Take(t *testing.T) {
+	s, now := testStore()
+	rule := Rule{Limit: 3, Period: 3 * time.Second}
+
+	for i := 2; i >= 0; i-- {
+		res := s.Take("a", rule)
+		if !res.Allowed || res.Remaining != i || res.Limit != 3 {
+			t.Fatalf("request %d: got %+v", 3-i, res)
+		}
+	}
+	res := s.Take("a", rule)
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("want rejected with retry after 1s, got %+v", res)
+	}
+	// у другого клиента своя корзина
+	if res = s.Take("b", rule); !res.Allowed {
+		t.Errorf("other key rejected: %+v", res)
+	}
+
+	// за полторы секунды накопился один токен и половина следующего
+	*now = now.Add(1500 * time.Millisecond)
+	if res = s.Take("a", rule); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("want one token after refill, got %+v", res)
+	}
+	if res = s.Take("a", rule); res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("want retry after 500ms, got %+v", res)
+	}
+
+	// новое правило для ключа начинает корзину заново
+	if res = s.Take("a", Rule{Limit: 5, Period: time.Second}); !res.Allowed || res.Remaining != 4 {
+		t.Errorf("want fresh bucket for new rule, got %+v", res)
+	}
+}
+
+func TestStore_Sweep(t *testing.T) {
+	s, now := testStore()
+	rule := Rule{Limit: 1, Period: time.Second}
+	s.Take("a", rule)
+	*now = now.Add(sweepEvery)
+	s.Take("b", rule)
+	if s.Len() != 1 {
+		t.Errorf("full bucket is not removed: %d buckets", s.Len())
+	}
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	s := NewStore()
+	rule := Rule{Limit: 100, Period: time.Hour}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if s.Take("a", rule).Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 100 {
+		t.Errorf("want exactly 100 allowed requests, got %d", allowed)
+	}
+}
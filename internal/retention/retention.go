@@ -0,0 +1,59 @@
+package retention
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"time"
+)
+
+type Repository interface {
+	Restore(ctx context.Context, id string, deletedAfter time.Time) error
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+}
+
+// Service восстанавливает удаленных пользователей в течение срока хранения
+// и окончательно удаляет их после его окончания
+type Service struct {
+	repo      Repository
+	retention time.Duration
+	interval  time.Duration
+	logger    *logging.Logger
+}
+
+func NewService(repo Repository, retention, interval time.Duration, logger *logging.Logger) *Service {
+	return &Service{
+		repo:      repo,
+		retention: retention,
+		interval:  interval,
+		logger:    logger,
+	}
+}
+
+// Restore возвращает пользователя, если с момента удаления прошло не больше срока хранения
+func (s *Service) Restore(ctx context.Context, id string) error {
+	return s.repo.Restore(ctx, id, time.Now().Add(-s.retention))
+}
+
+// Purge окончательно удаляет пользователей с истекшим сроком хранения
+func (s *Service) Purge(ctx context.Context) (int, error) {
+	return s.repo.Purge(ctx, time.Now().Add(-s.retention))
+}
+
+// Run запускает Purge сразу и затем каждые interval до отмены ctx
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.Purge(ctx)
+		if err != nil {
+			s.logger.Err(err).Msg("purge failed")
+		} else if n > 0 {
+			s.logger.Info().Int("users", n).Msg("deleted users purged")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package search
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"strconv"
+)
+
+// Index - триграммный индекс имен в памяти, отбирает кандидатов для Score
+type Index struct {
+	names    map[string]string
+	trigrams map[string]map[string]struct{}
+}
+
+func NewIndex() *Index {
+	return &Index{
+		names:    make(map[string]string),
+		trigrams: make(map[string]map[string]struct{}),
+	}
+}
+
+func (x *Index) Add(id, name string) {
+	x.Remove(id)
+	x.names[id] = name
+	for _, t := range Trigrams(name) {
+		if x.trigrams[t] == nil {
+			x.trigrams[t] = make(map[string]struct{})
+		}
+		x.trigrams[t][id] = struct{}{}
+	}
+}
+
+func (x *Index) Remove(id string) {
+	name, ok := x.names[id]
+	if !ok {
+		return
+	}
+	delete(x.names, id)
+	for _, t := range Trigrams(name) {
+		delete(x.trigrams[t], id)
+		if len(x.trigrams[t]) == 0 {
+			delete(x.trigrams, t)
+		}
+	}
+}
+
+// Candidates - id пользователей, имена которых могут подойти под запрос.
+// Для префикса и подстроки нужны все триграммы запроса, для нечеткого поиска - любая
+func (x *Index) Candidates(mode, query string) []string {
+	var set map[string]struct{}
+	switch mode {
+	case models.SearchFuzzy:
+		set = make(map[string]struct{})
+		for _, t := range Trigrams(query) {
+			for id := range x.trigrams[t] {
+				set[id] = struct{}{}
+			}
+		}
+	default:
+		inner := Inner(query)
+		if len(inner) == 0 {
+			// запрос короче триграммы, проверяются все имена
+			set = make(map[string]struct{}, len(x.names))
+			for id := range x.names {
+				set[id] = struct{}{}
+			}
+			break
+		}
+		for i, t := range inner {
+			if i == 0 {
+				set = make(map[string]struct{}, len(x.trigrams[t]))
+				for id := range x.trigrams[t] {
+					set[id] = struct{}{}
+				}
+				continue
+			}
+			for id := range set {
+				if _, ok := x.trigrams[t][id]; !ok {
+					delete(set, id)
+				}
+			}
+		}
+	}
+	ids := make([]string, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Page ранжирует результаты и возвращает страницу после курсора
+func Page(results []*models.SearchResult, q models.SearchQuery) (*models.SearchPage, error) {
+	Rank(results)
+	start := 0
+	if q.After != nil {
+		pos, err := strconv.Atoi(q.After.Key)
+		if err != nil || pos < 0 {
+			return nil, models.ErrInvalidCursor
+		}
+		start = pos + 1
+		// если выдача изменилась, ищем последнего найденного по id
+		if pos >= len(results) || results[pos].User.ID != q.After.ID {
+			for i, r := range results {
+				if r.User.ID == q.After.ID {
+					start = i + 1
+				}
+			}
+		}
+	}
+	page := &models.SearchPage{Results: []*models.SearchResult{}}
+	if start >= len(results) {
+		return page, nil
+	}
+	page.Results = results[start:]
+	if len(page.Results) > q.Limit {
+		page.Results = page.Results[:q.Limit]
+		last := start + q.Limit - 1
+		page.Next = &models.Cursor{Key: strconv.Itoa(last), ID: results[last].User.ID}
+	}
+	return page, nil
+}
@@ -0,0 +1,171 @@
+// Package search - сравнение имен для поиска пользователей, общее для всех хранилищ
+package search
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MinFuzzyScore - минимальная похожесть для нечеткого поиска
+const MinFuzzyScore = 0.5
+
+// Normalize приводит имя к нижнему регистру и схлопывает пробелы
+func Normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// Words - хвосты нормализованного имени, начинающиеся с каждого слова:
+// "helen de vries" -> "helen de vries", "de vries", "vries"
+func Words(name string) []string {
+	fields := strings.Fields(Normalize(name))
+	words := make([]string, 0, len(fields))
+	for i := range fields {
+		words = append(words, strings.Join(fields[i:], " "))
+	}
+	return words
+}
+
+// Trigrams - триграммы слов имени с пробелами по краям, как в pg_trgm
+func Trigrams(s string) []string {
+	set := make(map[string]struct{})
+	for _, word := range strings.Fields(Normalize(s)) {
+		r := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for t := range set {
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Inner - триграммы внутри слов строки без дополнения, они есть в Trigrams
+// любого имени, содержащего s
+func Inner(s string) []string {
+	set := make(map[string]struct{})
+	for _, word := range strings.Fields(Normalize(s)) {
+		r := []rune(word)
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for t := range set {
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Distance - расстояние Дамерау-Левенштейна (перестановка соседних букв - одна правка)
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// similarity - 1 для одинаковых строк, 0 для совсем разных
+func similarity(a, b string) float64 {
+	n := utf8.RuneCountInString(a)
+	if m := utf8.RuneCountInString(b); m > n {
+		n = m
+	}
+	if n == 0 {
+		return 1
+	}
+	return 1 - float64(Distance(a, b))/float64(n)
+}
+
+// Score сравнивает запрос с именем. Чем ближе совпадение к началу имени и чем
+// большую часть имени оно покрывает, тем выше оценка, точное совпадение - 1
+func Score(mode, query, name string) (float64, bool) {
+	q, n := Normalize(query), Normalize(name)
+	if q == "" {
+		return 0, false
+	}
+	if q == n {
+		return 1, true
+	}
+	switch mode {
+	case models.SearchPrefix:
+		for _, w := range Words(n) {
+			if strings.HasPrefix(w, q) {
+				return coverage(q, n, len(n)-len(w)), true
+			}
+		}
+	case models.SearchContains:
+		if pos := strings.Index(n, q); pos >= 0 {
+			return coverage(q, n, pos), true
+		}
+	case models.SearchFuzzy:
+		// запрос сравнивается и с именем целиком, и с каждым словом, и с началом слова той же длины
+		best := similarity(q, n)
+		for _, w := range strings.Fields(n) {
+			if s := similarity(q, w); s > best {
+				best = s
+			}
+			if r := []rune(w); len(r) > len([]rune(q)) {
+				if s := 0.9 * similarity(q, string(r[:len([]rune(q))])); s > best {
+					best = s
+				}
+			}
+		}
+		if best >= MinFuzzyScore {
+			return best, true
+		}
+	}
+	return 0, false
+}
+
+func coverage(q, n string, pos int) float64 {
+	return float64(len(q)) / float64(len(n)) * (1 - 0.5*float64(pos)/float64(len(n)))
+}
+
+// Rank сортирует результаты по убыванию оценки, при равенстве - по имени и id
+func Rank(results []*models.SearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		na, nb := Normalize(a.User.Name), Normalize(b.User.Name)
+		if na != nb {
+			return na < nb
+		}
+		return a.User.ID < b.User.ID
+	})
+}
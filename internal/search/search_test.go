@@ -0,0 +1,90 @@
+package search
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"sort"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	testTable := []struct {
+		a, b     string
+		expected int
+	}{
+		{"john", "john", 0},
+		{"jhon", "john", 1},
+		{"jon", "john", 1},
+		{"helen", "hellen", 1},
+		{"", "bob", 3},
+		{"анна", "ана", 1},
+	}
+	for _, tc := range testTable {
+		if got := Distance(tc.a, tc.b); got != tc.expected {
+			t.Errorf("Distance(%q, %q): got %d, expected %d", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	testTable := []struct {
+		name  string
+		mode  string
+		query string
+		user  string
+		ok    bool
+	}{
+		{name: "exact", mode: models.SearchPrefix, query: "john", user: "John", ok: true},
+		{name: "prefix", mode: models.SearchPrefix, query: "hel", user: "Helen", ok: true},
+		{name: "prefix of word", mode: models.SearchPrefix, query: "vri", user: "Helen de Vries", ok: true},
+		{name: "not a prefix", mode: models.SearchPrefix, query: "len", user: "Helen", ok: false},
+		{name: "contains", mode: models.SearchContains, query: "len", user: "Helen", ok: true},
+		{name: "fuzzy typo", mode: models.SearchFuzzy, query: "jhon", user: "John", ok: true},
+		{name: "fuzzy too far", mode: models.SearchFuzzy, query: "bob", user: "John", ok: false},
+	}
+	for _, tc := range testTable {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := Score(tc.mode, tc.query, tc.user); ok != tc.ok {
+				t.Errorf("got %v, expected %v", ok, tc.ok)
+			}
+		})
+	}
+
+	exact, _ := Score(models.SearchPrefix, "hel", "Hel")
+	short, _ := Score(models.SearchPrefix, "hel", "Helen")
+	long, _ := Score(models.SearchPrefix, "hel", "Helen de Vries")
+	inner, _ := Score(models.SearchPrefix, "vri", "Helen de Vries")
+	if !(exact > short && short > long && long > inner) {
+		t.Errorf("wrong ranking: %v, %v, %v, %v", exact, short, long, inner)
+	}
+}
+
+func TestIndex_Candidates(t *testing.T) {
+	x := NewIndex()
+	x.Add("1", "John")
+	x.Add("2", "Helen")
+	x.Add("3", "Jonathan")
+	x.Remove("3")
+
+	testTable := []struct {
+		mode     string
+		query    string
+		expected []string
+	}{
+		{mode: models.SearchPrefix, query: "joh", expected: []string{"1"}},
+		{mode: models.SearchContains, query: "le", expected: []string{"1", "2"}},
+		{mode: models.SearchFuzzy, query: "jhon", expected: []string{"1"}},
+	}
+	for _, tc := range testTable {
+		got := x.Candidates(tc.mode, tc.query)
+		sort.Strings(got)
+		if len(got) != len(tc.expected) {
+			t.Errorf("Candidates(%s, %q): got %v, expected %v", tc.mode, tc.query, got, tc.expected)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("Candidates(%s, %q): got %v, expected %v", tc.mode, tc.query, got, tc.expected)
+			}
+		}
+	}
+}
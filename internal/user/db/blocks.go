@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// checkBlocked возвращает models.ErrBlocked, если один из пользователей заблокировал другого
+func (d *db) checkBlocked(ctx context.Context, sourceId, targetId string) error {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"id": sourceId, "blocked": targetId},
+		bson.M{"id": targetId, "blocked": sourceId},
+	}}
+	n, err := d.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("can't check blocks: %w", err)
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: %s, %s\n", models.ErrBlocked, sourceId, targetId)
+	}
+	return nil
+}
+
+func (d *db) checkBlockUsers(ctx context.Context, id, targetID string) error {
+	if id == targetID {
+		err := errors.New("Пользователь " + id + " не может заблокировать сам себя\n")
+		return err
+	}
+	return d.checkUsers(ctx, id, targetID)
+}
+
+func (d *db) Block(ctx context.Context, id, targetID string) error {
+	if err := d.checkBlockUsers(ctx, id, targetID); err != nil {
+		return err
+	}
+
+	// блокировка, разрыв дружбы с обеих сторон и событие о нем одной транзакцией
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		updates := []struct {
+			id     string
+			update bson.M
+		}{
+			{id, bson.M{
+				"$addToSet": bson.M{"blocked": targetID},
+				"$pull":     bson.M{"friends": targetID},
+				"$unset":    bson.M{"friendships." + targetID: ""},
+			}},
+			{targetID, bson.M{
+				"$pull":  bson.M{"friends": id},
+				"$unset": bson.M{"friendships." + id: ""},
+			}},
+		}
+		// документы до изменения показывают, была ли дружба хотя бы с одной стороны
+		opts := options.FindOneAndUpdate().SetProjection(bson.M{"friends": 1})
+		friends := false
+		for _, u := range updates {
+			var before models.UserModel
+			if err := d.collection.FindOneAndUpdate(ctx, bson.M{"id": u.id}, u.update, opts).Decode(&before); err != nil {
+				return err
+			}
+			friends = friends || contains(before.FriendIDs, id) || contains(before.FriendIDs, targetID)
+		}
+		if !friends {
+			return nil
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{id, targetID}})
+	})
+	if err != nil {
+		return fmt.Errorf("can't block user: %w", err)
+	}
+	d.logger.Debug().Msgf("user %s blocked %s", id, targetID)
+	return nil
+}
+
+func (d *db) Unblock(ctx context.Context, id, targetID string) error {
+	updateFilter := live(bson.M{"id": id, "blocked": targetID})
+	res, err := d.collection.UpdateOne(ctx, updateFilter, bson.M{"$pull": bson.M{"blocked": targetID}})
+	if err != nil {
+		return fmt.Errorf("can't unblock user: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return models.NewError(models.ErrNotBlocked, "Пользователь "+targetID+" не заблокирован пользователем "+id+"\n")
+	}
+	d.logger.Debug().Msgf("user %s unblocked %s", id, targetID)
+	return nil
+}
+
+func (d *db) FindFriendsOfFriends(ctx context.Context, id string, limit int) ([]*models.Suggestion, error) {
+	u, err := d.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	friends, err := d.FindFriend(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return d.suggestions(ctx, u, friends, limit)
+}
+
+func (d *db) suggestions(ctx context.Context, u *models.UserModel, friends []*models.UserModel, limit int) ([]*models.Suggestion, error) {
+	mutual := friendsOfFriends(u, friends)
+	if len(mutual) == 0 {
+		return []*models.Suggestion{}, nil
+	}
+	ids := make([]string, 0, len(mutual))
+	for candidateID := range mutual {
+		ids = append(ids, candidateID)
+	}
+	candidates, err := d.findByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	d.logger.Debug().Msg("method FindFriendsOfFriends finished")
+	return rankSuggestions(u, candidates, mutual, limit), nil
+}
+
+func (d *edgeDB) Block(ctx context.Context, id, targetID string) error {
+	if err := d.checkBlockUsers(ctx, id, targetID); err != nil {
+		return err
+	}
+
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		updateOptions := bson.M{"$addToSet": bson.M{"blocked": targetID}}
+		if _, err := d.collection.UpdateOne(ctx, bson.M{"id": id}, updateOptions); err != nil {
+			return err
+		}
+		res, err := d.edges.DeleteOne(ctx, newEdge(id, targetID).key())
+		if err != nil || res.DeletedCount == 0 {
+			return err
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{id, targetID}})
+	})
+	if err != nil {
+		return fmt.Errorf("can't block user: %w", err)
+	}
+	d.logger.Debug().Msgf("user %s blocked %s", id, targetID)
+	return nil
+}
+
+func (d *edgeDB) FindFriendsOfFriends(ctx context.Context, id string, limit int) ([]*models.Suggestion, error) {
+	u, err := d.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	friends, err := d.FindFriend(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return d.suggestions(ctx, u, friends, limit)
+}
@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+)
+
+// friendsCheck - результат проверки списков друзей, общий для хранилищ
+type friendsCheck struct {
+	users int
+	// ids - id пользователей по возрастанию
+	ids []string
+	// seen - сколько пользователей с этим id
+	seen map[string]int
+	// friends - исходные списки друзей, clean - без дублей, ссылок на себя и на удаленных
+	friends map[string][]string
+	clean   map[string][]string
+	// blocked - кого заблокировал пользователь, meta - метаданные дружбы по id друга
+	blocked map[string]map[string]bool
+	meta    map[string]map[string]*models.Friendship
+	// duplicates - одинаковые id, issues - проблемы списка каждого пользователя,
+	// asymmetric - дружба без обратной стороны
+	duplicates []models.ConsistencyIssue
+	issues     map[string][]models.ConsistencyIssue
+	asymmetric []models.ConsistencyIssue
+}
+
+// checkFriends находит проблемы в списках друзей users, ничего не меняя
+func checkFriends(users []models.UserModel) *friendsCheck {
+	c := &friendsCheck{
+		users:   len(users),
+		seen:    make(map[string]int, len(users)),
+		friends: make(map[string][]string, len(users)),
+		clean:   make(map[string][]string, len(users)),
+		blocked: make(map[string]map[string]bool, len(users)),
+		meta:    make(map[string]map[string]*models.Friendship, len(users)),
+		issues:  make(map[string][]models.ConsistencyIssue),
+	}
+	// пользователи с одинаковым id (гонка в MakeID) автоматически не чинятся
+	for _, u := range users {
+		c.seen[u.ID]++
+		c.friends[u.ID] = append(c.friends[u.ID], u.FriendIDs...)
+		for _, target := range u.Blocked {
+			if c.blocked[u.ID] == nil {
+				c.blocked[u.ID] = make(map[string]bool)
+			}
+			c.blocked[u.ID][target] = true
+		}
+		for friend, meta := range u.Friendships {
+			if c.meta[u.ID] == nil {
+				c.meta[u.ID] = make(map[string]*models.Friendship)
+			}
+			c.meta[u.ID][friend] = meta
+		}
+	}
+	c.ids = make([]string, 0, len(c.friends))
+	for id := range c.friends {
+		c.ids = append(c.ids, id)
+	}
+	sort.Strings(c.ids)
+	for _, id := range c.ids {
+		if c.seen[id] > 1 {
+			c.duplicates = append(c.duplicates, models.ConsistencyIssue{Type: models.IssueDuplicateUser, UserID: id, Count: c.seen[id]})
+		}
+	}
+
+	// чистим дубли, ссылки на себя, на удаленных и на заблокированных пользователей
+	for _, id := range c.ids {
+		if c.seen[id] > 1 {
+			c.clean[id] = c.friends[id]
+			continue
+		}
+		issues := make([]models.ConsistencyIssue, 0)
+		count := make(map[string]int)
+		result := make([]string, 0, len(c.friends[id]))
+		for _, friend := range c.friends[id] {
+			count[friend]++
+			switch {
+			case friend == id:
+				if count[friend] == 1 {
+					issues = append(issues, models.ConsistencyIssue{Type: models.IssueSelf, UserID: id, FriendID: friend})
+				}
+			case c.seen[friend] == 0:
+				if count[friend] == 1 {
+					issues = append(issues, models.ConsistencyIssue{Type: models.IssueDangling, UserID: id, FriendID: friend})
+				}
+			case c.isBlocked(id, friend):
+				if count[friend] == 1 {
+					issues = append(issues, models.ConsistencyIssue{Type: models.IssueBlocked, UserID: id, FriendID: friend})
+				}
+			case count[friend] == 2:
+				issues = append(issues, models.ConsistencyIssue{Type: models.IssueDuplicate, UserID: id, FriendID: friend})
+			case count[friend] == 1:
+				result = append(result, friend)
+			}
+		}
+		for i := range issues {
+			if issues[i].Type == models.IssueDuplicate {
+				issues[i].Count = count[issues[i].FriendID]
+			}
+		}
+		c.clean[id] = result
+		if len(issues) > 0 {
+			c.issues[id] = issues
+		}
+	}
+
+	// дружба должна быть взаимной, недостающая сторона - у friend.
+	// Дружба заблокированных пользователей не восстанавливается
+	for _, id := range c.ids {
+		for _, friend := range c.clean[id] {
+			if !contains(c.clean[friend], id) && !c.isBlocked(id, friend) {
+				c.asymmetric = append(c.asymmetric, models.ConsistencyIssue{Type: models.IssueAsymmetric, UserID: friend, FriendID: id})
+			}
+		}
+	}
+	return c
+}
+
+// isBlocked сообщает, заблокировал ли один из пользователей другого
+func (c *friendsCheck) isBlocked(id, friend string) bool {
+	return c.blocked[id][friend] || c.blocked[friend][id]
+}
+
+// reverseMeta - метаданные дружбы со стороны, где она есть, для восстановления обратной
+func (c *friendsCheck) reverseMeta(issue models.ConsistencyIssue) *models.Friendship {
+	if meta := c.meta[issue.FriendID][issue.UserID]; meta != nil {
+		copied := *meta
+		if meta.Closeness != nil {
+			closeness := *meta.Closeness
+			copied.Closeness = &closeness
+		}
+		return &copied
+	}
+	return nil
+}
+
+// report собирает отчет: дубли id, проблемы списков по id пользователя, несимметричная дружба
+func (c *friendsCheck) report() *models.ConsistencyReport {
+	report := &models.ConsistencyReport{Users: c.users, Issues: make([]models.ConsistencyIssue, 0)}
+	report.Issues = append(report.Issues, c.duplicates...)
+	for _, id := range c.ids {
+		report.Issues = append(report.Issues, c.issues[id]...)
+	}
+	report.Issues = append(report.Issues, c.asymmetric...)
+	for _, issue := range report.Issues {
+		if issue.Fixed {
+			report.Fixed++
+		}
+	}
+	return report
+}
+
+// Check проверяет списки друзей во всей коллекции, с fix = true исправляет найденное.
+// Повторный запуск после исправления ничего не меняет
+func (d *db) Check(ctx context.Context, fix bool) (*models.ConsistencyReport, error) {
+	opts := options.Find().SetProjection(bson.D{
+		{Key: "id", Value: 1}, {Key: "friends", Value: 1}, {Key: "friendships", Value: 1}, {Key: "blocked", Value: 1},
+	})
+	cursor, err := d.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't scan collection: %w", err)
+	}
+	var docs []models.UserModel
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("can't decode users: %w", err)
+	}
+
+	c := checkFriends(docs)
+	if fix {
+		for _, id := range c.ids {
+			issues := c.issues[id]
+			if len(issues) == 0 {
+				continue
+			}
+			fixed, err := d.replaceFriends(ctx, id, c.friends[id], c.clean[id])
+			if err != nil {
+				return nil, err
+			}
+			for i := range issues {
+				issues[i].Fixed = fixed
+			}
+		}
+		for i, issue := range c.asymmetric {
+			if c.seen[issue.UserID] != 1 {
+				continue
+			}
+			// пользователь мог заблокировать друга после проверки
+			updateFilter := bson.D{{Key: "id", Value: issue.UserID}, {Key: "blocked", Value: bson.D{{Key: "$ne", Value: issue.FriendID}}}}
+			updateOptions := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "friends", Value: issue.FriendID}}}}
+			if meta := c.reverseMeta(issue); meta != nil {
+				updateOptions = append(updateOptions, bson.E{Key: "$set", Value: bson.D{{Key: "friendships." + issue.FriendID, Value: meta}}})
+			}
+			res, err := d.collection.UpdateOne(ctx, updateFilter, updateOptions)
+			if err != nil {
+				return nil, fmt.Errorf("can't add friend %s to user %s: %w", issue.FriendID, issue.UserID, err)
+			}
+			if res.MatchedCount == 0 {
+				d.logger.Warn().Str("user", issue.UserID).Str("friend", issue.FriendID).Msg("friend blocked during check, skipped")
+				continue
+			}
+			c.asymmetric[i].Fixed = true
+			d.logger.Info().Str("user", issue.UserID).Str("friend", issue.FriendID).Msg("restored reverse friendship")
+		}
+	}
+	report := c.report()
+	d.logger.Debug().Msgf("method Check finished with %d issues", len(report.Issues))
+	return report, nil
+}
+
+// Check для хранилища в памяти: те же проверки, что и для MongoDB. Пользователь
+// с id, повторяющим чужой, может попасть сюда только с переданной в NewRepository картой
+func (r *repository) Check(ctx context.Context, fix bool) (*models.ConsistencyReport, error) {
+	users := make([]models.UserModel, 0, len(r.storage))
+	keys := make([]string, 0, len(r.storage))
+	for key, u := range r.storage {
+		users = append(users, models.UserModel{ID: u.ID, FriendIDs: u.FriendIDs, Friendships: u.Friendships, Blocked: u.Blocked})
+		keys = append(keys, key)
+	}
+
+	c := checkFriends(users)
+	if fix {
+		byID := make(map[string]*models.UserModel, len(keys))
+		for _, key := range keys {
+			byID[r.storage[key].ID] = r.storage[key]
+		}
+		for _, id := range c.ids {
+			issues := c.issues[id]
+			if len(issues) == 0 {
+				continue
+			}
+			u := byID[id]
+			for _, friend := range u.FriendIDs {
+				if !contains(c.clean[id], friend) {
+					delete(u.Friendships, friend)
+				}
+			}
+			u.FriendIDs = append([]string(nil), c.clean[id]...)
+			for i := range issues {
+				issues[i].Fixed = true
+			}
+		}
+		for i, issue := range c.asymmetric {
+			if c.seen[issue.UserID] != 1 {
+				continue
+			}
+			u := byID[issue.UserID]
+			if !contains(u.FriendIDs, issue.FriendID) {
+				u.FriendIDs = append(u.FriendIDs, issue.FriendID)
+			}
+			if meta := c.reverseMeta(issue); meta != nil {
+				if u.Friendships == nil {
+					u.Friendships = make(map[string]*models.Friendship)
+				}
+				u.Friendships[issue.FriendID] = meta
+			}
+			c.asymmetric[i].Fixed = true
+		}
+	}
+	report := c.report()
+	r.logger.Debug().Msgf("method Check finished with %d issues", len(report.Issues))
+	return report, nil
+}
+
+// replaceFriends заменяет список друзей, только если он не менялся с момента проверки
+func (d *db) replaceFriends(ctx context.Context, id string, old, friends []string) (bool, error) {
+	updateFilter := bson.D{{Key: "id", Value: id}, {Key: "friends", Value: old}}
+	updateOptions := bson.D{{Key: "$set", Value: bson.D{{Key: "friends", Value: friends}}}}
+	// метаданные удаленных из списка друзей тоже убираем
+	unset := bson.D{}
+	removed := make(map[string]bool)
+	for _, friend := range old {
+		if !contains(friends, friend) && !removed[friend] {
+			removed[friend] = true
+			unset = append(unset, bson.E{Key: "friendships." + friend, Value: ""})
+		}
+	}
+	if len(unset) > 0 {
+		updateOptions = append(updateOptions, bson.E{Key: "$unset", Value: unset})
+	}
+	res, err := d.collection.UpdateOne(ctx, updateFilter, updateOptions)
+	if err != nil {
+		return false, fmt.Errorf("can't update friends of user %s: %w", id, err)
+	}
+	if res.MatchedCount == 0 {
+		d.logger.Warn().Str("user", id).Msg("friends changed during check, skipped")
+		return false, nil
+	}
+	d.logger.Info().Str("user", id).Strs("old", old).Strs("new", friends).Msg("friends list repaired")
+	return true, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepository_Check(t *testing.T) {
+	ctx := context.Background()
+	testTable := []struct {
+		name  string
+		users map[string]*models.UserModel
+		// expected - проблемы до исправления, friends - списки друзей после него
+		expected []models.ConsistencyIssue
+		friends  map[string][]string
+	}{
+		{
+			"consistent",
+			map[string]*models.UserModel{
+				"1": {ID: "1", FriendIDs: []string{"2"}},
+				"2": {ID: "2", FriendIDs: []string{"1"}},
+			},
+			[]models.ConsistencyIssue{},
+			map[string][]string{"1": {"2"}, "2": {"1"}},
+		},
+		{
+			"dangling friend",
+			map[string]*models.UserModel{
+				"1": {ID: "1", FriendIDs: []string{"2", "9"}, Friendships: map[string]*models.Friendship{"9": {Initiator: "1"}}},
+				"2": {ID: "2", FriendIDs: []string{"1"}},
+			},
+			[]models.ConsistencyIssue{{Type: models.IssueDangling, UserID: "1", FriendID: "9"}},
+			map[string][]string{"1": {"2"}, "2": {"1"}},
+		},
+		{
+			"one-way friendship",
+			map[string]*models.UserModel{
+				"1": {ID: "1", FriendIDs: []string{"2", "3"}},
+				"2": {ID: "2", FriendIDs: []string{"1"}},
+				"3": {ID: "3"},
+			},
+			[]models.ConsistencyIssue{{Type: models.IssueAsymmetric, UserID: "3", FriendID: "1"}},
+			map[string][]string{"1": {"2", "3"}, "2": {"1"}, "3": {"1"}},
+		},
+		{
+			// дружба заблокированных удаляется с обеих сторон и не восстанавливается
+			"blocked friend",
+			map[string]*models.UserModel{
+				"1": {ID: "1", FriendIDs: []string{"2", "3"}, Friendships: map[string]*models.Friendship{"2": {Initiator: "1"}}},
+				"2": {ID: "2", FriendIDs: []string{"1"}, Blocked: []string{"1"}},
+				"3": {ID: "3", Blocked: []string{"1"}},
+			},
+			[]models.ConsistencyIssue{
+				{Type: models.IssueBlocked, UserID: "1", FriendID: "2"},
+				{Type: models.IssueBlocked, UserID: "1", FriendID: "3"},
+				{Type: models.IssueBlocked, UserID: "2", FriendID: "1"},
+			},
+			map[string][]string{"1": {}, "2": {}, "3": {}},
+		},
+		{
+			"self and repeated friend",
+			map[string]*models.UserModel{
+				"1": {ID: "1", FriendIDs: []string{"1", "2", "2", "2"}},
+				"2": {ID: "2", FriendIDs: []string{"1"}},
+			},
+			[]models.ConsistencyIssue{
+				{Type: models.IssueSelf, UserID: "1", FriendID: "1"},
+				{Type: models.IssueDuplicate, UserID: "1", FriendID: "2", Count: 3},
+			},
+			map[string][]string{"1": {"2"}, "2": {"1"}},
+		},
+		{
+			// одинаковые id не чинятся, их друзья проверяются вместе
+			"duplicate ids",
+			map[string]*models.UserModel{
+				"1":    {ID: "1", FriendIDs: []string{"2"}},
+				"1-v2": {ID: "1", FriendIDs: []string{"3"}},
+				"2":    {ID: "2", FriendIDs: []string{"1"}},
+				"3":    {ID: "3"},
+			},
+			[]models.ConsistencyIssue{
+				{Type: models.IssueDuplicateUser, UserID: "1", Count: 2},
+				{Type: models.IssueAsymmetric, UserID: "3", FriendID: "1"},
+			},
+			map[string][]string{"1": {"2"}, "1-v2": {"3"}, "2": {"1"}, "3": {"1"}},
+		},
+	}
+
+	for _, test := range testTable {
+		r := NewRepository(ctx, test.users, &logging.Logger{Logger: zerolog.Nop()})
+		report, err := r.Check(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Users != len(test.users) || report.Fixed != 0 || !reflect.DeepEqual(report.Issues, test.expected) {
+			t.Errorf("%s: got %+v want issues %+v", test.name, report, test.expected)
+		}
+
+		report, err = r.Check(ctx, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixed := 0
+		remaining := make([]models.ConsistencyIssue, 0)
+		for i, issue := range report.Issues {
+			expected := issue.Type != models.IssueDuplicateUser
+			if issue.Fixed != expected {
+				t.Errorf("%s: issue %d fixed %v want %v", test.name, i, issue.Fixed, expected)
+			}
+			if issue.Fixed {
+				fixed++
+			} else {
+				remaining = append(remaining, issue)
+			}
+		}
+		if report.Fixed != fixed || len(report.Issues) != len(test.expected) {
+			t.Errorf("%s: wrong report after fix: got %+v", test.name, report)
+		}
+		for key, expected := range test.friends {
+			if got := test.users[key].FriendIDs; !reflect.DeepEqual(got, expected) && !(len(got) == 0 && len(expected) == 0) {
+				t.Errorf("%s: friends of %s: got %v want %v", test.name, key, got, expected)
+			}
+		}
+		for _, friend := range []string{"2", "9"} {
+			if meta := test.users["1"].Friendships[friend]; meta != nil && !contains(test.users["1"].FriendIDs, friend) {
+				t.Errorf("%s: metadata of removed friend %s is kept: %+v", test.name, friend, meta)
+			}
+		}
+
+		// повторный запуск находит только то, что не чинится
+		report, err = r.Check(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(report.Issues, remaining) {
+			t.Errorf("%s: issues after fix: got %+v want %+v", test.name, report.Issues, remaining)
+		}
+	}
+}
+
+func TestRepository_CheckMetadata(t *testing.T) {
+	ctx := context.Background()
+	closeness := 0.5
+	meta := &models.Friendship{CreatedAt: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC), Initiator: "2", Label: "school", Closeness: &closeness}
+	users := map[string]*models.UserModel{
+		"1": {ID: "1"},
+		"2": {ID: "2", FriendIDs: []string{"1"}, Friendships: map[string]*models.Friendship{"1": meta}},
+	}
+	r := NewRepository(ctx, users, &logging.Logger{Logger: zerolog.Nop()})
+	if _, err := r.Check(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// восстановленная сторона получает метаданные второй стороны, но не общий указатель
+	got := users["1"].Friendships["2"]
+	if got == nil || !reflect.DeepEqual(got, meta) {
+		t.Fatalf("restored metadata: got %+v want %+v", got, meta)
+	}
+	if got == meta || got.Closeness == meta.Closeness {
+		t.Errorf("restored metadata shares memory with the other side")
+	}
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// Бенчмарки сравнивают хранение друзей массивом и отдельной коллекцией связей.
+// Нужен запущенный MongoDB: MONGO_TEST_URI=mongodb://localhost:27017 go test -bench . ./internal/user/db
+
+type layoutRepository interface {
+	Create(ctx context.Context, user *models.UserModel) error
+	MakeFriends(ctx context.Context, sourceId, targetId string) (string, error)
+	Delete(ctx context.Context, id string) (string, error)
+	FindFriend(ctx context.Context, id string) ([]*models.UserModel, error)
+}
+
+func benchDatabase(b *testing.B) *mongo.Database {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		b.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		b.Fatal(err)
+	}
+	database := client.Database("bench_layout")
+	b.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return database
+}
+
+func benchLayouts(b *testing.B, database *mongo.Database) map[string]layoutRepository {
+	ctx := context.Background()
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	database.Drop(ctx)
+	edges, err := NewMongoEdgeRepository(ctx, database, "users_edges", "friendships", logger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return map[string]layoutRepository{
+		"array": NewMongoRepository(database, "users_array", logger),
+		"edges": edges,
+	}
+}
+
+// fill создает звезду: пользователь 0 дружит с остальными
+func fill(b *testing.B, repository layoutRepository, users int) {
+	ctx := context.Background()
+	for i := 0; i < users; i++ {
+		id := strconv.Itoa(i)
+		if err := repository.Create(ctx, &models.UserModel{ID: id, Name: "user" + id, Age: "20"}); err != nil {
+			b.Fatal(err)
+		}
+		if i > 0 {
+			if _, err := repository.MakeFriends(ctx, "0", id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkLayout_FindFriend(b *testing.B) {
+	database := benchDatabase(b)
+	for _, size := range []int{100, 1000} {
+		for name, repository := range benchLayouts(b, database) {
+			fill(b, repository, size)
+			b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := repository.FindFriend(context.Background(), "0"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkLayout_MakeFriends(b *testing.B) {
+	database := benchDatabase(b)
+	for name, repository := range benchLayouts(b, database) {
+		fill(b, repository, 1000)
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				id := "new" + strconv.Itoa(i)
+				repository.Create(ctx, &models.UserModel{ID: id, Name: id, Age: "20"})
+				b.StartTimer()
+				if _, err := repository.MakeFriends(ctx, "0", id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLayout_Delete(b *testing.B) {
+	database := benchDatabase(b)
+	for name, repository := range benchLayouts(b, database) {
+		fill(b, repository, 1000)
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				id := "del" + strconv.Itoa(i)
+				repository.Create(ctx, &models.UserModel{ID: id, Name: id, Age: "20"})
+				repository.MakeFriends(ctx, "0", id)
+				b.StartTimer()
+				if _, err := repository.Delete(ctx, id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/migrations"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// lockID - документ блокировки в коллекции состояния миграций
+const lockID = "lock"
+
+type migrationDoc struct {
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// migrationState - состояние миграций в MongoDB: документ на каждую
+// примененную версию и документ блокировки с владельцем и сроком
+type migrationState struct {
+	collection *mongo.Collection
+}
+
+func NewMigrationState(database *mongo.Database, collection string) *migrationState {
+	return &migrationState{collection: database.Collection(collection)}
+}
+
+// Lock обновляет блокировку, если она своя или истекла, иначе upsert
+// упирается в уникальный _id и блокировка считается занятой
+func (s *migrationState) Lock(ctx context.Context, owner string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": lockID, "$or": bson.A{
+		bson.M{"owner": owner},
+		bson.M{"expires_at": bson.M{"$lte": now}},
+	}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}
+	_, err := s.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return migrations.ErrLocked
+	}
+	if err != nil {
+		return fmt.Errorf("can't update migrations lock: %w", err)
+	}
+	return nil
+}
+
+func (s *migrationState) Unlock(ctx context.Context, owner string) error {
+	if _, err := s.collection.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner}); err != nil {
+		return fmt.Errorf("can't delete migrations lock: %w", err)
+	}
+	return nil
+}
+
+func (s *migrationState) Applied(ctx context.Context) ([]migrations.Record, error) {
+	opts := options.Find().SetSort(bson.M{"version": 1})
+	cursor, err := s.collection.Find(ctx, bson.M{"version": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't find migrations: %w", err)
+	}
+	var docs []migrationDoc
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("can't decode migrations: %w", err)
+	}
+	records := make([]migrations.Record, 0, len(docs))
+	for _, doc := range docs {
+		records = append(records, migrations.Record{Version: doc.Version, Description: doc.Description, AppliedAt: doc.AppliedAt})
+	}
+	return records, nil
+}
+
+func (s *migrationState) Save(ctx context.Context, r migrations.Record) error {
+	doc := migrationDoc{Version: r.Version, Description: r.Description, AppliedAt: r.AppliedAt}
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"version": r.Version}, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("can't save migration: %w", err)
+	}
+	return nil
+}
+
+func (s *migrationState) Remove(ctx context.Context, version int) error {
+	if _, err := s.collection.DeleteOne(ctx, bson.M{"version": version}); err != nil {
+		return fmt.Errorf("can't delete migration: %w", err)
+	}
+	return nil
+}
+
+// Migrations - изменения данных коллекции пользователей по версиям.
+// Новые шаги добавляются в конец со следующей версией
+func (d *db) Migrations() []migrations.Migration {
+	return []migrations.Migration{
+		{
+			Version:     1,
+			Description: "fill search keys of users created before search",
+			Up:          d.fillSearchKeys,
+			Down:        keepSearchKeys,
+		},
+	}
+}
+
+// keepSearchKeys - откат миграции 1 ничего не меняет: ключи поиска пишет и Create,
+// без них поиск перестал бы находить всех пользователей
+func keepSearchKeys(ctx context.Context) error {
+	return nil
+}
+
+// fillSearchKeys заполняет ключи поиска у пользователей без них
+func (d *db) fillSearchKeys(ctx context.Context) error {
+	filter := bson.M{"search": bson.M{"$exists": false}}
+	cursor, err := d.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "name": 1}))
+	if err != nil {
+		return fmt.Errorf("can't scan users: %w", err)
+	}
+	var docs []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Name string             `bson:"name"`
+	}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return fmt.Errorf("can't decode users: %w", err)
+	}
+	for _, doc := range docs {
+		_, err = d.collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"search": newSearchKeys(doc.Name)}})
+		if err != nil {
+			return fmt.Errorf("can't set search keys: %w", err)
+		}
+	}
+	if len(docs) > 0 {
+		d.logger.Info().Int("users", len(docs)).Msg("search keys filled")
+	}
+	return nil
+}
+
+// Migrations - те же версии для хранилища в памяти: ключи поиска здесь - индекс имен
+func (r *repository) Migrations() []migrations.Migration {
+	return []migrations.Migration{
+		{
+			Version:     1,
+			Description: "fill search keys of users created before search",
+			Up: func(ctx context.Context) error {
+				for id, u := range r.storage {
+					r.index.Add(id, u.Name)
+				}
+				return nil
+			},
+			Down: keepSearchKeys,
+		},
+	}
+}
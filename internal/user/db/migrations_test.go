@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/migrations"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// searchCount - сколько пользователей находит поиск по префиксу
+func searchCount(t *testing.T, s interface {
+	Search(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error)
+}, text string) int {
+	page, err := s.Search(context.Background(), models.SearchQuery{Text: text, Mode: models.SearchPrefix, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(page.Results)
+}
+
+func TestRepository_Migrations(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	m, err := migrations.NewMigrator(migrations.NewMemoryState(), r.Migrations(), migrations.DefaultLockTTL, r.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if done, err := m.Up(ctx, 0); err != nil || !reflect.DeepEqual(done, []int{1}) {
+		t.Errorf("up: got %v, %v want [1], nil", done, err)
+	}
+	if n := searchCount(t, r, "helen"); n != 1 {
+		t.Errorf("after up: got %d results want 1", n)
+	}
+	// откат не ломает поиск
+	if done, err := m.Down(ctx, 0); err != nil || !reflect.DeepEqual(done, []int{1}) {
+		t.Errorf("down: got %v, %v want [1], nil", done, err)
+	}
+	if n := searchCount(t, r, "helen"); n != 1 {
+		t.Errorf("after down: got %d results want 1", n)
+	}
+}
+
+func TestMigrationState(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	state := NewMigrationState(database, "migrations")
+
+	steps := []struct {
+		name     string
+		owner    string
+		ttl      time.Duration
+		expected error
+	}{
+		{"take", "a", time.Minute, nil},
+		{"extend", "a", time.Minute, nil},
+		{"taken by other", "b", time.Minute, migrations.ErrLocked},
+	}
+	for _, step := range steps {
+		if err := state.Lock(ctx, step.owner, step.ttl); !errors.Is(err, step.expected) {
+			t.Errorf("%s: got error %v want %v", step.name, err, step.expected)
+		}
+	}
+	if err := state.Unlock(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Lock(ctx, "b", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// истекшую блокировку забирает другой экземпляр
+	if err := state.Lock(ctx, "a", time.Minute); err != nil {
+		t.Errorf("expired lock is not taken: %v", err)
+	}
+
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	state.Save(ctx, migrations.Record{Version: 2, Description: "two", AppliedAt: at})
+	state.Save(ctx, migrations.Record{Version: 1, Description: "one", AppliedAt: at})
+	records, err := state.Applied(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []migrations.Record{
+		{Version: 1, Description: "one", AppliedAt: at},
+		{Version: 2, Description: "two", AppliedAt: at},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("wrong records: got %+v want %+v", records, expected)
+	}
+	state.Remove(ctx, 2)
+	if records, _ = state.Applied(ctx); len(records) != 1 {
+		t.Errorf("wrong number of records after remove: got %d want 1", len(records))
+	}
+}
+
+func TestMongoMigrations(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	d := NewMongoRepository(database, "users", &logging.Logger{Logger: zerolog.Nop()})
+	// пользователь, созданный до поиска, и новый с ключами
+	if _, err := d.collection.InsertOne(ctx, bson.M{"id": "1", "name": "Helen", "age": "18"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(ctx, &models.UserModel{ID: "2", Name: "Helena", Age: "20"}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrations.NewMigrator(NewMigrationState(database, "migrations"), d.Migrations(), time.Minute, d.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := searchCount(t, d, "helen"); n != 1 {
+		t.Errorf("before up: got %d results want 1", n)
+	}
+
+	if _, err = m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := searchCount(t, d, "helen"); n != 2 {
+		t.Errorf("after up: got %d results want 2", n)
+	}
+	if _, err = m.Down(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := searchCount(t, d, "helen"); n != 2 {
+		t.Errorf("after down: got %d results want 2", n)
+	}
+}
@@ -0,0 +1,437 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+)
+
+// edgeDB хранит дружбу отдельными документами {user_a, user_b} с user_a < user_b,
+// пользователи лежат в той же коллекции, что и у db, но без массива friends
+type edgeDB struct {
+	*db
+	edges *mongo.Collection
+}
+
+type edgeDoc struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty"`
+	UserA             string             `bson:"user_a"`
+	UserB             string             `bson:"user_b"`
+	models.Friendship `bson:",inline"`
+}
+
+func newEdge(a, b string) edgeDoc {
+	if a > b {
+		a, b = b, a
+	}
+	return edgeDoc{UserA: a, UserB: b}
+}
+
+// key - фильтр по паре пользователей без учета метаданных
+func (e edgeDoc) key() bson.M {
+	return bson.M{"user_a": e.UserA, "user_b": e.UserB}
+}
+
+func (e edgeDoc) other(id string) string {
+	if e.UserA == id {
+		return e.UserB
+	}
+	return e.UserA
+}
+
+func NewMongoEdgeRepository(ctx context.Context, database *mongo.Database, collection, edges string, logger *logging.Logger) (*edgeDB, error) {
+	d := &edgeDB{
+		db:    NewMongoRepository(database, collection, logger),
+		edges: database.Collection(edges),
+	}
+	if err := d.ensureEdgeIndexes(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *edgeDB) ensureEdgeIndexes(ctx context.Context) error {
+	_, err := d.edges.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_a", Value: 1}, {Key: "user_b", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_b", Value: 1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("can't create edge indexes: %w", err)
+	}
+	return nil
+}
+
+// EnsureSchema добавляет к отчету индексы коллекции связей, они создаются в конструкторе
+func (d *edgeDB) EnsureSchema(ctx context.Context, action string) (*models.SchemaReport, error) {
+	report, err := d.db.EnsureSchema(ctx, action)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := listIndexes(ctx, d.edges)
+	if err != nil {
+		return nil, err
+	}
+	report.Indexes = append(report.Indexes, indexes...)
+	return report, nil
+}
+
+func (d *edgeDB) Create(ctx context.Context, user *models.UserModel) error {
+	// список друзей в документе пользователя в этой схеме не хранится
+	u := *user
+	u.FriendIDs = nil
+	return d.db.Create(ctx, &u)
+}
+
+func (d *edgeDB) MakeFriends(ctx context.Context, sourceId, targetId string) (string, error) {
+	err := d.checkUsers(ctx, sourceId, targetId)
+	if err != nil {
+		return "", err
+	}
+	if err = d.checkBlocked(ctx, sourceId, targetId); err != nil {
+		return "", err
+	}
+
+	// уникальный индекс не дает создать дружбу дважды
+	e := newEdge(sourceId, targetId)
+	e.Friendship = *models.NewFriendship(sourceId)
+	err = d.withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := d.edges.InsertOne(ctx, e); err != nil {
+			return err
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventFriendshipCreated, UserIDs: []string{sourceId, targetId}})
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		err = models.NewError(models.ErrAlreadyFriends, "Пользователи "+sourceId+" "+targetId+" уже друзья\n")
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't create friendship: %w", err)
+	}
+
+	d.logger.Debug().Msgf("method MakeFriends finished with ids %s, %s", sourceId, targetId)
+	return fmt.Sprint("пользователи ", sourceId, " и ", targetId, " теперь друзья"), nil
+}
+
+func (d *edgeDB) Unfriend(ctx context.Context, id, friendID string) (string, error) {
+	if err := d.checkUsers(ctx, id, friendID); err != nil {
+		return "", err
+	}
+
+	notFriends := models.NewError(models.ErrNotFriends, "Пользователи "+id+" "+friendID+" не друзья\n")
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		res, err := d.edges.DeleteOne(ctx, newEdge(id, friendID).key())
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return notFriends
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{id, friendID}})
+	})
+	if errors.Is(err, notFriends) {
+		return "", notFriends
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't delete friendship: %w", err)
+	}
+	d.logger.Debug().Msgf("method Unfriend finished with ids %s, %s", id, friendID)
+	return fmt.Sprint("пользователи ", id, " и ", friendID, " больше не друзья"), nil
+}
+
+func edgesOf(id string) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"user_a": id}, bson.M{"user_b": id}}}
+}
+
+// friendIDs возвращает id друзей для каждого из пользователей в порядке создания дружбы
+func (d *edgeDB) friendIDs(ctx context.Context, ids ...string) (map[string][]string, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"user_a": bson.M{"$in": ids}}, bson.M{"user_b": bson.M{"$in": ids}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := d.edges.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't find friendships: %w", err)
+	}
+	var edges []edgeDoc
+	if err = cursor.All(ctx, &edges); err != nil {
+		return nil, fmt.Errorf("can't decode friendships: %w", err)
+	}
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	others := make([]string, 0, len(edges))
+	for _, e := range edges {
+		others = append(others, e.UserA, e.UserB)
+	}
+	// связи с удаленными пользователями остаются до окончательного удаления
+	deleted, err := d.deletedIDs(ctx, others)
+	if err != nil {
+		return nil, err
+	}
+	friends := make(map[string][]string, len(ids))
+	for _, e := range edges {
+		if wanted[e.UserA] && !deleted[e.UserB] {
+			friends[e.UserA] = append(friends[e.UserA], e.UserB)
+		}
+		if wanted[e.UserB] && !deleted[e.UserA] {
+			friends[e.UserB] = append(friends[e.UserB], e.UserA)
+		}
+	}
+	return friends, nil
+}
+
+func (d *edgeDB) FindUser(ctx context.Context, id string) (*models.UserModel, error) {
+	u, err := d.db.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	friends, err := d.friendIDs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	u.FriendIDs = friends[id]
+	return u, nil
+}
+
+func (d *edgeDB) FindUsers(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	if len(ids) == 0 {
+		return []*models.UserModel{}, nil
+	}
+	users, err := d.findByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("can't find users: %w", err)
+	}
+	friendsOf, err := d.friendIDs(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		u.FriendIDs = friendsOf[u.ID]
+	}
+	d.logger.Debug().Msgf("method FindUsers finished with %d ids", len(ids))
+	return users, nil
+}
+
+func (d *edgeDB) FindFriend(ctx context.Context, id string) ([]*models.UserModel, error) {
+	u, err := d.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.FriendIDs) == 0 {
+		return []*models.UserModel{}, nil
+	}
+	friends, err := d.findByIDs(ctx, u.FriendIDs)
+	if err != nil {
+		return nil, err
+	}
+	// списки друзей найденных друзей одним запросом
+	friendsOf, err := d.friendIDs(ctx, u.FriendIDs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range friends {
+		f.FriendIDs = friendsOf[f.ID]
+	}
+	d.logger.Debug().Msg("method FindFriend finished")
+	return friends, nil
+}
+
+func (d *edgeDB) UpdateFriendship(ctx context.Context, id, friendID string, update models.FriendshipUpdate) (*models.Friendship, error) {
+	set := bson.M{}
+	if update.Label != nil {
+		set["label"] = *update.Label
+	}
+	if update.Closeness != nil {
+		set["closeness"] = *update.Closeness
+	}
+	if len(set) == 0 {
+		return nil, errors.New("nothing to update")
+	}
+
+	fields := bson.M{}
+	for k, v := range set {
+		fields[k] = bson.M{"$literal": v}
+	}
+	// связь, перенесенная без метаданных, получает их при первом изменении
+	created := models.NewFriendship(id)
+	empty := bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$initiator", ""}}, ""}}
+	fields["created_at"] = bson.M{"$cond": bson.A{empty, created.CreatedAt, "$created_at"}}
+	fields["initiator"] = bson.M{"$cond": bson.A{empty, created.Initiator, "$initiator"}}
+
+	e := edgeDoc{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := d.edges.FindOneAndUpdate(ctx, newEdge(id, friendID).key(), bson.A{bson.M{"$set": fields}}, opts).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		err = models.NewError(models.ErrNotFriends, "Пользователи "+id+" "+friendID+" не друзья\n")
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't update friendship: %w", err)
+	}
+	d.logger.Debug().Msgf("friendship %s-%s updated", id, friendID)
+	return &e.Friendship, nil
+}
+
+func (d *edgeDB) FindAll(ctx context.Context) ([]*models.UserModel, error) {
+	users, err := d.db.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := d.edges.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("can't find friendships: %w", err)
+	}
+	var edges []edgeDoc
+	if err = cursor.All(ctx, &edges); err != nil {
+		return nil, fmt.Errorf("can't decode friendships: %w", err)
+	}
+	alive := make(map[string]bool, len(users))
+	for _, u := range users {
+		alive[u.ID] = true
+	}
+	friends := make(map[string][]string, len(users))
+	for _, e := range edges {
+		if !alive[e.UserA] || !alive[e.UserB] {
+			continue
+		}
+		friends[e.UserA] = append(friends[e.UserA], e.UserB)
+		friends[e.UserB] = append(friends[e.UserB], e.UserA)
+	}
+	for _, u := range users {
+		u.FriendIDs = friends[u.ID]
+	}
+	return users, nil
+}
+
+// Check для схемы со связями: несимметричная дружба и дубли невозможны,
+// проверяются связи с удаленными и заблокированными пользователями и с самим собой
+func (d *edgeDB) Check(ctx context.Context, fix bool) (*models.ConsistencyReport, error) {
+	// удаленные, но еще не стертые пользователи считаются существующими
+	cursor, err := d.collection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.M{"id": 1, "blocked": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("can't scan users: %w", err)
+	}
+	var users []models.UserModel
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("can't decode users: %w", err)
+	}
+	exists := make(map[string]bool, len(users))
+	blocked := make(map[string]bool)
+	for _, u := range users {
+		exists[u.ID] = true
+		for _, target := range u.Blocked {
+			blocked[u.ID+" "+target] = true
+		}
+	}
+	cursor, err = d.edges.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("can't scan friendships: %w", err)
+	}
+	var edges []edgeDoc
+	if err = cursor.All(ctx, &edges); err != nil {
+		return nil, fmt.Errorf("can't decode friendships: %w", err)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].UserA < edges[j].UserA || edges[i].UserA == edges[j].UserA && edges[i].UserB < edges[j].UserB
+	})
+
+	report := &models.ConsistencyReport{Users: len(users), Issues: make([]models.ConsistencyIssue, 0)}
+	for _, e := range edges {
+		var issue models.ConsistencyIssue
+		switch {
+		case e.UserA == e.UserB:
+			issue = models.ConsistencyIssue{Type: models.IssueSelf, UserID: e.UserA, FriendID: e.UserB}
+		case !exists[e.UserA]:
+			issue = models.ConsistencyIssue{Type: models.IssueDangling, UserID: e.UserB, FriendID: e.UserA}
+		case !exists[e.UserB]:
+			issue = models.ConsistencyIssue{Type: models.IssueDangling, UserID: e.UserA, FriendID: e.UserB}
+		case blocked[e.UserA+" "+e.UserB] || blocked[e.UserB+" "+e.UserA]:
+			issue = models.ConsistencyIssue{Type: models.IssueBlocked, UserID: e.UserA, FriendID: e.UserB}
+		default:
+			continue
+		}
+		if fix {
+			if _, err = d.edges.DeleteOne(ctx, bson.M{"_id": e.ID}); err != nil {
+				return nil, fmt.Errorf("can't delete friendship %s-%s: %w", e.UserA, e.UserB, err)
+			}
+			issue.Fixed = true
+			report.Fixed++
+			d.logger.Info().Str("user_a", e.UserA).Str("user_b", e.UserB).Msg("friendship removed")
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	d.logger.Debug().Msgf("method Check finished with %d issues", len(report.Issues))
+	return report, nil
+}
+
+// MigrateFromArrays переносит массивы friends из документов пользователей в коллекцию связей.
+// Миграцию можно запускать повторно, существующие связи не дублируются
+func (d *edgeDB) MigrateFromArrays(ctx context.Context, dropArrays bool) (int, error) {
+	filter := bson.M{"friends": bson.M{"$exists": true}}
+	cursor, err := d.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1, "friends": 1, "friendships": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("can't scan users: %w", err)
+	}
+	var users []models.UserModel
+	if err = cursor.All(ctx, &users); err != nil {
+		return 0, fmt.Errorf("can't decode users: %w", err)
+	}
+	// друг может быть без массива friends, поэтому существование проверяется по всем
+	// пользователям. Удаленные, но еще не стертые считаются существующими, как в Check
+	cursor, err = d.collection.Find(ctx, bson.D{}, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("can't scan users: %w", err)
+	}
+	var all []models.UserModel
+	if err = cursor.All(ctx, &all); err != nil {
+		return 0, fmt.Errorf("can't decode users: %w", err)
+	}
+	exists := make(map[string]bool, len(all))
+	for _, u := range all {
+		exists[u.ID] = true
+	}
+
+	created := 0
+	for _, u := range users {
+		for _, friendID := range u.FriendIDs {
+			// ссылки на себя и на несуществующих пользователей не переносим
+			if friendID == u.ID || !exists[friendID] {
+				d.logger.Warn().Str("user", u.ID).Str("friend", friendID).Msg("friendship skipped")
+				continue
+			}
+			// дружба без метаданных получает их при переносе, как при первом изменении
+			e := newEdge(u.ID, friendID)
+			e.Friendship = *models.NewFriendship(u.ID)
+			if meta, ok := u.Friendships[friendID]; ok {
+				e.Friendship = *meta
+			}
+			res, err := d.edges.UpdateOne(ctx, e.key(), bson.M{"$setOnInsert": e}, options.Update().SetUpsert(true))
+			if err != nil {
+				return created, fmt.Errorf("can't insert friendship %s-%s: %w", e.UserA, e.UserB, err)
+			}
+			if res.UpsertedCount > 0 {
+				created++
+			}
+		}
+	}
+
+	if dropArrays {
+		_, err = d.collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"friends": "", "friendships": ""}})
+		if err != nil {
+			return created, fmt.Errorf("can't drop friends arrays: %w", err)
+		}
+	}
+	d.logger.Info().Int("users", len(users)).Int("friendships", created).Msg("friends migrated to edges collection")
+	return created, nil
+}
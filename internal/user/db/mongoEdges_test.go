@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"sort"
+	"testing"
+)
+
+func TestMigrateFromArrays(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	d, err := NewMongoEdgeRepository(ctx, database, "users", "friendships", &logging.Logger{Logger: zerolog.Nop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// у 2 и 3 нет массива friends, 4 не существует
+	_, err = d.collection.InsertMany(ctx, []interface{}{
+		bson.M{"id": "1", "name": "John", "age": "24", "friends": bson.A{"2", "3", "4", "1"}},
+		bson.M{"id": "2", "name": "Nate", "age": "25"},
+		bson.M{"id": "3", "name": "Helen", "age": "18"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := d.MigrateFromArrays(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 2 {
+		t.Errorf("wrong number of created friendships: got %d want 2", created)
+	}
+	friends, err := d.friendIDs(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := friends["1"]
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("wrong friends after migration: got %v want [2 3]", got)
+	}
+	n, err := d.collection.CountDocuments(ctx, bson.M{"friends": bson.M{"$exists": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("friends arrays are not dropped: %d left", n)
+	}
+
+	// повторный запуск не создает дублей
+	if created, err = d.MigrateFromArrays(ctx, false); err != nil || created != 0 {
+		t.Errorf("repeated migration: got %d, %v want 0, nil", created, err)
+	}
+}
@@ -10,12 +10,16 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"strconv"
+	"sync"
 )
 
 type db struct {
-	collection *mongo.Collection
-	id         int
-	logger     *logging.Logger
+	collection  *mongo.Collection
+	outbox      *mongo.Collection
+	id          int
+	logger      *logging.Logger
+	txOnce      sync.Once
+	txSupported bool
 }
 
 func NewMongoRepository(database *mongo.Database, collection string, logger *logging.Logger) *db {
@@ -26,44 +30,32 @@ func NewMongoRepository(database *mongo.Database, collection string, logger *log
 }
 
 func (d *db) Create(ctx context.Context, user *models.UserModel) error {
-	_, err := d.collection.InsertOne(ctx, user)
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		doc := userDoc{UserModel: *user, Search: newSearchKeys(user.Name)}
+		if _, err := d.collection.InsertOne(ctx, doc); err != nil {
+			return err
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventUserCreated, UserIDs: []string{user.ID}, User: user.Copy()})
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return models.NewError(models.ErrUserExists, "пользователь с id "+user.ID+" уже существует")
+	}
 	if err != nil {
-		return errors.New("error to insert user")
+		return fmt.Errorf("can't insert user: %w", err)
 	}
 	d.logger.Debug().Msg("User created with id " + user.ID)
 	return nil
 }
 
 func (d *db) MakeFriends(ctx context.Context, sourceId, targetId string) (string, error) {
-	var err error
 	var result bson.M
-	ok := [2]bool{true, true}
-	ids := [2]string{sourceId, targetId}
-	// проверка на существование пользователей
-	for i, id := range ids {
-		err = d.collection.FindOne(ctx, bson.D{{"id", id}}).Decode(&result)
-		if err != nil {
-			ok[i] = false
-		}
-	}
-
-	switch {
-	case !ok[0] && !ok[1]:
-		{
-			err = errors.New("Пользователи " + sourceId + " " + targetId + " не найдены\n")
-		}
-	case !ok[0]:
-		{
-			err = errors.New("Пользователь " + sourceId + " не найден\n")
-		}
-	case !ok[1]:
-		{
-			err = errors.New("Пользователь " + targetId + " не найден\n")
-		}
-	}
+	err := d.checkUsers(ctx, sourceId, targetId)
 	if err != nil {
 		return "", err
 	}
+	if err = d.checkBlocked(ctx, sourceId, targetId); err != nil {
+		return "", err
+	}
 
 	//проверка на друзей
 	checkFilter := bson.D{
@@ -75,98 +67,280 @@ func (d *db) MakeFriends(ctx context.Context, sourceId, targetId string) (string
 	}
 	err = d.collection.FindOne(ctx, checkFilter).Decode(&result)
 	if err == nil {
-		err = errors.New("Пользователи " + sourceId + " " + targetId + " уже друзья\n")
+		err = models.NewError(models.ErrAlreadyFriends, "Пользователи "+sourceId+" "+targetId+" уже друзья\n")
 		return "", err
 	}
 
-	// обновление друзей в базе
-	for i := 0; i <= 1; i++ {
-		if i == 1 {
-			sourceId, targetId = targetId, sourceId
+	// обновление друзей в базе, метаданные дружбы хранятся у обоих
+	meta := models.NewFriendship(sourceId)
+	err = d.withTransaction(ctx, func(ctx context.Context) error {
+		for _, pair := range [][2]string{{sourceId, targetId}, {targetId, sourceId}} {
+			updateFilter := bson.D{{"id", pair[0]}}
+			updateOptions := bson.D{
+				{"$push", bson.D{{"friends", pair[1]}}},
+				{"$set", bson.D{{"friendships." + pair[1], meta}}},
+			}
+			if _, err := d.collection.UpdateOne(ctx, updateFilter, updateOptions); err != nil {
+				return err
+			}
 		}
-		updateFilter := bson.D{{"id", sourceId}}
-		updateOptions := bson.D{{"$push", bson.D{{"friends", targetId}}}}
-		_, err = d.collection.UpdateOne(ctx, updateFilter, updateOptions)
+		return d.emit(ctx, &models.Event{Type: models.EventFriendshipCreated, UserIDs: []string{sourceId, targetId}})
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't create friendship: %w", err)
 	}
-	//перевернем обратно
-	sourceId, targetId = targetId, sourceId
 	d.logger.Debug().Msgf("method MakeFriends finished with ids %s, %s", sourceId, targetId)
 
 	return fmt.Sprint("пользователи ", sourceId, " и ", targetId, " теперь друзья"), nil
 }
 
-func (d *db) Delete(ctx context.Context, id string) (string, error) {
-	//поиск по id
-	filter := bson.M{"id": id}
-	result, err := d.collection.DeleteOne(ctx, filter)
-	if err != nil {
-		err = errors.New("failed to execute with filter")
+func (d *db) Unfriend(ctx context.Context, id, friendID string) (string, error) {
+	if err := d.checkUsers(ctx, id, friendID); err != nil {
 		return "", err
 	}
 
-	//проверка на то, что пользователь удален
-	if result.DeletedCount == 0 {
-		err := errors.New("Пользователь " + id + " не найден")
-		return "", err
+	notFriends := models.NewError(models.ErrNotFriends, "Пользователи "+id+" "+friendID+" не друзья\n")
+	// дружба разрывается с обеих сторон одной транзакцией
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		for _, pair := range [][2]string{{id, friendID}, {friendID, id}} {
+			updateFilter := bson.M{"id": pair[0], "friends": pair[1]}
+			updateOptions := bson.M{
+				"$pull":  bson.M{"friends": pair[1]},
+				"$unset": bson.M{"friendships." + pair[1]: ""},
+			}
+			res, err := d.collection.UpdateOne(ctx, updateFilter, updateOptions)
+			if err != nil {
+				return err
+			}
+			if res.MatchedCount == 0 {
+				return notFriends
+			}
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{id, friendID}})
+	})
+	if errors.Is(err, notFriends) {
+		return "", notFriends
 	}
+	if err != nil {
+		return "", fmt.Errorf("can't delete friendship: %w", err)
+	}
+	d.logger.Debug().Msgf("method Unfriend finished with ids %s, %s", id, friendID)
+	return fmt.Sprint("пользователи ", id, " и ", friendID, " больше не друзья"), nil
+}
 
-	// удаление удаленного пользователя из друзей
-	updateFilter := bson.D{{"friends", id}}
-	updateOptions := bson.D{{"$pull", bson.D{{"friends", id}}}}
-	_, err = d.collection.UpdateMany(ctx, updateFilter, updateOptions)
+// checkUsers проверяет, что оба пользователя существуют и это разные пользователи
+func (d *db) checkUsers(ctx context.Context, sourceId, targetId string) error {
+	var err error
+	var result bson.M
+	if sourceId == targetId {
+		return models.NewError(models.ErrSelfFriendship, "Пользователь "+sourceId+" не может дружить сам с собой\n")
+	}
+	ok := [2]bool{true, true}
+	ids := [2]string{sourceId, targetId}
+	// проверка на существование пользователей
+	for i, id := range ids {
+		err = d.collection.FindOne(ctx, live(bson.M{"id": id})).Decode(&result)
+		if err == mongo.ErrNoDocuments {
+			ok[i] = false
+		} else if err != nil {
+			return fmt.Errorf("can't find user: %w", err)
+		}
+	}
+
+	switch {
+	case !ok[0] && !ok[1]:
+		{
+			err = models.NewError(models.ErrNotFound, "Пользователи "+sourceId+" "+targetId+" не найдены\n")
+		}
+	case !ok[0]:
+		{
+			err = models.NewError(models.ErrNotFound, "Пользователь "+sourceId+" не найден\n")
+		}
+	case !ok[1]:
+		{
+			err = models.NewError(models.ErrNotFound, "Пользователь "+targetId+" не найден\n")
+		}
+	default:
+		err = nil
+	}
+	return err
+}
 
-	d.logger.Debug().Msgf("Удален пользователь с id %s", id)
-	return fmt.Sprint("пользователь ", id, " удален"), nil
+func (d *db) FindUser(ctx context.Context, id string) (*models.UserModel, error) {
+	u := models.UserModel{}
+	filter := live(bson.M{"id": id})
+	err := d.collection.FindOne(ctx, filter).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		err = models.NewError(models.ErrNotFound, "пользователь с "+id+" не найден")
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't find user: %w", err)
+	}
+	if err = d.hideDeleted(ctx, &u); err != nil {
+		return nil, err
+	}
+	d.logger.Debug().Msg("method FindUser finished")
+	return &u, nil
 }
 
 func (d *db) FindFriend(ctx context.Context, id string) (ufriends []*models.UserModel, err error) {
 	//проверка на существование
-	//поиск по id
-	check := bson.D{}
-	filter := bson.M{"id": id}
-	err = d.collection.FindOne(ctx, filter).Decode(&check)
+	u, err := d.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.FriendIDs) == 0 {
+		return []*models.UserModel{}, nil
+	}
+	ufriends, err = d.findByIDs(ctx, u.FriendIDs)
 	if err != nil {
-		err = errors.New("пользователь с " + id + " не найден")
 		return nil, err
 	}
-	//поиск друзей по id
-	var results []bson.D
-	friendsFilter := bson.D{{"friends", id}}
-	cursor, err := d.collection.Find(ctx, friendsFilter)
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err = d.hideDeleted(ctx, ufriends...); err != nil {
+		return nil, err
+	}
+	d.logger.Debug().Msg("method FindFriend finished")
+	return ufriends, nil
+}
+
+// FindUsers возвращает пользователей одним запросом в порядке переданных id, отсутствующие пропускаются
+func (d *db) FindUsers(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	if len(ids) == 0 {
+		return []*models.UserModel{}, nil
+	}
+	users, err := d.findByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("can't find users: %w", err)
+	}
+	if err = d.hideDeleted(ctx, users...); err != nil {
+		return nil, err
+	}
+	d.logger.Debug().Msgf("method FindUsers finished with %d ids", len(ids))
+	return users, nil
+}
+
+// findByIDs возвращает пользователей в порядке переданных id, отсутствующие пропускаются
+func (d *db) findByIDs(ctx context.Context, ids []string, opts ...*options.FindOptions) ([]*models.UserModel, error) {
+	filter := live(bson.M{"id": bson.M{"$in": ids}})
+	cursor, err := d.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		d.logger.Err(err).Msg("find friends error")
+		return nil, err
+	}
+	var results []*models.UserModel
+	if err = cursor.All(ctx, &results); err != nil {
 		d.logger.Err(err).Msg("find results error")
 		return nil, err
 	}
+	byID := make(map[string]*models.UserModel, len(results))
+	for _, u := range results {
+		byID[u.ID] = u
+	}
 
-	//запись друзей в мапу
-	for _, result := range results {
-		u := models.UserModel{}
-		doc, err := bson.Marshal(result)
-		if err != nil {
-			err = errors.New("can't marshal result")
-			return nil, err
+	users := make([]*models.UserModel, 0, len(results))
+	for _, id := range ids {
+		if u, ok := byID[id]; ok {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (d *db) UpdateFriendship(ctx context.Context, id, friendID string, update models.FriendshipUpdate) (*models.Friendship, error) {
+	set := bson.M{}
+	if update.Label != nil {
+		set["label"] = *update.Label
+	}
+	if update.Closeness != nil {
+		set["closeness"] = *update.Closeness
+	}
+	if len(set) == 0 {
+		return nil, errors.New("nothing to update")
+	}
+
+	// метаданные меняются у обоих друзей. Дружба, созданная без метаданных,
+	// получает их при первом изменении, иначе запись не пройдет валидатор
+	created := models.NewFriendship(id)
+	for _, pair := range [][2]string{{id, friendID}, {friendID, id}} {
+		path := "friendships." + pair[1]
+		fields := bson.M{}
+		for k, v := range set {
+			fields[k] = bson.M{"$literal": v}
 		}
-		err = bson.Unmarshal(doc, &u)
+		merged := bson.A{
+			bson.M{"created_at": created.CreatedAt, "initiator": created.Initiator},
+			bson.M{"$ifNull": bson.A{"$" + path, bson.M{}}},
+			fields,
+		}
+		updateFilter := live(bson.M{"id": pair[0], "friends": pair[1]})
+		updateOptions := bson.A{bson.M{"$set": bson.M{path: bson.M{"$mergeObjects": merged}}}}
+		res, err := d.collection.UpdateOne(ctx, updateFilter, updateOptions)
 		if err != nil {
-			err = errors.New("can't unmarshal result to struct")
+			return nil, fmt.Errorf("can't update friendship: %w", err)
+		}
+		if res.MatchedCount == 0 {
+			err = models.NewError(models.ErrNotFriends, "Пользователи "+id+" "+friendID+" не друзья\n")
 			return nil, err
 		}
-		ufriends = append(ufriends, &u)
 	}
-	d.logger.Debug().Msg("method FindFriend finished")
-	return ufriends, nil
+
+	u, err := d.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	meta := u.Friendships[friendID]
+	if meta == nil {
+		meta = &models.Friendship{}
+	}
+	d.logger.Debug().Msgf("friendship %s-%s updated", id, friendID)
+	return meta, nil
+}
+
+func (d *db) FindAll(ctx context.Context) ([]*models.UserModel, error) {
+	cursor, err := d.collection.Find(ctx, live(bson.M{}))
+	if err != nil {
+		return nil, fmt.Errorf("can't find users: %w", err)
+	}
+	users := make([]*models.UserModel, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("can't decode users: %w", err)
+	}
+	// друзья, которых нет среди живых пользователей, удалены
+	alive := make(map[string]bool, len(users))
+	for _, u := range users {
+		alive[u.ID] = true
+	}
+	for _, u := range users {
+		u.HideFriends(func(id string) bool {
+			return !alive[id]
+		})
+	}
+	d.logger.Debug().Msg("method FindAll finished")
+	return users, nil
 }
 
 func (d *db) UpdateAge(ctx context.Context, id, age string) error {
-	updateFilter := bson.D{{"id", id}}
+	updateFilter := live(bson.M{"id": id})
 	updateOptions := bson.D{{"$set", bson.D{{"age", age}}}}
-	res, err := d.collection.UpdateOne(ctx, updateFilter, updateOptions)
-	if err != nil {
-		err = errors.New(fmt.Sprintf("can't update age %v", err))
+	notFound := models.NewError(models.ErrNotFound, fmt.Sprintf("пользователь с id %s не найден", id))
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		u := &models.UserModel{}
+		err := d.collection.FindOneAndUpdate(ctx, updateFilter, updateOptions,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(u)
+		if err == mongo.ErrNoDocuments {
+			return notFound
+		}
+		if err != nil {
+			return err
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventUserUpdated, UserIDs: []string{id}, User: u})
+	})
+	if err == notFound {
 		return err
 	}
-	if res.MatchedCount == 0 {
-		err = errors.New(fmt.Sprintf("пользователь с id %s не найден", id))
+	if err != nil {
+		err = errors.New(fmt.Sprintf("can't update age %v", err))
 		return err
 	}
 
@@ -174,35 +348,57 @@ func (d *db) UpdateAge(ctx context.Context, id, age string) error {
 	return nil
 }
 
+// idCollation сравнивает строковые id как числа: "10" больше "9"
+var idCollation = &options.Collation{Locale: "en", NumericOrdering: true}
+
+// MakeID выдает следующий id из счетчика в коллекции counters, так что несколько
+// сервисов с одной базой не получат одинаковый id. Если счетчика нет, он создается
+// по наибольшему числовому id, уже записанному в коллекцию
 func (d *db) MakeID() string {
-	// генерация ID из базы если идет запрос больше чем от двух сервисов к 1 базе
-	var ids string
-	var id int
-
-	//сортировка по последнему добавленному id и возвращение последнего элемента из mongo
-	filter := bson.D{}
-	opts := options.Find().SetSort(bson.D{{"id", -1}}).SetLimit(1)
-	cursor, err := d.collection.Find(context.TODO(), filter, opts)
-	if err != nil {
-		d.logger.Err(err).Msg("Can't get ID from mongo DB")
+	ctx := context.TODO()
+	counters := d.collection.Database().Collection("counters")
+	filter := bson.M{"_id": d.collection.Name()}
+	next := func() (int, error) {
+		var counter struct {
+			Seq int `bson:"seq"`
+		}
+		err := counters.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"seq": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&counter)
+		return counter.Seq, err
+	}
+
+	id, err := next()
+	if err == mongo.ErrNoDocuments {
+		// $max не уменьшит счетчик, если его одновременно создал другой сервис
+		var last int
+		last, err = d.maxID(ctx)
+		if err == nil {
+			_, err = counters.UpdateOne(ctx, filter, bson.M{"$max": bson.M{"seq": last}}, options.Update().SetUpsert(true))
+		}
+		if err == nil {
+			id, err = next()
+		}
 	}
-	//конвертирование результата в мапу
-	var results []bson.M
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err != nil {
 		d.logger.Err(err).Msg("Can't get ID from mongo DB")
+		return ""
 	}
+	return strconv.Itoa(id)
+}
 
-	//если база пустая и не ни одного id
-	if results == nil {
-		return "1"
+// maxID - наибольший числовой id среди пользователей, 0 для пустой коллекции
+func (d *db) maxID(ctx context.Context) (int, error) {
+	var result struct {
+		ID string `bson:"id"`
 	}
-
-	// получение id из мапы, получаем string
-	ids = fmt.Sprintf("%v", results[0]["id"])
-
-	// итерация id и перевод обратно в string
-	id, _ = strconv.Atoi(ids)
-	id++
-	ids = strconv.Itoa(id)
-	return ids
+	opts := options.FindOne().SetSort(bson.D{{Key: "id", Value: -1}}).SetCollation(idCollation).SetProjection(bson.M{"id": 1})
+	err := d.collection.FindOne(ctx, bson.M{}, opts).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, _ := strconv.Atoi(result.ID)
+	return id, nil
 }
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMakeID(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	d := NewMongoRepository(database, "users", &logging.Logger{Logger: zerolog.Nop()})
+	if _, err := d.EnsureSchema(ctx, "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	// больше десяти пользователей: строка "9" больше "10", а id - нет
+	for i := 1; i <= 12; i++ {
+		id := d.MakeID()
+		if id != strconv.Itoa(i) {
+			t.Errorf("user %d: got id %q", i, id)
+		}
+		if err := d.Create(ctx, &models.UserModel{ID: id, Name: "user", Age: "20"}); err != nil {
+			t.Fatalf("user %d: %v", i, err)
+		}
+	}
+
+	// без счетчика id продолжается после наибольшего числового
+	if _, err := database.Collection("counters").DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+	if id := d.MakeID(); id != "13" {
+		t.Errorf("after counter reset: got id %q want 13", id)
+	}
+}
+
+func TestCreate_Duplicate(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	d := NewMongoRepository(database, "users", &logging.Logger{Logger: zerolog.Nop()})
+	if _, err := d.EnsureSchema(ctx, "error"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(ctx, &models.UserModel{ID: "1", Name: "John", Age: "24"}); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Create(ctx, &models.UserModel{ID: "1", Name: "Copy", Age: "30"})
+	if !errors.Is(err, models.ErrUserExists) {
+		t.Errorf("got error %v want %v", err, models.ErrUserExists)
+	}
+}
+
+func TestRequireTransactions(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	supported, err := NewMongoRepository(database, "users", logger).detectTransactions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// на одиночном сервере старт возможен только с явным разрешением
+	var expected error
+	if !supported {
+		expected = ErrNoTransactions
+	}
+	if err = NewMongoRepository(database, "users", logger).RequireTransactions(ctx, false); err != expected {
+		t.Errorf("without permission: got error %v want %v", err, expected)
+	}
+	d := NewMongoRepository(database, "users", logger)
+	if err = d.RequireTransactions(ctx, true); err != nil {
+		t.Errorf("with permission: got error %v", err)
+	}
+	if d.txSupported != supported {
+		t.Errorf("transaction support is not saved: got %v want %v", d.txSupported, supported)
+	}
+}
+
+func TestBlock_Outbox(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	edges, err := NewMongoEdgeRepository(ctx, database, "edge_users", "friendships", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repositories := []struct {
+		name       string
+		repository interface {
+			Create(ctx context.Context, u *models.UserModel) error
+			MakeFriends(ctx context.Context, sourceId, targetId string) (string, error)
+			Block(ctx context.Context, id, targetID string) error
+			Unblock(ctx context.Context, id, targetID string) error
+			EnableOutbox(collection string)
+		}
+		outbox string
+	}{
+		{"arrays", NewMongoRepository(database, "users", logger), "outbox"},
+		{"edges", edges, "edge_outbox"},
+	}
+	for _, test := range repositories {
+		d := test.repository
+		d.EnableOutbox(test.outbox)
+		for _, id := range []string{"1", "2", "3"} {
+			if err = d.Create(ctx, &models.UserModel{ID: id, Name: "user", Age: "20"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = d.MakeFriends(ctx, "1", "2"); err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Block(ctx, "2", "1"); err != nil {
+			t.Fatal(err)
+		}
+		// 1 и 3 не дружили, разрывать нечего
+		if err = d.Block(ctx, "1", "3"); err != nil {
+			t.Fatal(err)
+		}
+
+		filter := bson.M{"event.type": models.EventFriendshipDeleted}
+		var records []struct {
+			Event models.Event `bson:"event"`
+		}
+		cursor, err := database.Collection(test.outbox).Find(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cursor.All(ctx, &records); err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || !reflect.DeepEqual(records[0].Event.UserIDs, []string{"2", "1"}) {
+			t.Errorf("%s: wrong friendship.deleted events: got %+v", test.name, records)
+		}
+
+		if err = d.Unblock(ctx, "3", "1"); !errors.Is(err, models.ErrNotBlocked) {
+			t.Errorf("%s: got error %v want %v", test.name, err, models.ErrNotBlocked)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/outbox"
+)
+
+// EnableOutbox включает запись событий в коллекцию outbox вместе с изменениями данных
+func (d *db) EnableOutbox(collection string) {
+	d.outbox = d.collection.Database().Collection(collection)
+}
+
+// emit пишет событие в outbox, вызывается внутри withTransaction
+func (d *db) emit(ctx context.Context, e *models.Event) error {
+	if d.outbox == nil {
+		return nil
+	}
+	if _, err := d.outbox.InsertOne(ctx, outbox.NewRecord(e)); err != nil {
+		return fmt.Errorf("can't write outbox event: %w", err)
+	}
+	return nil
+}
+
+// EnableOutbox включает запись событий хранилища в памяти в store
+func (r *repository) EnableOutbox(store *outbox.MemoryStore) {
+	r.outbox = store
+}
+
+func (r *repository) emit(e *models.Event) {
+	if r.outbox != nil {
+		r.outbox.Add(e)
+	}
+}
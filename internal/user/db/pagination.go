@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strconv"
+)
+
+// сортировка без учета регистра, возраст и id сравниваются как числа
+var friendsCollation = &options.Collation{Locale: "en", Strength: 2, NumericOrdering: true}
+
+func friendsProjection(q models.FriendsQuery) *options.FindOptions {
+	opts := options.Find()
+	if len(q.Fields) == 0 {
+		return opts
+	}
+	projection := bson.M{"_id": 0, "id": 1}
+	for _, f := range q.Fields {
+		projection[f] = 1
+	}
+	if q.Sort == models.SortByName || q.Sort == models.SortByAge {
+		projection[q.Sort] = 1
+	}
+	return opts.SetProjection(projection)
+}
+
+func (d *db) FindFriendsPage(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, error) {
+	u, err := d.FindUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var page *models.FriendsPage
+	switch q.Sort {
+	case models.SortByName, models.SortByAge:
+		page, err = d.pageByKey(ctx, u.FriendIDs, q)
+	default:
+		page, err = d.pageByPosition(ctx, u.FriendIDs, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = d.hideDeleted(ctx, page.Friends...); err != nil {
+		return nil, err
+	}
+	page.Friendships = make(map[string]*models.Friendship, len(page.Friends))
+	for _, f := range page.Friends {
+		if meta, ok := u.Friendships[f.ID]; ok {
+			page.Friendships[f.ID] = meta
+		}
+	}
+	d.logger.Debug().Msg("method FindFriendsPage finished")
+	return page, nil
+}
+
+// pageByKey - страница друзей из ids, отсортированных по имени или возрасту.
+// Продолжение ищется по значению ключа и id последнего друга, без skip
+func (d *db) pageByKey(ctx context.Context, ids []string, q models.FriendsQuery) (*models.FriendsPage, error) {
+	page := &models.FriendsPage{Friends: []*models.UserModel{}}
+	if len(ids) == 0 {
+		return page, nil
+	}
+	filter := live(bson.M{"id": bson.M{"$in": ids}})
+	if q.After != nil {
+		filter["$or"] = bson.A{
+			bson.M{q.Sort: bson.M{"$gt": q.After.Key}},
+			bson.M{q.Sort: q.After.Key, "id": bson.M{"$gt": q.After.ID}},
+		}
+	}
+	opts := friendsProjection(q).
+		SetSort(bson.D{{Key: q.Sort, Value: 1}, {Key: "id", Value: 1}}).
+		SetLimit(int64(q.Limit + 1)).
+		SetCollation(friendsCollation)
+	cursor, err := d.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &page.Friends); err != nil {
+		return nil, err
+	}
+
+	if len(page.Friends) > q.Limit {
+		page.Friends = page.Friends[:q.Limit]
+		last := page.Friends[q.Limit-1]
+		key := last.Name
+		if q.Sort == models.SortByAge {
+			key = last.Age
+		}
+		page.Next = &models.Cursor{Key: key, ID: last.ID}
+	}
+	return page, nil
+}
+
+// pageByPosition - страница в порядке добавления в друзья, ключ курсора - позиция в массиве
+func (d *db) pageByPosition(ctx context.Context, ids []string, q models.FriendsQuery) (*models.FriendsPage, error) {
+	start := 0
+	if q.After != nil {
+		pos, err := strconv.Atoi(q.After.Key)
+		if err != nil {
+			return nil, models.ErrInvalidCursor
+		}
+		start = pos + 1
+		// если список друзей изменился, ищем последнего друга по id
+		if pos >= len(ids) || ids[pos] != q.After.ID {
+			for i, friendID := range ids {
+				if friendID == q.After.ID {
+					start = i + 1
+				}
+			}
+		}
+	}
+	if start >= len(ids) {
+		return &models.FriendsPage{Friends: []*models.UserModel{}}, nil
+	}
+
+	window := ids[start:]
+	page := &models.FriendsPage{}
+	if len(window) > q.Limit {
+		window = window[:q.Limit]
+		page.Next = &models.Cursor{Key: strconv.Itoa(start + q.Limit - 1), ID: window[q.Limit-1]}
+	}
+	friends, err := d.findByIDs(ctx, window, friendsProjection(q))
+	if err != nil {
+		return nil, err
+	}
+	page.Friends = friends
+	return page, nil
+}
+
+func (d *edgeDB) FindFriendsPage(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, error) {
+	if _, err := d.db.FindUser(ctx, id); err != nil {
+		return nil, err
+	}
+	var page *models.FriendsPage
+	var edges []edgeDoc
+	var err error
+	switch q.Sort {
+	case models.SortByName, models.SortByAge:
+		edges, err = d.ownerEdges(ctx, id, bson.M{}, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, 0, len(edges))
+		for _, e := range edges {
+			ids = append(ids, e.other(id))
+		}
+		page, err = d.pageByKey(ctx, ids, q)
+	default:
+		page, edges, err = d.pageByEdge(ctx, id, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// связи с удаленными друзьями в страницу не попадают
+	onPage := make(map[string]bool, len(page.Friends))
+	for _, f := range page.Friends {
+		onPage[f.ID] = true
+	}
+	page.Friendships = make(map[string]*models.Friendship, len(page.Friends))
+	for _, e := range edges {
+		if !onPage[e.other(id)] {
+			continue
+		}
+		meta := e.Friendship
+		page.Friendships[e.other(id)] = &meta
+	}
+
+	// списки друзей хранятся в связях, достаем их одним запросом
+	if len(q.Fields) == 0 || contains(q.Fields, "friends") {
+		ids := make([]string, 0, len(page.Friends))
+		for _, f := range page.Friends {
+			ids = append(ids, f.ID)
+		}
+		friendsOf, err := d.friendIDs(ctx, ids...)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range page.Friends {
+			f.FriendIDs = friendsOf[f.ID]
+		}
+	}
+	d.logger.Debug().Msg("method FindFriendsPage finished")
+	return page, nil
+}
+
+// ownerEdges возвращает связи пользователя в порядке создания
+func (d *edgeDB) ownerEdges(ctx context.Context, id string, extra bson.M, limit int) ([]edgeDoc, error) {
+	filter := edgesOf(id)
+	if len(extra) > 0 {
+		filter = bson.M{"$and": bson.A{filter, extra}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := d.edges.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var edges []edgeDoc
+	if err = cursor.All(ctx, &edges); err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
+
+// pageByEdge - страница в порядке создания связей, ключ курсора - _id последней связи
+func (d *edgeDB) pageByEdge(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, []edgeDoc, error) {
+	extra := bson.M{}
+	if q.After != nil {
+		after, err := primitive.ObjectIDFromHex(q.After.Key)
+		if err != nil {
+			return nil, nil, models.ErrInvalidCursor
+		}
+		extra["_id"] = bson.M{"$gt": after}
+	}
+	edges, err := d.ownerEdges(ctx, id, extra, q.Limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	page := &models.FriendsPage{Friends: []*models.UserModel{}}
+	if len(edges) > q.Limit {
+		edges = edges[:q.Limit]
+		last := edges[q.Limit-1]
+		page.Next = &models.Cursor{Key: last.ID.Hex(), ID: last.other(id)}
+	}
+	if len(edges) == 0 {
+		return page, edges, nil
+	}
+	ids := make([]string, 0, len(edges))
+	for _, e := range edges {
+		ids = append(ids, e.other(id))
+	}
+	page.Friends, err = d.findByIDs(ctx, ids, friendsProjection(q))
+	if err != nil {
+		return nil, nil, err
+	}
+	return page, edges, nil
+}
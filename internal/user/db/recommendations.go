@@ -0,0 +1,41 @@
+package db
+
+import (
+	"github.com/ast3am/educationProject/internal/models"
+	"sort"
+)
+
+// friendsOfFriends ранжирует друзей друзей по числу общих друзей.
+// Исключаются сам пользователь, его друзья и заблокированные в любую сторону
+func friendsOfFriends(u *models.UserModel, friends []*models.UserModel) map[string]int {
+	mutual := make(map[string]int)
+	for _, f := range friends {
+		for _, candidate := range f.FriendIDs {
+			if candidate == u.ID || contains(u.FriendIDs, candidate) || contains(u.Blocked, candidate) {
+				continue
+			}
+			mutual[candidate]++
+		}
+	}
+	return mutual
+}
+
+func rankSuggestions(u *models.UserModel, candidates []*models.UserModel, mutual map[string]int, limit int) []*models.Suggestion {
+	res := make([]*models.Suggestion, 0, len(candidates))
+	for _, c := range candidates {
+		if contains(c.Blocked, u.ID) {
+			continue
+		}
+		res = append(res, &models.Suggestion{User: c, Mutual: mutual[c.ID]})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Mutual != res[j].Mutual {
+			return res[i].Mutual > res[j].Mutual
+		}
+		return naturalCompare(res[i].User.ID, res[j].User.ID) < 0
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// live добавляет к фильтру условие, что пользователь не удален
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// deletedIDs возвращает id удаленных пользователей из переданного списка
+func (d *db) deletedIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	deleted := make(map[string]bool)
+	if len(ids) == 0 {
+		return deleted, nil
+	}
+	filter := bson.M{"id": bson.M{"$in": ids}, "deleted_at": bson.M{"$exists": true}}
+	cursor, err := d.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("can't find deleted users: %w", err)
+	}
+	var users []models.UserModel
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("can't decode deleted users: %w", err)
+	}
+	for _, u := range users {
+		deleted[u.ID] = true
+	}
+	return deleted, nil
+}
+
+// hideDeleted убирает удаленных пользователей из списков друзей
+func (d *db) hideDeleted(ctx context.Context, users ...*models.UserModel) error {
+	ids := make([]string, 0)
+	for _, u := range users {
+		ids = append(ids, u.FriendIDs...)
+	}
+	deleted, err := d.deletedIDs(ctx, ids)
+	if err != nil || len(deleted) == 0 {
+		return err
+	}
+	for _, u := range users {
+		u.HideFriends(func(id string) bool {
+			return deleted[id]
+		})
+	}
+	return nil
+}
+
+// Delete помечает пользователя удаленным. Друзья и блокировки не меняются,
+// пока пользователь не будет окончательно удален в Purge
+func (d *db) Delete(ctx context.Context, id string) (string, error) {
+	updateOptions := bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}}
+	notFound := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден")
+	err := d.withTransaction(ctx, func(ctx context.Context) error {
+		result, err := d.collection.UpdateOne(ctx, live(bson.M{"id": id}), updateOptions)
+		if err != nil {
+			return err
+		}
+		//проверка на то, что пользователь удален
+		if result.MatchedCount == 0 {
+			return notFound
+		}
+		return d.emit(ctx, &models.Event{Type: models.EventUserDeleted, UserIDs: []string{id}})
+	})
+	if err == notFound {
+		return "", err
+	}
+	if err != nil {
+		err = errors.New("failed to execute with filter")
+		return "", err
+	}
+
+	d.logger.Debug().Msgf("Удален пользователь с id %s", id)
+	return fmt.Sprint("пользователь ", id, " удален"), nil
+}
+
+func (d *db) Restore(ctx context.Context, id string, deletedAfter time.Time) error {
+	u := models.UserModel{}
+	filter := bson.M{"id": id, "deleted_at": bson.M{"$exists": true}}
+	err := d.collection.FindOne(ctx, filter).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		err = models.NewError(models.ErrNotFound, "Удаленный пользователь "+id+" не найден\n")
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("can't find deleted user: %w", err)
+	}
+	if u.DeletedAt.Before(deletedAfter) {
+		return fmt.Errorf("%w: пользователь %s удален %s\n", models.ErrRetentionExpired, id, u.DeletedAt.Format(time.RFC3339))
+	}
+
+	// условие на deleted_at защищает от гонки с Purge
+	filter["deleted_at"] = u.DeletedAt
+	res, err := d.collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"deleted_at": ""}})
+	if err != nil {
+		return fmt.Errorf("can't restore user: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		err = models.NewError(models.ErrNotFound, "Удаленный пользователь "+id+" не найден\n")
+		return err
+	}
+	d.logger.Debug().Msgf("Восстановлен пользователь с id %s", id)
+	return nil
+}
+
+func (d *db) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return d.purge(ctx, deletedBefore, d.unfriendAll)
+}
+
+// purge окончательно удаляет пользователей, удаленных раньше deletedBefore,
+// cleanup убирает дружбу с удаляемым в зависимости от схемы хранения
+func (d *db) purge(ctx context.Context, deletedBefore time.Time, cleanup func(ctx context.Context, id string) error) (int, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": deletedBefore}}
+	cursor, err := d.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("can't find users to purge: %w", err)
+	}
+	var users []models.UserModel
+	if err = cursor.All(ctx, &users); err != nil {
+		return 0, fmt.Errorf("can't decode users to purge: %w", err)
+	}
+
+	purged := 0
+	for _, u := range users {
+		id := u.ID
+		err = d.withTransaction(ctx, func(ctx context.Context) error {
+			res, err := d.collection.DeleteOne(ctx, bson.M{"id": id, "deleted_at": bson.M{"$lt": deletedBefore}})
+			if err != nil || res.DeletedCount == 0 {
+				return err
+			}
+			if err = cleanup(ctx, id); err != nil {
+				return err
+			}
+			_, err = d.collection.UpdateMany(ctx, bson.M{"blocked": id}, bson.M{"$pull": bson.M{"blocked": id}})
+			return err
+		})
+		if err != nil {
+			return purged, fmt.Errorf("can't purge user %s: %w", id, err)
+		}
+		purged++
+		d.logger.Info().Str("user", id).Msg("user purged")
+	}
+	return purged, nil
+}
+
+// unfriendAll удаляет пользователя из массивов друзей
+func (d *db) unfriendAll(ctx context.Context, id string) error {
+	updateFilter := bson.D{{Key: "friends", Value: id}}
+	updateOptions := bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "friends", Value: id}}},
+		{Key: "$unset", Value: bson.D{{Key: "friendships." + id, Value: ""}}},
+	}
+	_, err := d.collection.UpdateMany(ctx, updateFilter, updateOptions)
+	return err
+}
+
+func (d *edgeDB) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return d.purge(ctx, deletedBefore, d.deleteEdges)
+}
+
+func (d *edgeDB) deleteEdges(ctx context.Context, id string) error {
+	_, err := d.edges.DeleteMany(ctx, edgesOf(id))
+	return err
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// namespaceNotFound - код ошибки collMod для несуществующей коллекции
+const namespaceNotFound = 26
+
+func stringArray() bson.M {
+	return bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}}
+}
+
+// userSchema - $jsonSchema документа models.UserModel вместе с ключами поиска.
+// Лишние поля разрешены, чтобы старые документы не мешали обновлениям
+func userSchema() bson.M {
+	return bson.M{
+		"bsonType": "object",
+		"required": bson.A{"id", "name", "age"},
+		"properties": bson.M{
+			"id":      bson.M{"bsonType": "string", "minLength": 1},
+			"name":    bson.M{"bsonType": "string"},
+			"age":     bson.M{"bsonType": "string"},
+			"friends": stringArray(),
+			"friendships": bson.M{
+				"bsonType": "object",
+				"additionalProperties": bson.M{
+					"bsonType": "object",
+					"required": bson.A{"created_at", "initiator"},
+					"properties": bson.M{
+						"created_at": bson.M{"bsonType": "date"},
+						"initiator":  bson.M{"bsonType": "string"},
+						"label":      bson.M{"bsonType": "string"},
+						"closeness":  bson.M{"bsonType": bson.A{"double", "int", "long"}},
+					},
+				},
+			},
+			"blocked":    stringArray(),
+			"deleted_at": bson.M{"bsonType": "date"},
+			"search": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"name":     bson.M{"bsonType": "string"},
+					"words":    stringArray(),
+					"trigrams": stringArray(),
+				},
+			},
+		},
+	}
+}
+
+// EnsureSchema ставит валидатор на коллекцию пользователей, создает индексы
+// по id, друзьям и для поиска и возвращает получившиеся индексы.
+// action - error (неверный документ отклоняется) или warn (только запись в лог MongoDB)
+func (d *db) EnsureSchema(ctx context.Context, action string) (*models.SchemaReport, error) {
+	if err := d.ensureValidator(ctx, action); err != nil {
+		return nil, err
+	}
+	if err := d.ensureUserIndexes(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.EnsureSearchIndex(ctx); err != nil {
+		return nil, err
+	}
+	indexes, err := listIndexes(ctx, d.collection)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SchemaReport{ValidationAction: action, Indexes: indexes}, nil
+}
+
+// ensureValidator меняет валидатор существующей коллекции или создает коллекцию с ним.
+// moderate не проверяет обновления документов, которые уже не подходили под схему
+func (d *db) ensureValidator(ctx context.Context, action string) error {
+	validator := bson.M{"$jsonSchema": userSchema()}
+	err := d.collection.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: d.collection.Name()},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "moderate"},
+		{Key: "validationAction", Value: action},
+	}).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+		opts := options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel("moderate").
+			SetValidationAction(action)
+		err = d.collection.Database().CreateCollection(ctx, d.collection.Name(), opts)
+	}
+	if err != nil {
+		return fmt.Errorf("can't set user validator: %w", err)
+	}
+	return nil
+}
+
+func (d *db) ensureUserIndexes(ctx context.Context) error {
+	_, err := d.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("id_unique").SetUnique(true),
+		},
+		{
+			// multikey: по элементу массива находятся все, у кого он в друзьях
+			Keys:    bson.D{{Key: "friends", Value: 1}},
+			Options: options.Index().SetName("friends"),
+		},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("can't create unique index on id, duplicates are listed by GET /admin/consistency: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("can't create user indexes: %w", err)
+	}
+	return nil
+}
+
+// listIndexes - индексы коллекции в том виде, в каком они есть в MongoDB
+func listIndexes(ctx context.Context, collection *mongo.Collection) ([]models.IndexStatus, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list indexes of %s: %w", collection.Name(), err)
+	}
+	var specs []struct {
+		Name   string `bson:"name"`
+		Key    bson.D `bson:"key"`
+		Unique bool   `bson:"unique"`
+	}
+	if err = cursor.All(ctx, &specs); err != nil {
+		return nil, fmt.Errorf("can't decode indexes of %s: %w", collection.Name(), err)
+	}
+	indexes := make([]models.IndexStatus, 0, len(specs))
+	for _, spec := range specs {
+		status := models.IndexStatus{Collection: collection.Name(), Name: spec.Name, Unique: spec.Unique}
+		for _, key := range spec.Key {
+			status.Keys = append(status.Keys, fmt.Sprint(key.Key, ":", key.Value))
+		}
+		indexes = append(indexes, status)
+	}
+	return indexes, nil
+}
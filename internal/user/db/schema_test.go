@@ -0,0 +1,247 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Нужен запущенный MongoDB: MONGO_TEST_URI=mongodb://localhost:27017 go test -run Schema ./internal/user/db
+func testDatabase(t *testing.T) *mongo.Database {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database("test_schema")
+	database.Drop(ctx)
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return database
+}
+
+// schemaAt - вложенная схема по пути из имен свойств, "*" - additionalProperties
+func schemaAt(t *testing.T, schema bson.M, path ...string) bson.M {
+	for _, name := range path {
+		var next interface{}
+		if name == "*" {
+			next = schema["additionalProperties"]
+		} else if props, ok := schema["properties"].(bson.M); ok {
+			next = props[name]
+		}
+		s, ok := next.(bson.M)
+		if !ok {
+			t.Fatalf("no schema at %s", strings.Join(path, "."))
+		}
+		schema = s
+	}
+	return schema
+}
+
+func TestUserSchema(t *testing.T) {
+	schema := userSchema()
+
+	requiredTable := []struct {
+		path     []string
+		expected bson.A
+	}{
+		{nil, bson.A{"id", "name", "age"}},
+		{[]string{"friendships", "*"}, bson.A{"created_at", "initiator"}},
+	}
+	for _, test := range requiredTable {
+		if got := schemaAt(t, schema, test.path...)["required"]; !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got required %v want %v", strings.Join(test.path, "."), got, test.expected)
+		}
+	}
+
+	typeTable := []struct {
+		path     []string
+		expected interface{}
+	}{
+		{nil, "object"},
+		{[]string{"id"}, "string"},
+		{[]string{"name"}, "string"},
+		{[]string{"age"}, "string"},
+		{[]string{"friends"}, "array"},
+		{[]string{"blocked"}, "array"},
+		{[]string{"deleted_at"}, "date"},
+		{[]string{"friendships"}, "object"},
+		{[]string{"friendships", "*"}, "object"},
+		{[]string{"friendships", "*", "created_at"}, "date"},
+		{[]string{"friendships", "*", "initiator"}, "string"},
+		{[]string{"friendships", "*", "label"}, "string"},
+		{[]string{"friendships", "*", "closeness"}, bson.A{"double", "int", "long"}},
+		{[]string{"search", "name"}, "string"},
+		{[]string{"search", "words"}, "array"},
+		{[]string{"search", "trigrams"}, "array"},
+	}
+	for _, test := range typeTable {
+		if got := schemaAt(t, schema, test.path...)["bsonType"]; !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got type %v want %v", strings.Join(test.path, "."), got, test.expected)
+		}
+	}
+	for _, path := range [][]string{{"friends"}, {"blocked"}, {"search", "words"}, {"search", "trigrams"}} {
+		if got := schemaAt(t, schema, path...)["items"]; !reflect.DeepEqual(got, bson.M{"bsonType": "string"}) {
+			t.Errorf("%s: got items %v want strings", strings.Join(path, "."), got)
+		}
+	}
+	if got := schemaAt(t, schema, "id")["minLength"]; got != 1 {
+		t.Errorf("id: got minLength %v want 1", got)
+	}
+
+	// каждое поле модели описано в схеме
+	props := schema["properties"].(bson.M)
+	model := reflect.TypeOf(models.UserModel{})
+	for i := 0; i < model.NumField(); i++ {
+		name := strings.Split(model.Field(i).Tag.Get("bson"), ",")[0]
+		if _, ok := props[name]; !ok {
+			t.Errorf("field %s of UserModel is not in the schema", name)
+		}
+	}
+}
+
+func TestEnsureSchema(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	logger := &logging.Logger{Logger: zerolog.Nop()}
+	d := NewMongoRepository(database, "users", logger)
+
+	report, err := d.EnsureSchema(ctx, "error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ValidationAction != "error" {
+		t.Errorf("wrong validation action: got %s want error", report.ValidationAction)
+	}
+	indexes := make(map[string]models.IndexStatus)
+	for _, index := range report.Indexes {
+		indexes[index.Name] = index
+	}
+	expectedID := models.IndexStatus{Collection: "users", Name: "id_unique", Keys: []string{"id:1"}, Unique: true}
+	if !reflect.DeepEqual(indexes["id_unique"], expectedID) {
+		t.Errorf("wrong id index: got %+v want %+v", indexes["id_unique"], expectedID)
+	}
+	if keys := indexes["friends"].Keys; !reflect.DeepEqual(keys, []string{"friends:1"}) {
+		t.Errorf("wrong friends index: got %v", keys)
+	}
+	for _, name := range []string{"search.words_1", "search.trigrams_1"} {
+		if _, ok := indexes[name]; !ok {
+			t.Errorf("index %s is not created", name)
+		}
+	}
+
+	// повторный запуск ничего не меняет
+	if _, err = d.EnsureSchema(ctx, "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeness := 0.5
+	since := time.Now().UTC().Truncate(time.Millisecond)
+	valid := &models.UserModel{
+		ID: "1", Name: "John", Age: "24", FriendIDs: []string{"2"},
+		Friendships: map[string]*models.Friendship{"2": {CreatedAt: since, Initiator: "1", Closeness: &closeness}},
+		Blocked:     []string{"3"},
+		DeletedAt:   &since,
+	}
+	if err = d.Create(ctx, valid); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.collection.InsertOne(ctx, &models.UserModel{ID: "1", Name: "Copy", Age: "30"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("duplicate id: got error %v want duplicate key", err)
+	}
+
+	testTable := []struct {
+		name string
+		doc  bson.M
+	}{
+		{"no id", bson.M{"name": "Nate", "age": "25"}},
+		{"empty id", bson.M{"id": "", "name": "Nate", "age": "25"}},
+		{"age as number", bson.M{"id": "4", "name": "Nate", "age": 25}},
+		{"friends not strings", bson.M{"id": "4", "name": "Nate", "age": "25", "friends": bson.A{4}}},
+		{"friendship without date", bson.M{"id": "4", "name": "Nate", "age": "25",
+			"friendships": bson.M{"2": bson.M{"initiator": "4"}}}},
+		{"friendship date as string", bson.M{"id": "4", "name": "Nate", "age": "25",
+			"friendships": bson.M{"2": bson.M{"created_at": "yesterday", "initiator": "4"}}}},
+	}
+	for _, test := range testTable {
+		_, err = d.collection.InsertOne(ctx, test.doc)
+		var writeErr mongo.WriteException
+		if !errors.As(err, &writeErr) {
+			t.Errorf("%s: got error %v want validation error", test.name, err)
+			continue
+		}
+		// 121 - DocumentValidationFailure
+		if code := writeErr.WriteErrors[0].Code; code != 121 {
+			t.Errorf("%s: got code %d want 121", test.name, code)
+		}
+	}
+
+	// метка у старой дружбы без метаданных: UpdateFriendship дописывает created_at и initiator
+	_, err = d.collection.InsertMany(ctx, []interface{}{
+		bson.M{"id": "6", "name": "Kate", "age": "30", "friends": bson.A{"7"}},
+		bson.M{"id": "7", "name": "Mike", "age": "31", "friends": bson.A{"6"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	label := "school"
+	meta, err := d.UpdateFriendship(ctx, "6", "7", models.FriendshipUpdate{Label: &label})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Label != label || meta.Initiator != "6" || meta.CreatedAt.IsZero() {
+		t.Errorf("wrong friendship: got %+v", meta)
+	}
+	// у дружбы с метаданными они не меняются
+	meta, err = d.UpdateFriendship(ctx, "7", "6", models.FriendshipUpdate{Closeness: &closeness})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Label != label || meta.Initiator != "6" || *meta.Closeness != closeness {
+		t.Errorf("wrong friendship after second update: got %+v", meta)
+	}
+
+	// warn пропускает неверные документы
+	if _, err = d.EnsureSchema(ctx, "warn"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.collection.InsertOne(ctx, bson.M{"id": "5", "name": "Helen", "age": 18}); err != nil {
+		t.Errorf("warn rejected the document: %v", err)
+	}
+}
+
+func TestEnsureSchema_Duplicates(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	d := NewMongoRepository(database, "users", &logging.Logger{Logger: zerolog.Nop()})
+	_, err := d.collection.InsertMany(ctx, []interface{}{
+		bson.M{"id": "1", "name": "John", "age": "24"},
+		bson.M{"id": "1", "name": "Copy", "age": "24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.EnsureSchema(ctx, "error")
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("got error %v want duplicate key", err)
+	}
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+)
+
+// searchKeys хранятся в документе пользователя и индексируются:
+// words - для поиска по префиксу, name - по подстроке, trigrams - для нечеткого поиска
+type searchKeys struct {
+	Name     string   `bson:"name"`
+	Words    []string `bson:"words"`
+	Trigrams []string `bson:"trigrams"`
+}
+
+// userDoc - документ пользователя вместе с ключами поиска, вставляется одной записью
+type userDoc struct {
+	models.UserModel `bson:",inline"`
+	Search           *searchKeys `bson:"search"`
+}
+
+func newSearchKeys(name string) *searchKeys {
+	return &searchKeys{
+		Name:     search.Normalize(name),
+		Words:    search.Words(name),
+		Trigrams: search.Trigrams(name),
+	}
+}
+
+// EnsureSearchIndex создает индексы поиска. Ключи пользователей, созданных
+// до них, заполняет миграция 1
+func (d *db) EnsureSearchIndex(ctx context.Context) error {
+	_, err := d.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "search.words", Value: 1}}},
+		{Keys: bson.D{{Key: "search.name", Value: 1}}},
+		{Keys: bson.D{{Key: "search.trigrams", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("can't create search indexes: %w", err)
+	}
+	return nil
+}
+
+func (d *db) Search(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error) {
+	page, err := d.searchPage(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*models.UserModel, 0, len(page.Results))
+	for _, r := range page.Results {
+		users = append(users, r.User)
+	}
+	if err = d.hideDeleted(ctx, users...); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// searchFilter отбирает кандидатов по индексам ключей поиска, окончательно их проверяет search.Score
+func searchFilter(q models.SearchQuery) bson.M {
+	text := search.Normalize(q.Text)
+	filter := live(bson.M{})
+	switch q.Mode {
+	case models.SearchPrefix:
+		filter["search.words"] = bson.M{"$regex": "^" + regexp.QuoteMeta(text)}
+	case models.SearchContains:
+		// имя с подстрокой содержит все ее внутренние триграммы. Запрос короче
+		// триграммы проверяется по индексу search.name без чтения документов
+		if inner := search.Inner(text); len(inner) > 0 {
+			filter["search.trigrams"] = bson.M{"$all": inner}
+		} else {
+			filter["search.name"] = bson.M{"$regex": regexp.QuoteMeta(text)}
+		}
+	default:
+		filter["search.trigrams"] = bson.M{"$in": search.Trigrams(text)}
+	}
+	return filter
+}
+
+// searchPage оценивает всех кандидатов до выбора страницы, поэтому лучшие совпадения
+// не теряются. Из базы читаются только id и имя, документы целиком - для страницы
+func (d *db) searchPage(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error) {
+	opts := options.Find().SetProjection(bson.M{"id": 1, "name": 1})
+	cursor, err := d.collection.Find(ctx, searchFilter(q), opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't search users: %w", err)
+	}
+	defer cursor.Close(ctx)
+	results := make([]*models.SearchResult, 0)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID   string `bson:"id"`
+			Name string `bson:"name"`
+		}
+		if err = cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("can't decode users: %w", err)
+		}
+		if score, ok := search.Score(q.Mode, q.Text, doc.Name); ok {
+			results = append(results, &models.SearchResult{User: &models.UserModel{ID: doc.ID, Name: doc.Name}, Score: score})
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("can't search users: %w", err)
+	}
+	page, err := search.Page(results, q)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(page.Results))
+	for _, r := range page.Results {
+		ids = append(ids, r.User.ID)
+	}
+	users, err := d.findByIDs(ctx, ids, options.Find().SetProjection(bson.M{"search": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("can't find users: %w", err)
+	}
+	byID := make(map[string]*models.UserModel, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	// пользователь мог быть удален между запросами
+	found := page.Results[:0]
+	for _, r := range page.Results {
+		if u, ok := byID[r.User.ID]; ok {
+			r.User = u
+			found = append(found, r)
+		}
+	}
+	page.Results = found
+	d.logger.Debug().Msg("method Search finished")
+	return page, nil
+}
+
+func (d *edgeDB) Search(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error) {
+	page, err := d.searchPage(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	// списки друзей только для найденной страницы
+	ids := make([]string, 0, len(page.Results))
+	for _, r := range page.Results {
+		ids = append(ids, r.User.ID)
+	}
+	if len(ids) > 0 {
+		friendsOf, err := d.friendIDs(ctx, ids...)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Results {
+			r.User.FriendIDs = friendsOf[r.User.ID]
+		}
+	}
+	return page, nil
+}
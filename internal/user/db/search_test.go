@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+func TestMongoSearch(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	d := NewMongoRepository(database, "users", &logging.Logger{Logger: zerolog.Nop()})
+	if err := d.EnsureSearchIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*models.UserModel{
+		{ID: "1", Name: "Helena", Age: "18"},
+		{ID: "2", Name: "Helen", Age: "20"},
+		{ID: "3", Name: "Michelle", Age: "30"},
+		{ID: "4", Name: "Bob", Age: "40"},
+	} {
+		if err := d.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// ключи поиска записаны вместе с пользователем
+	var doc struct {
+		Search *searchKeys `bson:"search"`
+	}
+	if err := d.collection.FindOne(ctx, bson.M{"id": "2"}).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Search == nil || doc.Search.Name != "helen" {
+		t.Errorf("search keys are not stored on create: got %+v", doc.Search)
+	}
+
+	testTable := []struct {
+		name     string
+		query    models.SearchQuery
+		expected []string
+	}{
+		{"prefix", models.SearchQuery{Text: "hel", Mode: models.SearchPrefix, Limit: 10}, []string{"2", "1"}},
+		{"contains", models.SearchQuery{Text: "hel", Mode: models.SearchContains, Limit: 10}, []string{"2", "1", "3"}},
+		{"short contains", models.SearchQuery{Text: "ob", Mode: models.SearchContains, Limit: 10}, []string{"4"}},
+		{"fuzzy", models.SearchQuery{Text: "helne", Mode: models.SearchFuzzy, Limit: 10}, []string{"2", "1", "3"}},
+		{"first page", models.SearchQuery{Text: "hel", Mode: models.SearchContains, Limit: 1}, []string{"2"}},
+	}
+	for _, test := range testTable {
+		page, err := d.Search(ctx, test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		ids := make([]string, 0, len(page.Results))
+		for _, r := range page.Results {
+			if r.User.Age == "" {
+				t.Errorf("%s: user %s is not loaded", test.name, r.User.ID)
+			}
+			ids = append(ids, r.User.ID)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("%s: got %v want %v", test.name, ids, test.expected)
+		}
+	}
+}
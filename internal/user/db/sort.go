@@ -0,0 +1,59 @@
+package db
+
+import "strings"
+
+// naturalCompare сравнивает строки без учета регистра, а последовательности цифр - как числа.
+// Так же сортирует MongoDB с collation {locale: "en", strength: 2, numericOrdering: true}
+func naturalCompare(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ra, rb := []rune(a)[0], []rune(b)[0]
+		if isDigit(ra) && isDigit(rb) {
+			na, nb := digits(a), digits(b)
+			a, b = a[len(na):], b[len(nb):]
+			na, nb = strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(na) != len(nb) {
+				return compareInt(len(na), len(nb))
+			}
+			if na != nb {
+				return strings.Compare(na, nb)
+			}
+			continue
+		}
+		if ra != rb {
+			return compareInt(int(ra), int(rb))
+		}
+		a, b = a[len(string(ra)):], b[len(string(rb)):]
+	}
+	return compareInt(len(a), len(b))
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func digits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareKeys сравнивает пары (ключ сортировки, id), id разрешает равенство ключей
+func compareKeys(key, id, key2, id2 string) int {
+	if c := naturalCompare(key, key2); c != 0 {
+		return c
+	}
+	return naturalCompare(id, id2)
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNoTransactions - сервер MongoDB не поддерживает транзакции (одиночный сервер)
+var ErrNoTransactions = errors.New("mongodb does not support transactions")
+
+// RequireTransactions проверяет при старте, что сервер поддерживает транзакции.
+// Без них outbox и блокировки пишутся не атомарно с данными, поэтому с allow=false
+// возвращается ErrNoTransactions, а с allow=true в лог пишется предупреждение
+func (d *db) RequireTransactions(ctx context.Context, allow bool) error {
+	supported, err := d.detectTransactions(ctx)
+	if err != nil {
+		return fmt.Errorf("can't detect transaction support: %w", err)
+	}
+	d.txOnce.Do(func() {
+		d.txSupported = supported
+	})
+	if supported {
+		return nil
+	}
+	if !allow {
+		return ErrNoTransactions
+	}
+	d.logger.Warn().Msg("mongodb does not support transactions, events and blocks are written without them")
+	return nil
+}
+
+// withTransaction выполняет fn в транзакции, если сервер их поддерживает (replica set или mongos).
+// На одиночном сервере fn выполняется без транзакции, сервис запускается так только
+// после RequireTransactions с allow=true
+func (d *db) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	d.txOnce.Do(func() {
+		supported, err := d.detectTransactions(ctx)
+		if err != nil {
+			d.logger.Err(err).Msg("can't detect transaction support")
+		} else if !supported {
+			d.logger.Warn().Msg("mongodb does not support transactions, writing without them")
+		}
+		d.txSupported = supported
+	})
+	if !d.txSupported {
+		return fn(ctx)
+	}
+
+	session, err := d.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func (d *db) detectTransactions(ctx context.Context) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := d.collection.Database().RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
@@ -5,25 +5,41 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/outbox"
+	"github.com/ast3am/educationProject/internal/search"
 	"github.com/ast3am/educationProject/pkg/logging"
+	"sort"
 	"strconv"
+	"time"
 )
 
 type repository struct {
 	storage map[string]*models.UserModel
+	index   *search.Index
+	outbox  *outbox.MemoryStore
 	id      int
 	logger  *logging.Logger
 }
 
 func NewRepository(ctx context.Context, rep map[string]*models.UserModel, logger *logging.Logger) *repository {
+	index := search.NewIndex()
+	for id, u := range rep {
+		index.Add(id, u.Name)
+	}
 	return &repository{
 		storage: rep,
+		index:   index,
 		logger:  logger,
 	}
 }
 
 func (r *repository) Create(ctx context.Context, user *models.UserModel) error {
-	r.storage[user.ID] = user
+	if _, ok := r.storage[user.ID]; ok {
+		return models.NewError(models.ErrUserExists, "пользователь с id "+user.ID+" уже существует")
+	}
+	r.storage[user.ID] = user.Copy()
+	r.index.Add(user.ID, user.Name)
+	r.emit(&models.Event{Type: models.EventUserCreated, UserIDs: []string{user.ID}, User: user.Copy()})
 	r.logger.Debug().Msg("method Create finished")
 	return nil
 }
@@ -31,20 +47,24 @@ func (r *repository) Create(ctx context.Context, user *models.UserModel) error {
 func (r *repository) MakeFriends(ctx context.Context, id, id2 string) (string, error) {
 	var err error
 	// проверка на существование пользователей
-	_, ok := r.storage[id]
-	_, ok2 := r.storage[id2]
+	_, ok := r.get(id)
+	_, ok2 := r.get(id2)
 	switch {
+	case id == id2:
+		{
+			err = models.NewError(models.ErrSelfFriendship, "Пользователь "+id+" не может дружить сам с собой\n")
+		}
 	case !ok && !ok2:
 		{
-			err = errors.New("Пользователи " + id + " " + id2 + " не найдены\n")
+			err = models.NewError(models.ErrNotFound, "Пользователи "+id+" "+id2+" не найдены\n")
 		}
 	case !ok:
 		{
-			err = errors.New("Пользователь " + id + " не найден\n")
+			err = models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
 		}
 	case !ok2:
 		{
-			err = errors.New("Пользователь " + id2 + " не найден\n")
+			err = models.NewError(models.ErrNotFound, "Пользователь "+id2+" не найден\n")
 		}
 	}
 
@@ -52,10 +72,15 @@ func (r *repository) MakeFriends(ctx context.Context, id, id2 string) (string, e
 		return "", err
 	}
 
+	// проверка блокировки в обе стороны
+	if contains(r.storage[id].Blocked, id2) || contains(r.storage[id2].Blocked, id) {
+		return "", fmt.Errorf("%w: %s, %s\n", models.ErrBlocked, id, id2)
+	}
+
 	// проверка, не являются ли друзьями
-	for _, v := range r.storage[id].Friends {
-		if v == r.storage[id2] {
-			err = errors.New("Пользователи " + id + " " + id2 + " уже друзья\n")
+	for _, v := range r.storage[id].FriendIDs {
+		if v == id2 {
+			err = models.NewError(models.ErrAlreadyFriends, "Пользователи "+id+" "+id2+" уже друзья\n")
 		}
 	}
 
@@ -63,60 +88,346 @@ func (r *repository) MakeFriends(ctx context.Context, id, id2 string) (string, e
 		return "", err
 	}
 
-	// добавление в друзья
-	r.storage[id].Friends = append(r.storage[id].Friends, r.storage[id2])
-	r.storage[id2].Friends = append(r.storage[id2].Friends, r.storage[id])
+	// добавление в друзья, у каждого своя копия метаданных
+	meta := models.NewFriendship(id)
+	for _, pair := range [][2]string{{id, id2}, {id2, id}} {
+		u := r.storage[pair[0]]
+		u.FriendIDs = append(u.FriendIDs, pair[1])
+		if u.Friendships == nil {
+			u.Friendships = make(map[string]*models.Friendship)
+		}
+		copied := *meta
+		u.Friendships[pair[1]] = &copied
+	}
+	r.emit(&models.Event{Type: models.EventFriendshipCreated, UserIDs: []string{id, id2}})
 	r.logger.Debug().Msgf("method MakeFriends finished + %v", r.storage[id])
 	return fmt.Sprint(r.storage[id].Name, " и ", r.storage[id2].Name, " теперь друзья"), nil
 }
+
+func (r *repository) Unfriend(ctx context.Context, id, friendID string) (string, error) {
+	u, ok := r.get(id)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
+		return "", err
+	}
+	friend, ok := r.get(friendID)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+friendID+" не найден\n")
+		return "", err
+	}
+	if !contains(u.FriendIDs, friendID) {
+		err := models.NewError(models.ErrNotFriends, "Пользователи "+id+" "+friendID+" не друзья\n")
+		return "", err
+	}
+	unfriend(u, friendID)
+	unfriend(friend, id)
+	r.emit(&models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{id, friendID}})
+	r.logger.Debug().Msg("method Unfriend finished")
+	return fmt.Sprint(u.Name, " и ", friend.Name, " больше не друзья"), nil
+}
+
+// get возвращает пользователя из хранилища, удаленные считаются отсутствующими
+func (r *repository) get(id string) (*models.UserModel, bool) {
+	u, ok := r.storage[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// view - копия пользователя без удаленных друзей
+func (r *repository) view(u *models.UserModel) *models.UserModel {
+	c := u.Copy()
+	c.HideFriends(func(id string) bool {
+		_, ok := r.get(id)
+		return !ok
+	})
+	return c
+}
+
+// Delete помечает пользователя удаленным, дружба сохраняется до окончательного удаления
 func (r *repository) Delete(ctx context.Context, id string) (string, error) {
 	//проверка на существование
-	_, ok := r.storage[id]
+	u, ok := r.get(id)
 	if !ok {
-		err := errors.New("Пользователь " + id + " не найден\n")
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
 		return "", err
 	}
 
-	//удаление из друзей
-	friendArr := make([]string, 0)
-	for _, friends := range r.storage[id].Friends {
-		friendArr = append(friendArr, friends.ID)
+	deletedAt := time.Now().UTC()
+	u.DeletedAt = &deletedAt
+	r.emit(&models.Event{Type: models.EventUserDeleted, UserIDs: []string{id}})
+	r.logger.Debug().Msg("method Delete finished")
+	return fmt.Sprint("пользователь ", u.Name, " удален"), nil
+}
+
+func (r *repository) Restore(ctx context.Context, id string, deletedAfter time.Time) error {
+	u, ok := r.storage[id]
+	if !ok || u.DeletedAt == nil {
+		err := models.NewError(models.ErrNotFound, "Удаленный пользователь "+id+" не найден\n")
+		return err
 	}
-	for _, some := range friendArr {
-		for i, v := range r.storage[some].Friends {
-			if r.storage[id] == v {
-				r.storage[some].Friends = append(r.storage[some].Friends[0:i], r.storage[some].Friends[i+1:]...)
+	if u.DeletedAt.Before(deletedAfter) {
+		return fmt.Errorf("%w: пользователь %s удален %s\n", models.ErrRetentionExpired, id, u.DeletedAt.Format(time.RFC3339))
+	}
+	u.DeletedAt = nil
+	r.logger.Debug().Msg("method Restore finished")
+	return nil
+}
+
+// Purge окончательно удаляет пользователей, удаленных раньше deletedBefore
+func (r *repository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	purged := 0
+	for id, u := range r.storage {
+		if u.DeletedAt == nil || !u.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		//удаление из друзей и из списков блокировки
+		for _, some := range u.FriendIDs {
+			if friend, ok := r.storage[some]; ok {
+				unfriend(friend, id)
+			}
+		}
+		for _, other := range r.storage {
+			for i, v := range other.Blocked {
+				if v == id {
+					other.Blocked = append(other.Blocked[:i], other.Blocked[i+1:]...)
+					break
+				}
 			}
 		}
+		delete(r.storage, id)
+		r.index.Remove(id)
+		purged++
 	}
-	name := r.storage[id].Name
+	r.logger.Debug().Msgf("method Purge finished, %d users purged", purged)
+	return purged, nil
+}
 
-	//удаление из хранилища
-	delete(r.storage, id)
-	r.logger.Debug().Msg("method Delete finished")
-	return fmt.Sprint("пользователь ", name, " удален"), nil
+// unfriend убирает друга из списка пользователя вместе с метаданными
+func unfriend(u *models.UserModel, friendID string) {
+	for i, v := range u.FriendIDs {
+		if v == friendID {
+			u.FriendIDs = append(u.FriendIDs[0:i], u.FriendIDs[i+1:]...)
+			break
+		}
+	}
+	delete(u.Friendships, friendID)
+}
+
+func (r *repository) Block(ctx context.Context, id, targetID string) error {
+	if id == targetID {
+		err := errors.New("Пользователь " + id + " не может заблокировать сам себя\n")
+		return err
+	}
+	u, ok := r.get(id)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
+		return err
+	}
+	target, ok := r.get(targetID)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+targetID+" не найден\n")
+		return err
+	}
+	if !contains(u.Blocked, targetID) {
+		u.Blocked = append(u.Blocked, targetID)
+	}
+	// блокировка разрывает дружбу
+	friends := contains(u.FriendIDs, targetID) || contains(target.FriendIDs, id)
+	unfriend(u, targetID)
+	unfriend(target, id)
+	if friends {
+		r.emit(&models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{id, targetID}})
+	}
+	r.logger.Debug().Msg("method Block finished")
+	return nil
+}
+
+func (r *repository) Unblock(ctx context.Context, id, targetID string) error {
+	u, ok := r.get(id)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
+		return err
+	}
+	for i, v := range u.Blocked {
+		if v == targetID {
+			u.Blocked = append(u.Blocked[:i], u.Blocked[i+1:]...)
+			r.logger.Debug().Msg("method Unblock finished")
+			return nil
+		}
+	}
+	err := models.NewError(models.ErrNotBlocked, "Пользователь "+targetID+" не заблокирован\n")
+	return err
+}
+
+func (r *repository) FindFriendsOfFriends(ctx context.Context, id string, limit int) ([]*models.Suggestion, error) {
+	friends, err := r.FindFriend(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	u := r.storage[id]
+	mutual := friendsOfFriends(u, friends)
+	candidates := make([]*models.UserModel, 0, len(mutual))
+	for candidateID := range mutual {
+		if c, ok := r.get(candidateID); ok {
+			candidates = append(candidates, r.view(c))
+		}
+	}
+	r.logger.Debug().Msg("method FindFriendsOfFriends finished")
+	return rankSuggestions(u, candidates, mutual, limit), nil
+}
+
+func (r *repository) FindUser(ctx context.Context, id string) (*models.UserModel, error) {
+	u, ok := r.get(id)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
+		return nil, err
+	}
+	r.logger.Debug().Msg("method FindUser finished")
+	return r.view(u), nil
+}
+
+func (r *repository) FindUsers(ctx context.Context, ids []string) ([]*models.UserModel, error) {
+	users := make([]*models.UserModel, 0, len(ids))
+	for _, id := range ids {
+		if u, ok := r.get(id); ok {
+			users = append(users, r.view(u))
+		}
+	}
+	r.logger.Debug().Msg("method FindUsers finished")
+	return users, nil
 }
 
 func (r *repository) FindFriend(ctx context.Context, id string) (ufriends []*models.UserModel, err error) {
 	//проверка на существование
-	_, ok := r.storage[id]
+	u, ok := r.get(id)
 	if !ok {
-		err := errors.New("Пользователь " + id + " не найден\n")
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
 		return nil, err
 	}
-	// передача списка друзей
-	ufriends = r.storage[id].Friends
+	// передача копий друзей
+	ufriends = make([]*models.UserModel, 0, len(u.FriendIDs))
+	for _, friendID := range u.FriendIDs {
+		if friend, ok := r.get(friendID); ok {
+			ufriends = append(ufriends, r.view(friend))
+		}
+	}
 	r.logger.Debug().Msg("method FindFriend finished")
 	return
 }
+
+func (r *repository) FindFriendsPage(ctx context.Context, id string, q models.FriendsQuery) (*models.FriendsPage, error) {
+	friends, err := r.FindFriend(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// ключ сортировки since - порядок добавления в друзья
+	keys := make(map[string]string, len(friends))
+	for i, f := range friends {
+		switch q.Sort {
+		case models.SortByName:
+			keys[f.ID] = f.Name
+		case models.SortByAge:
+			keys[f.ID] = f.Age
+		default:
+			keys[f.ID] = strconv.Itoa(i)
+		}
+	}
+	sort.SliceStable(friends, func(i, j int) bool {
+		return compareKeys(keys[friends[i].ID], friends[i].ID, keys[friends[j].ID], friends[j].ID) < 0
+	})
+
+	// пропускаем все, что не дальше курсора
+	if q.After != nil {
+		i := sort.Search(len(friends), func(i int) bool {
+			return compareKeys(keys[friends[i].ID], friends[i].ID, q.After.Key, q.After.ID) > 0
+		})
+		friends = friends[i:]
+	}
+
+	page := &models.FriendsPage{Friends: friends, Friendships: make(map[string]*models.Friendship)}
+	if len(friends) > q.Limit {
+		page.Friends = friends[:q.Limit]
+		last := page.Friends[q.Limit-1]
+		page.Next = &models.Cursor{Key: keys[last.ID], ID: last.ID}
+	}
+	for _, f := range page.Friends {
+		if meta, ok := r.storage[id].Friendships[f.ID]; ok {
+			copied := *meta
+			page.Friendships[f.ID] = &copied
+		}
+	}
+	r.logger.Debug().Msg("method FindFriendsPage finished")
+	return page, nil
+}
+
+func (r *repository) Search(ctx context.Context, q models.SearchQuery) (*models.SearchPage, error) {
+	results := make([]*models.SearchResult, 0)
+	for _, id := range r.index.Candidates(q.Mode, q.Text) {
+		u, ok := r.get(id)
+		if !ok {
+			continue
+		}
+		if score, ok := search.Score(q.Mode, q.Text, u.Name); ok {
+			results = append(results, &models.SearchResult{User: r.view(u), Score: score})
+		}
+	}
+	r.logger.Debug().Msg("method Search finished")
+	return search.Page(results, q)
+}
+
+func (r *repository) UpdateFriendship(ctx context.Context, id, friendID string, update models.FriendshipUpdate) (*models.Friendship, error) {
+	u, ok := r.get(id)
+	if !ok {
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
+		return nil, err
+	}
+	friend, ok := r.get(friendID)
+	if !ok || !contains(u.FriendIDs, friendID) {
+		err := models.NewError(models.ErrNotFriends, "Пользователи "+id+" "+friendID+" не друзья\n")
+		return nil, err
+	}
+
+	// дружбы, созданные без метаданных, получают их при первом изменении
+	created := models.NewFriendship(id)
+	for _, pair := range [][2]*models.UserModel{{u, friend}, {friend, u}} {
+		owner, other := pair[0], pair[1]
+		if owner.Friendships == nil {
+			owner.Friendships = make(map[string]*models.Friendship)
+		}
+		if owner.Friendships[other.ID] == nil {
+			copied := *created
+			owner.Friendships[other.ID] = &copied
+		}
+		owner.Friendships[other.ID].Apply(update)
+	}
+	r.logger.Debug().Msg("method UpdateFriendship finished")
+	meta := *u.Friendships[friendID]
+	return &meta, nil
+}
+
+func (r *repository) FindAll(ctx context.Context) ([]*models.UserModel, error) {
+	users := make([]*models.UserModel, 0, len(r.storage))
+	for _, u := range r.storage {
+		if u.DeletedAt == nil {
+			users = append(users, r.view(u))
+		}
+	}
+	r.logger.Debug().Msg("method FindAll finished")
+	return users, nil
+}
+
 func (r *repository) UpdateAge(ctx context.Context, id, age string) error {
 	//проверка на существование
-	_, ok := r.storage[id]
+	u, ok := r.get(id)
 	if !ok {
-		err := errors.New("Пользователь " + id + " не найден\n")
+		err := models.NewError(models.ErrNotFound, "Пользователь "+id+" не найден\n")
 		return err
 	}
-	r.storage[id].Age = age
+	u.Age = age
+	r.emit(&models.Event{Type: models.EventUserUpdated, UserIDs: []string{id}, User: u.Copy()})
 	r.logger.Debug().Msg("method UpdateAge finished")
 	return nil
 }
@@ -0,0 +1,333 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/internal/outbox"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testRepository(t *testing.T) *repository {
+	ctx := context.Background()
+	r := NewRepository(ctx, make(map[string]*models.UserModel), &logging.Logger{Logger: zerolog.Nop()})
+	users := []*models.UserModel{
+		{ID: "1", Name: "John", Age: "24"},
+		{ID: "2", Name: "nate", Age: "9"},
+		{ID: "3", Name: "Helen", Age: "18"},
+		{ID: "10", Name: "Anna", Age: "18"},
+		{ID: "11", Name: "bob", Age: "30"},
+	}
+	for _, u := range users {
+		r.Create(ctx, u)
+	}
+	for _, id := range []string{"2", "3", "10", "11"} {
+		if _, err := r.MakeFriends(ctx, "1", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRepository_FindFriendsPage(t *testing.T) {
+	testTable := []struct {
+		name     string
+		sort     string
+		limit    int
+		expected [][]string
+	}{
+		{"since", models.SortBySince, 3, [][]string{{"2", "3", "10"}, {"11"}}},
+		{"name", models.SortByName, 2, [][]string{{"10", "11"}, {"3", "2"}}},
+		{"age", models.SortByAge, 2, [][]string{{"2", "3"}, {"10", "11"}}},
+		{"single page", models.SortByAge, 4, [][]string{{"2", "3", "10", "11"}}},
+	}
+
+	r := testRepository(t)
+	for _, test := range testTable {
+		q := models.FriendsQuery{Limit: test.limit, Sort: test.sort}
+		pages := make([][]string, 0)
+		for {
+			page, err := r.FindFriendsPage(context.Background(), "1", q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, 0)
+			for _, f := range page.Friends {
+				ids = append(ids, f.ID)
+			}
+			pages = append(pages, ids)
+			if page.Next == nil {
+				break
+			}
+			// курсор должен пережить кодирование
+			q.After, err = models.DecodeCursor(page.Next.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(pages, test.expected) {
+			t.Errorf("%s: wrong pages: got %v want %v", test.name, pages, test.expected)
+		}
+	}
+}
+
+func TestRepository_UpdateFriendship(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	label := "school"
+	closeness := 0.8
+
+	if _, err := r.UpdateFriendship(ctx, "2", "3", models.FriendshipUpdate{Label: &label}); err == nil {
+		t.Errorf("expected error for users who are not friends")
+	}
+
+	meta, err := r.UpdateFriendship(ctx, "2", "1", models.FriendshipUpdate{Label: &label, Closeness: &closeness})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Initiator != "1" || meta.Label != label || *meta.Closeness != closeness || meta.CreatedAt.IsZero() {
+		t.Errorf("wrong friendship: got %+v", meta)
+	}
+
+	// метаданные видны с обеих сторон
+	page, err := r.FindFriendsPage(ctx, "1", models.FriendsQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := page.Friendships["2"]; got == nil || got.Label != label {
+		t.Errorf("friendship is not visible from the other side: got %+v", got)
+	}
+
+	// дружба без метаданных получает дату и инициатора при первом изменении
+	r.storage["2"].FriendIDs = append(r.storage["2"].FriendIDs, "3")
+	r.storage["3"].FriendIDs = append(r.storage["3"].FriendIDs, "2")
+	meta, err = r.UpdateFriendship(ctx, "3", "2", models.FriendshipUpdate{Label: &label})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Initiator != "3" || meta.CreatedAt.IsZero() || meta.Label != label {
+		t.Errorf("wrong legacy friendship: got %+v", meta)
+	}
+	if other := r.storage["2"].Friendships["3"]; other == nil || other.Initiator != "3" || other == r.storage["3"].Friendships["2"] {
+		t.Errorf("wrong legacy friendship on the other side: got %+v", other)
+	}
+}
+
+func TestRepository_Errors(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	testTable := []struct {
+		name     string
+		call     func() error
+		expected error
+		text     string
+	}{
+		{"unknown user", func() error {
+			_, err := r.FindUser(ctx, "42")
+			return err
+		}, models.ErrNotFound, "Пользователь 42 не найден\n"},
+		{"update unknown user", func() error {
+			return r.UpdateAge(ctx, "42", "30")
+		}, models.ErrNotFound, "Пользователь 42 не найден\n"},
+		{"existing id", func() error {
+			return r.Create(ctx, &models.UserModel{ID: "1", Name: "Copy", Age: "30"})
+		}, models.ErrUserExists, "пользователь с id 1 уже существует"},
+		{"befriend self", func() error {
+			_, err := r.MakeFriends(ctx, "1", "1")
+			return err
+		}, models.ErrSelfFriendship, "Пользователь 1 не может дружить сам с собой\n"},
+		{"befriend unknown users", func() error {
+			_, err := r.MakeFriends(ctx, "42", "43")
+			return err
+		}, models.ErrNotFound, "Пользователи 42 43 не найдены\n"},
+		{"already friends", func() error {
+			_, err := r.MakeFriends(ctx, "1", "2")
+			return err
+		}, models.ErrAlreadyFriends, "Пользователи 1 2 уже друзья\n"},
+		{"not friends", func() error {
+			_, err := r.Unfriend(ctx, "2", "3")
+			return err
+		}, models.ErrNotFriends, "Пользователи 2 3 не друзья\n"},
+	}
+	for _, test := range testTable {
+		err := test.call()
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: got error %v want %v", test.name, err, test.expected)
+			continue
+		}
+		if err.Error() != test.text {
+			t.Errorf("%s: got text %q want %q", test.name, err.Error(), test.text)
+		}
+	}
+}
+
+func TestRepository_Block(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	r.MakeFriends(ctx, "2", "3")
+
+	// 1 дружит с 2, 3, 10, 11; 2 дружит с 3, поэтому 2 рекомендуются 10 и 11
+	suggestions, _ := r.FindFriendsOfFriends(ctx, "2", 10)
+	if len(suggestions) != 2 || suggestions[0].User.ID != "10" || suggestions[0].Mutual != 1 {
+		t.Errorf("wrong suggestions before block: got %d", len(suggestions))
+	}
+
+	store := outbox.NewMemoryStore()
+	r.EnableOutbox(store)
+	if err := r.Block(ctx, "11", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Block(ctx, "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if contains(r.storage["1"].FriendIDs, "2") || contains(r.storage["2"].FriendIDs, "1") {
+		t.Errorf("friendship was not removed by block")
+	}
+	// событие только о разорванной дружбе, 11 и 2 друзьями не были
+	records, _ := store.Pending(ctx, "test", time.Time{}, 10)
+	expected := models.Event{Type: models.EventFriendshipDeleted, UserIDs: []string{"1", "2"}}
+	if len(records) != 1 || records[0].Event.Type != expected.Type || !reflect.DeepEqual(records[0].Event.UserIDs, expected.UserIDs) {
+		t.Errorf("wrong events after block: got %+v want %+v", records, expected)
+	}
+	if _, err := r.MakeFriends(ctx, "2", "1"); !errors.Is(err, models.ErrBlocked) {
+		t.Errorf("expected ErrBlocked, got %v", err)
+	}
+
+	// у 2 остался друг 3, его друг 1 заблокировал 2
+	suggestions, _ = r.FindFriendsOfFriends(ctx, "2", 10)
+	if len(suggestions) != 0 {
+		t.Errorf("blocked users in suggestions: got %+v", suggestions[0].User)
+	}
+
+	if err := r.Unblock(ctx, "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unblock(ctx, "1", "2"); !errors.Is(err, models.ErrNotBlocked) {
+		t.Errorf("expected ErrNotBlocked, got %v", err)
+	}
+	if _, err := r.MakeFriends(ctx, "2", "1"); err != nil {
+		t.Errorf("expected friendship after unblock, got %v", err)
+	}
+}
+
+func TestRepository_SoftDelete(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	r.Block(ctx, "3", "11")
+
+	if _, err := r.Delete(ctx, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FindUser(ctx, "2"); err == nil {
+		t.Errorf("deleted user is visible")
+	}
+	u, _ := r.FindUser(ctx, "1")
+	if contains(u.FriendIDs, "2") {
+		t.Errorf("deleted user in friends: got %v", u.FriendIDs)
+	}
+	if _, err := r.MakeFriends(ctx, "2", "3"); err == nil {
+		t.Errorf("expected error for deleted user")
+	}
+
+	// в пределах срока хранения дружба возвращается вместе с пользователем
+	if err := r.Restore(ctx, "2", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = r.FindUser(ctx, "1")
+	if !contains(u.FriendIDs, "2") || u.Friendships["2"] == nil {
+		t.Errorf("friendship was not restored: got %v", u.FriendIDs)
+	}
+
+	r.Delete(ctx, "2")
+	if err := r.Restore(ctx, "2", time.Now().Add(time.Hour)); !errors.Is(err, models.ErrRetentionExpired) {
+		t.Errorf("expected ErrRetentionExpired, got %v", err)
+	}
+
+	r.Delete(ctx, "11")
+	n, err := r.Purge(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("wrong purge result: got %d, %v", n, err)
+	}
+	if _, ok := r.storage["2"]; ok {
+		t.Errorf("purged user is still stored")
+	}
+	if contains(r.storage["1"].FriendIDs, "2") || r.storage["1"].Friendships["2"] != nil {
+		t.Errorf("purged user in friends: got %v", r.storage["1"].FriendIDs)
+	}
+	if contains(r.storage["3"].Blocked, "11") {
+		t.Errorf("purged user in blocked: got %v", r.storage["3"].Blocked)
+	}
+	if err := r.Restore(ctx, "2", time.Time{}); err == nil {
+		t.Errorf("expected error for purged user")
+	}
+}
+
+func TestRepository_Search(t *testing.T) {
+	ctx := context.Background()
+	r := testRepository(t)
+	r.Create(ctx, &models.UserModel{ID: "12", Name: "Helena Johnson", Age: "40"})
+	r.Create(ctx, &models.UserModel{ID: "13", Name: "Jonathan", Age: "22"})
+
+	testTable := []struct {
+		name     string
+		mode     string
+		text     string
+		expected []string
+	}{
+		{name: "prefix", mode: models.SearchPrefix, text: "HEL", expected: []string{"3", "12"}},
+		{name: "prefix of second word", mode: models.SearchPrefix, text: "john", expected: []string{"1", "12"}},
+		{name: "contains", mode: models.SearchContains, text: "na", expected: []string{"2", "10", "13", "12"}},
+		{name: "fuzzy", mode: models.SearchFuzzy, text: "jhon", expected: []string{"1", "12"}},
+		{name: "nothing", mode: models.SearchPrefix, text: "zed", expected: []string{}},
+	}
+	for _, tc := range testTable {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := r.Search(ctx, models.SearchQuery{Text: tc.text, Mode: tc.mode, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, 0, len(page.Results))
+			for _, res := range page.Results {
+				ids = append(ids, res.User.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("wrong results: got %v, expected %v", ids, tc.expected)
+			}
+		})
+	}
+
+	// постранично отдаются те же результаты, что и одной страницей
+	all, _ := r.Search(ctx, models.SearchQuery{Text: "n", Mode: models.SearchContains, Limit: 10})
+	var got []string
+	q := models.SearchQuery{Text: "n", Mode: models.SearchContains, Limit: 2}
+	for {
+		page, err := r.Search(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range page.Results {
+			got = append(got, res.User.ID)
+		}
+		if page.Next == nil {
+			break
+		}
+		q.After = page.Next
+	}
+	var expected []string
+	for _, res := range all.Results {
+		expected = append(expected, res.User.ID)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong pages: got %v, expected %v", got, expected)
+	}
+
+	r.Delete(ctx, "3")
+	page, _ := r.Search(ctx, models.SearchQuery{Text: "helen", Mode: models.SearchPrefix, Limit: 10})
+	if len(page.Results) != 1 || page.Results[0].User.ID != "12" {
+		t.Errorf("deleted user found")
+	}
+}
@@ -0,0 +1,126 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// indexOptionsConflict - код ошибки создания индекса, который уже есть с другими параметрами
+const indexOptionsConflict = 85
+
+type mongoStore struct {
+	hooks      *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+// NewMongoStore хранит вебхуки в коллекции hooks, доставки - в deliveries. Законченные
+// доставки старше retention удаляет TTL-индекс, после изменения срока он обновляется
+func NewMongoStore(ctx context.Context, database *mongo.Database, hooks, deliveries string, retention time.Duration) (*mongoStore, error) {
+	s := &mongoStore{hooks: database.Collection(hooks), deliveries: database.Collection(deliveries)}
+	ttl := bson.D{{Key: "finished_at", Value: 1}}
+	seconds := int32(retention.Seconds())
+	_, err := s.deliveries.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    ttl,
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexOptionsConflict {
+		err = database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: deliveries},
+			{Key: "index", Value: bson.D{{Key: "keyPattern", Value: ttl}, {Key: "expireAfterSeconds", Value: seconds}}},
+		}).Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't create webhook deliveries ttl index: %w", err)
+	}
+	_, err = s.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create webhook deliveries indexes: %w", err)
+	}
+	return s, nil
+}
+
+// id вебхуков и доставок - ObjectID, поэтому экземпляры с общей базой не выдают одинаковых
+func (s *mongoStore) CreateHook(ctx context.Context, w *models.Webhook) error {
+	w.ID = primitive.NewObjectID().Hex()
+	if _, err := s.hooks.InsertOne(ctx, w); err != nil {
+		return fmt.Errorf("can't insert webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *mongoStore) Hooks(ctx context.Context) ([]*models.Webhook, error) {
+	cursor, err := s.hooks.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("can't find webhooks: %w", err)
+	}
+	hooks := make([]*models.Webhook, 0)
+	if err = cursor.All(ctx, &hooks); err != nil {
+		return nil, fmt.Errorf("can't decode webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+func (s *mongoStore) Hook(ctx context.Context, id string) (*models.Webhook, error) {
+	w := &models.Webhook{}
+	err := s.hooks.FindOne(ctx, bson.M{"_id": id}).Decode(w)
+	if err == mongo.ErrNoDocuments {
+		return nil, hookNotFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't find webhook: %w", err)
+	}
+	return w, nil
+}
+
+func (s *mongoStore) DeleteHook(ctx context.Context, id string) error {
+	res, err := s.hooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("can't delete webhook: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return hookNotFound(id)
+	}
+	return nil
+}
+
+func (s *mongoStore) SaveDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	if d.ID == "" {
+		d.ID = primitive.NewObjectID().Hex()
+	}
+	_, err := s.deliveries.ReplaceOne(ctx, bson.M{"_id": d.ID}, d, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("can't save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *mongoStore) find(ctx context.Context, filter bson.M) ([]*models.WebhookDelivery, error) {
+	cursor, err := s.deliveries.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("can't find webhook deliveries: %w", err)
+	}
+	deliveries := make([]*models.WebhookDelivery, 0)
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("can't decode webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *mongoStore) Deliveries(ctx context.Context, webhookID string) ([]*models.WebhookDelivery, error) {
+	return s.find(ctx, bson.M{"webhook_id": webhookID})
+}
+
+func (s *mongoStore) DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error) {
+	return s.find(ctx, bson.M{"status": models.DeliveryDead})
+}
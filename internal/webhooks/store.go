@@ -0,0 +1,145 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/ast3am/educationProject/internal/models"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store - подписки и журнал доставок. Законченные доставки старше срока хранения удаляются
+type Store interface {
+	// CreateHook сохраняет вебхук и заполняет его ID
+	CreateHook(ctx context.Context, w *models.Webhook) error
+	// Hooks - все вебхуки вместе с секретами в порядке создания
+	Hooks(ctx context.Context) ([]*models.Webhook, error)
+	Hook(ctx context.Context, id string) (*models.Webhook, error)
+	DeleteHook(ctx context.Context, id string) error
+	// SaveDelivery сохраняет доставку, новой доставке заполняет ID
+	SaveDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	Deliveries(ctx context.Context, webhookID string) ([]*models.WebhookDelivery, error)
+	DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error)
+}
+
+func hookNotFound(id string) error {
+	return models.NewError(models.ErrWebhookNotFound, "webhook "+id+" not found")
+}
+
+// MemoryStore хранит подписки и журнал в памяти процесса, для тестов
+type MemoryStore struct {
+	retention time.Duration
+
+	mu         sync.Mutex
+	lastID     int
+	hooks      map[string]*models.Webhook
+	deliveries map[string]*models.WebhookDelivery
+}
+
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		retention:  retention,
+		hooks:      make(map[string]*models.Webhook),
+		deliveries: make(map[string]*models.WebhookDelivery),
+	}
+}
+
+func (s *MemoryStore) makeID() string {
+	s.lastID++
+	return strconv.Itoa(s.lastID)
+}
+
+func (s *MemoryStore) CreateHook(ctx context.Context, w *models.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.ID = s.makeID()
+	copied := *w
+	s.hooks[w.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStore) Hooks(ctx context.Context) ([]*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hooks := make([]*models.Webhook, 0, len(s.hooks))
+	for _, w := range s.hooks {
+		copied := *w
+		hooks = append(hooks, &copied)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		a, _ := strconv.Atoi(hooks[i].ID)
+		b, _ := strconv.Atoi(hooks[j].ID)
+		return a < b
+	})
+	return hooks, nil
+}
+
+func (s *MemoryStore) Hook(ctx context.Context, id string) (*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.hooks[id]
+	if !ok {
+		return nil, hookNotFound(id)
+	}
+	copied := *w
+	return &copied, nil
+}
+
+func (s *MemoryStore) DeleteHook(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hooks[id]; !ok {
+		return hookNotFound(id)
+	}
+	delete(s.hooks, id)
+	return nil
+}
+
+func (s *MemoryStore) SaveDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.ID == "" {
+		d.ID = s.makeID()
+	}
+	s.deliveries[d.ID] = d.Copy()
+	s.expire()
+	return nil
+}
+
+// expire удаляет законченные доставки старше срока хранения, как TTL-индекс в MongoDB
+func (s *MemoryStore) expire() {
+	deadline := time.Now().Add(-s.retention)
+	for id, d := range s.deliveries {
+		if d.FinishedAt != nil && d.FinishedAt.Before(deadline) {
+			delete(s.deliveries, id)
+		}
+	}
+}
+
+// find - доставки, подходящие под match, в порядке создания
+func (s *MemoryStore) find(match func(d *models.WebhookDelivery) bool) []*models.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	res := make([]*models.WebhookDelivery, 0)
+	for _, d := range s.deliveries {
+		if match(d) {
+			res = append(res, d.Copy())
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, _ := strconv.Atoi(res[i].ID)
+		b, _ := strconv.Atoi(res[j].ID)
+		return a < b
+	})
+	return res
+}
+
+func (s *MemoryStore) Deliveries(ctx context.Context, webhookID string) ([]*models.WebhookDelivery, error) {
+	return s.find(func(d *models.WebhookDelivery) bool { return d.WebhookID == webhookID }), nil
+}
+
+func (s *MemoryStore) DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error) {
+	return s.find(func(d *models.WebhookDelivery) bool { return d.Status == models.DeliveryDead }), nil
+}
@@ -0,0 +1,232 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// заголовки запроса доставки
+const (
+	SignatureHeader = "X-Signature-256"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+type Config struct {
+	MaxAttempts int
+	// Backoff - пауза перед вторым запросом, дальше удваивается
+	Backoff time.Duration
+	Timeout time.Duration
+	// Workers - сколько вебхуков получают одно событие одновременно
+	Workers int
+}
+
+// Dispatcher доставляет события подписчикам, подписки и журнал доставок хранятся в store
+type Dispatcher struct {
+	cfg    Config
+	store  Store
+	client *http.Client
+	logger *logging.Logger
+}
+
+func NewDispatcher(cfg Config, store Store, logger *logging.Logger) *Dispatcher {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	return &Dispatcher{
+		cfg:    cfg,
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+	}
+}
+
+// Sign - подпись тела запроса, получатель сравнивает ее с заголовком X-Signature-256
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) Create(ctx context.Context, w *models.Webhook) error {
+	w.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	return d.store.CreateHook(ctx, w)
+}
+
+// List возвращает вебхуки без секретов
+func (d *Dispatcher) List(ctx context.Context) ([]*models.Webhook, error) {
+	hooks, err := d.store.Hooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range hooks {
+		w.Secret = ""
+	}
+	return hooks, nil
+}
+
+func (d *Dispatcher) Delete(ctx context.Context, id string) error {
+	return d.store.DeleteHook(ctx, id)
+}
+
+// Deliveries - журнал доставок вебхука, включая уже удаленный
+func (d *Dispatcher) Deliveries(ctx context.Context, webhookID string) ([]*models.WebhookDelivery, error) {
+	deliveries, err := d.store.Deliveries(ctx, webhookID)
+	if err != nil || len(deliveries) > 0 {
+		return deliveries, err
+	}
+	if _, err = d.store.Hook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DeadLetters - доставки, для которых закончились попытки
+func (d *Dispatcher) DeadLetters(ctx context.Context) ([]*models.WebhookDelivery, error) {
+	return d.store.DeadLetters(ctx)
+}
+
+// Handle доставляет событие всем подписанным вебхукам и возвращается, когда каждая
+// доставка удалась или исчерпала попытки. Relay отмечает событие в outbox только после
+// этого, поэтому после падения процесса событие придет повторно с тем же Key.
+// Ошибка - отмена ctx или сбой хранилища до первой попытки, событие тогда остается в outbox
+func (d *Dispatcher) Handle(ctx context.Context, e *models.Event) error {
+	deliveries, err := d.dispatch(ctx, e)
+	if err != nil {
+		return err
+	}
+	slots := make(chan struct{}, d.cfg.Workers)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(delivery *models.WebhookDelivery) {
+			defer wg.Done()
+			d.deliver(ctx, delivery)
+			<-slots
+		}(delivery)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// dispatch заводит доставку события каждому подписанному вебхуку
+func (d *Dispatcher) dispatch(ctx context.Context, e *models.Event) ([]*models.WebhookDelivery, error) {
+	hooks, err := d.store.Hooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for _, w := range hooks {
+		if !w.Wants(e.Type) {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			WebhookID: w.ID,
+			Event:     e,
+			Status:    models.DeliveryPending,
+			Attempts:  []models.WebhookAttempt{},
+		}
+		if err = d.store.SaveDelivery(ctx, delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// deliver повторяет запросы с растущей паузой, пока доставка не удастся,
+// не закончатся попытки или не будет отменен ctx
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	for d.attempt(ctx, delivery) {
+		timer := time.NewTimer(time.Until(*delivery.NextAttempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt делает одну попытку и возвращает, нужна ли следующая. Вебхук читается
+// перед каждой попыткой, удаленному вебхуку доставка прекращается
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) bool {
+	attempt := models.WebhookAttempt{Time: time.Now().UTC()}
+	w, err := d.store.Hook(ctx, delivery.WebhookID)
+	deleted := errors.Is(err, models.ErrWebhookNotFound)
+	switch {
+	case deleted:
+		attempt.Error = "webhook deleted"
+	case err != nil:
+		attempt.Error = err.Error()
+	default:
+		attempt.StatusCode, attempt.Error = d.post(ctx, w, delivery.ID, delivery.Event)
+	}
+	attempt.DurationMS = time.Since(attempt.Time).Milliseconds()
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.NextAttempt = nil
+	retry := false
+	switch {
+	case attempt.Error == "":
+		delivery.Status = models.DeliveryDelivered
+	case ctx.Err() != nil:
+		// доставка остается pending, событие придет снова из outbox
+	case deleted || len(delivery.Attempts) >= d.cfg.MaxAttempts:
+		delivery.Status = models.DeliveryDead
+		d.logger.Warn().Str("webhook", delivery.WebhookID).Str("delivery", delivery.ID).Str("error", attempt.Error).Msg("webhook delivery failed")
+	default:
+		next := time.Now().UTC().Add(d.cfg.Backoff << (len(delivery.Attempts) - 1))
+		delivery.NextAttempt = &next
+		retry = true
+	}
+	if delivery.Status != models.DeliveryPending {
+		finished := time.Now().UTC()
+		delivery.FinishedAt = &finished
+	}
+	// журнал пишется и после отмены ctx, его сбой на доставку не влияет и только логируется
+	if err = d.store.SaveDelivery(context.Background(), delivery); err != nil {
+		d.logger.Err(err).Str("delivery", delivery.ID).Msg("can't save webhook delivery")
+	}
+	return retry
+}
+
+// post возвращает код ответа и текст ошибки, успехом считается любой 2xx
+func (d *Dispatcher) post(ctx context.Context, w *models.Webhook, deliveryID string, e *models.Event) (int, string) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return 0, err.Error()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, e.Type)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Sprintf("unexpected status %d", res.StatusCode)
+	}
+	return res.StatusCode, ""
+}
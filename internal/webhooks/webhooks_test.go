@@ -0,0 +1,240 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/internal/models"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver отвечает кодами из statuses по очереди, после них - 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.mu.Lock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	rc.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// byStatus - доставки вебхука в нужном состоянии
+func byStatus(t *testing.T, d *Dispatcher, webhookID, status string) []*models.WebhookDelivery {
+	deliveries, err := d.Deliveries(context.Background(), webhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]*models.WebhookDelivery, 0)
+	for _, delivery := range deliveries {
+		if delivery.Status == status {
+			res = append(res, delivery)
+		}
+	}
+	return res
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	d := NewDispatcher(Config{MaxAttempts: 3, Backoff: time.Millisecond, Timeout: time.Second, Workers: 2},
+		NewMemoryStore(time.Hour), &logging.Logger{Logger: zerolog.Nop()})
+
+	flaky := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	brokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer brokenServer.Close()
+
+	signed := &models.Webhook{URL: flakyServer.URL, Events: []string{models.EventFriendshipCreated}, Secret: "s3cret"}
+	dead := &models.Webhook{URL: brokenServer.URL}
+	for _, w := range []*models.Webhook{signed, dead} {
+		if err := d.Create(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Handle возвращается, когда все доставки события закончены
+	for _, e := range []*models.Event{
+		{Type: models.EventUserCreated, UserIDs: []string{"1"}},
+		{Type: models.EventFriendshipCreated, UserIDs: []string{"1", "2"}},
+	} {
+		if err := d.Handle(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	delivered := byStatus(t, d, signed.ID, models.DeliveryDelivered)
+	if len(delivered) != 1 {
+		t.Fatalf("wrong delivered: got %+v", delivered)
+	}
+	if len(delivered[0].Attempts) != 3 || delivered[0].Attempts[0].StatusCode != http.StatusInternalServerError {
+		t.Errorf("wrong attempts: got %+v", delivered[0].Attempts)
+	}
+	if delivered[0].Event.Type != models.EventFriendshipCreated {
+		t.Errorf("webhook got unsubscribed event %s", delivered[0].Event.Type)
+	}
+	flaky.mu.Lock()
+	last := flaky.requests[len(flaky.requests)-1]
+	if got, want := last.Header.Get(SignatureHeader), Sign("s3cret", flaky.bodies[len(flaky.bodies)-1]); got != want {
+		t.Errorf("wrong signature: got %s want %s", got, want)
+	}
+	if last.Header.Get(EventHeader) != models.EventFriendshipCreated || last.Header.Get(DeliveryHeader) != delivered[0].ID {
+		t.Errorf("wrong headers: got %v", last.Header)
+	}
+	flaky.mu.Unlock()
+
+	// вебхук без списка событий получает оба события
+	if n := len(byStatus(t, d, dead.ID, models.DeliveryDead)); n != 2 {
+		t.Errorf("wrong dead deliveries: got %d want 2", n)
+	}
+	letters, _ := d.DeadLetters(ctx)
+	if len(letters) != 2 || letters[0].WebhookID != dead.ID || len(letters[0].Attempts) != 3 {
+		t.Errorf("wrong dead letters: got %+v", letters)
+	}
+	hooks, _ := d.List(ctx)
+	if len(hooks) != 2 || hooks[0].Secret != "" {
+		t.Errorf("wrong webhooks: got %+v", hooks)
+	}
+}
+
+// отмена ctx прерывает повторы, событие остается недоставленным
+func TestDispatcher_Canceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	d := NewDispatcher(Config{MaxAttempts: 5, Backoff: time.Hour, Timeout: time.Second, Workers: 1},
+		NewMemoryStore(time.Hour), &logging.Logger{Logger: zerolog.Nop()})
+	hook := &models.Webhook{URL: server.URL}
+	if err := d.Create(context.Background(), hook); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Handle(ctx, &models.Event{Type: models.EventUserCreated}); err != context.DeadlineExceeded {
+		t.Errorf("got error %v want %v", err, context.DeadlineExceeded)
+	}
+	pending := byStatus(t, d, hook.ID, models.DeliveryPending)
+	if len(pending) != 1 || len(pending[0].Attempts) != 1 {
+		t.Errorf("wrong pending deliveries: got %+v", pending)
+	}
+}
+
+// законченные доставки старше срока хранения удаляются, незаконченные остаются
+func TestMemoryStore_Retention(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(time.Hour)
+	hook := &models.Webhook{URL: "https://partner.example/hook"}
+	if err := s.CreateHook(ctx, hook); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+	for _, delivery := range []*models.WebhookDelivery{
+		{WebhookID: hook.ID, Status: models.DeliveryDead, FinishedAt: &old},
+		{WebhookID: hook.ID, Status: models.DeliveryDead, FinishedAt: &recent},
+		{WebhookID: hook.ID, Status: models.DeliveryPending},
+	} {
+		if err := s.SaveDelivery(ctx, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, _ := s.Deliveries(ctx, hook.ID)
+	if len(deliveries) != 2 || deliveries[0].FinishedAt == nil || deliveries[1].Status != models.DeliveryPending {
+		t.Errorf("wrong deliveries: got %+v", deliveries)
+	}
+	if letters, _ := s.DeadLetters(ctx); len(letters) != 1 {
+		t.Errorf("wrong dead letters: got %+v", letters)
+	}
+}
+
+// подписки и журнал переживают перезапуск: новый Dispatcher на той же базе их видит
+func TestMongoStore(t *testing.T) {
+	ctx := context.Background()
+	database := testDatabase(t)
+	log := &logging.Logger{Logger: zerolog.Nop()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	store, err := NewMongoStore(ctx, database, "webhooks", "webhook_deliveries", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDispatcher(Config{MaxAttempts: 1, Timeout: time.Second}, store, log)
+	hook := &models.Webhook{URL: server.URL, Secret: "s3cret"}
+	if err = d.Create(ctx, hook); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Handle(ctx, &models.Event{Type: models.EventUserCreated, UserIDs: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// срок хранения изменился - TTL-индекс обновляется, а не падает
+	store, err = NewMongoStore(ctx, database, "webhooks", "webhook_deliveries", 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = NewDispatcher(Config{MaxAttempts: 1, Timeout: time.Second}, store, log)
+	hooks, err := d.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != hook.ID || hooks[0].Secret != "" {
+		t.Errorf("wrong webhooks after restart: got %+v", hooks)
+	}
+	if w, _ := store.Hook(ctx, hook.ID); w == nil || w.Secret != "s3cret" {
+		t.Errorf("secret is not stored: got %+v", w)
+	}
+	delivered := byStatus(t, d, hook.ID, models.DeliveryDelivered)
+	if len(delivered) != 1 || delivered[0].FinishedAt == nil || delivered[0].Event.Type != models.EventUserCreated {
+		t.Errorf("wrong deliveries after restart: got %+v", delivered)
+	}
+
+	if err = d.Delete(ctx, hook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Delete(ctx, hook.ID); !errors.Is(err, models.ErrWebhookNotFound) {
+		t.Errorf("got error %v want %v", err, models.ErrWebhookNotFound)
+	}
+}
+
+func testDatabase(t *testing.T) *mongo.Database {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database("test_webhooks")
+	database.Drop(ctx)
+	t.Cleanup(func() {
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return database
+}
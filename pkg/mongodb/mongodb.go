@@ -2,24 +2,177 @@ package mongodb
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"github.com/ast3am/educationProject/pkg/logging"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"io/ioutil"
+	"net"
+	"time"
 )
 
-func NewClient(ctx context.Context, host, port, database string) (db *mongo.Database, err error) {
-	mongoDBURL := fmt.Sprintf("mongodb://%s:%s", host, port)
-	clientOptions := options.Client().ApplyURI(mongoDBURL)
-	client, err := mongo.Connect(ctx, clientOptions)
+// Options - параметры подключения. URI, если задан, берется за основу, а заполненные
+// поля его переопределяют. Без URI адрес собирается из Host и Port.
+// Нулевые значения оставляют настройки драйвера по умолчанию
+type Options struct {
+	URI      string
+	Host     string
+	Port     string
+	Database string
+
+	Username string
+	Password string
+	// база, в которой заведен пользователь, по умолчанию admin
+	AuthSource string
+	ReplicaSet string
+
+	TLS bool
+	// CA сервера в PEM, пусто - системные сертификаты
+	TLSCAFile string
+
+	MinPoolSize            uint64
+	MaxPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+	AppName                string
+
+	// сколько раз проверять соединение при старте и пауза перед первым повтором,
+	// которая удваивается с каждой попыткой
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+}
+
+func (o Options) uri() string {
+	if o.URI != "" {
+		return o.URI
+	}
+	return "mongodb://" + net.JoinHostPort(o.Host, o.Port)
+}
+
+// database - имя базы из Database или из пути URI
+func (o Options) database() (string, error) {
+	if o.Database != "" {
+		return o.Database, nil
+	}
+	cs, err := connstring.ParseAndValidate(o.uri())
+	if err != nil {
+		return "", fmt.Errorf("can't parse mongodb uri: %w", err)
+	}
+	if cs.Database == "" {
+		return "", errors.New("mongodb database is not set")
+	}
+	return cs.Database, nil
+}
+
+// ClientOptions собирает настройки драйвера
+func (o Options) ClientOptions() (*options.ClientOptions, error) {
+	cs, err := connstring.ParseAndValidate(o.uri())
+	if err != nil {
+		return nil, fmt.Errorf("can't parse mongodb uri: %w", err)
+	}
+	opts := options.Client().ApplyURI(o.uri())
+	if o.Username != "" {
+		// без пользователя в URI драйвер не сохраняет authSource и механизм из него
+		cred := options.Credential{
+			AuthMechanism: cs.AuthMechanism,
+			AuthSource:    cs.AuthSource,
+			Username:      o.Username,
+			Password:      o.Password,
+		}
+		if o.AuthSource != "" {
+			cred.AuthSource = o.AuthSource
+		}
+		opts.SetAuth(cred)
+	}
+	if o.ReplicaSet != "" {
+		opts.SetReplicaSet(o.ReplicaSet)
+	}
+	if o.TLS || o.TLSCAFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if o.TLSCAFile != "" {
+			content, err := ioutil.ReadFile(o.TLSCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("can't read mongodb CA file: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(content) {
+				return nil, errors.New("no certificates in mongodb CA file " + o.TLSCAFile)
+			}
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if o.MinPoolSize > 0 {
+		opts.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	}
+	if o.SocketTimeout > 0 {
+		opts.SetSocketTimeout(o.SocketTimeout)
+	}
+	if o.AppName != "" {
+		opts.SetAppName(o.AppName)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mongodb options: %w", err)
+	}
+	return opts, nil
+}
+
+// NewClient подключается к MongoDB и проверяет соединение, пока сервер не ответит
+// или не закончатся попытки
+func NewClient(ctx context.Context, o Options, logger *logging.Logger) (*mongo.Database, error) {
+	database, err := o.database()
 	if err != nil {
-		err = errors.New("can't connect")
 		return nil, err
 	}
-	err = client.Ping(ctx, nil)
+	clientOptions, err := o.ClientOptions()
 	if err != nil {
-		err = errors.New("ping error")
 		return nil, err
 	}
-	return client.Database(database), nil
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to mongodb: %w", err)
+	}
+
+	attempts := o.ConnectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := o.ConnectBackoff
+	attempt := 1
+	for ; ; attempt++ {
+		if err = client.Ping(ctx, readpref.Primary()); err == nil {
+			return client.Database(database), nil
+		}
+		if attempt == attempts {
+			break
+		}
+		logger.Warn().Err(err).Int("attempt", attempt).Dur("retry_in", backoff).Msg("mongodb is not available")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+	client.Disconnect(context.Background())
+	return nil, fmt.Errorf("can't ping mongodb after %d attempts: %w", attempt, err)
 }
@@ -0,0 +1,141 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"github.com/ast3am/educationProject/pkg/logging"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptions_ClientOptions(t *testing.T) {
+	opts, err := Options{
+		URI:                    "mongodb://db1:27017,db2:27017/app?authSource=users&replicaSet=rs0",
+		Username:               "svc",
+		Password:               "secret",
+		TLS:                    true,
+		MinPoolSize:            2,
+		MaxPoolSize:            20,
+		ConnectTimeout:         3 * time.Second,
+		ServerSelectionTimeout: 4 * time.Second,
+		SocketTimeout:          5 * time.Second,
+		AppName:                "educationProject",
+	}.ClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"db1:27017", "db2:27017"}; !reflect.DeepEqual(opts.Hosts, expected) {
+		t.Errorf("wrong hosts: got %v want %v", opts.Hosts, expected)
+	}
+	// authSource из URI остается, если не задан отдельно
+	if opts.Auth.Username != "svc" || opts.Auth.Password != "secret" || opts.Auth.AuthSource != "users" {
+		t.Errorf("wrong credentials: got %+v", *opts.Auth)
+	}
+	if *opts.ReplicaSet != "rs0" || *opts.AppName != "educationProject" {
+		t.Errorf("wrong replica set or app name: got %s, %s", *opts.ReplicaSet, *opts.AppName)
+	}
+	if opts.TLSConfig == nil {
+		t.Errorf("tls is not enabled")
+	}
+	if *opts.MinPoolSize != 2 || *opts.MaxPoolSize != 20 {
+		t.Errorf("wrong pool size: got %d-%d want 2-20", *opts.MinPoolSize, *opts.MaxPoolSize)
+	}
+	if *opts.ConnectTimeout != 3*time.Second || *opts.ServerSelectionTimeout != 4*time.Second ||
+		*opts.SocketTimeout != 5*time.Second {
+		t.Errorf("wrong timeouts: got %v, %v, %v", *opts.ConnectTimeout, *opts.ServerSelectionTimeout, *opts.SocketTimeout)
+	}
+
+	opts, err = Options{Host: "localhost", Port: "27018", ReplicaSet: "rs1", AuthSource: "admin", Username: "u"}.ClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"localhost:27018"}; !reflect.DeepEqual(opts.Hosts, expected) {
+		t.Errorf("wrong hosts: got %v want %v", opts.Hosts, expected)
+	}
+	if *opts.ReplicaSet != "rs1" || opts.Auth.AuthSource != "admin" {
+		t.Errorf("wrong replica set or auth source: got %s, %s", *opts.ReplicaSet, opts.Auth.AuthSource)
+	}
+	if opts.TLSConfig != nil || opts.MaxPoolSize != nil {
+		t.Errorf("unexpected defaults: tls %v, max pool %v", opts.TLSConfig, opts.MaxPoolSize)
+	}
+
+	testTable := []struct {
+		name     string
+		options  Options
+		expected string
+	}{
+		{"pool", Options{URI: "mongodb://localhost", MinPoolSize: 10, MaxPoolSize: 5}, "invalid mongodb options: "},
+		{"ca file", Options{URI: "mongodb://localhost", TLSCAFile: "/nonexistent/ca.pem"}, "can't read mongodb CA file: "},
+	}
+	for _, test := range testTable {
+		_, err = test.options.ClientOptions()
+		if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("%s: got error %v want %q...", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestOptions_Database(t *testing.T) {
+	testTable := []struct {
+		name     string
+		options  Options
+		expected string
+		err      string
+	}{
+		{"explicit", Options{URI: "mongodb://localhost/app", Database: "other"}, "other", ""},
+		{"from uri", Options{URI: "mongodb://localhost/app"}, "app", ""},
+		{"not set", Options{URI: "mongodb://localhost"}, "", "mongodb database is not set"},
+	}
+	for _, test := range testTable {
+		db, err := test.options.database()
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if db != test.expected || got != test.err {
+			t.Errorf("%s: got %q, %q want %q, %q", test.name, db, got, test.expected, test.err)
+		}
+	}
+}
+
+func TestNewClient_Retry(t *testing.T) {
+	o := Options{
+		// на этом порту никто не слушает
+		URI:                    "mongodb://127.0.0.1:1/?connect=direct",
+		Database:               "test",
+		ServerSelectionTimeout: 20 * time.Millisecond,
+		ConnectAttempts:        3,
+		ConnectBackoff:         10 * time.Millisecond,
+	}
+	start := time.Now()
+	_, err := NewClient(context.Background(), o, logging.GetLogger())
+	if err == nil {
+		t.Fatal("connected to a closed port")
+	}
+	if !strings.HasPrefix(err.Error(), "can't ping mongodb after 3 attempts: ") {
+		t.Errorf("wrong error: %v", err)
+	}
+	// ошибка драйвера не теряется
+	var selectionErr topology.ServerSelectionError
+	if !errors.As(err, &selectionErr) {
+		t.Errorf("driver error is lost: %v", err)
+	}
+	// паузы 10ms и 20ms между тремя попытками
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("no backoff between attempts: took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o.ConnectBackoff = time.Minute
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = NewClient(ctx, o, logging.GetLogger())
+	if !errors.Is(err, context.Canceled) || !strings.HasPrefix(err.Error(), "can't ping mongodb after 1 attempts: ") {
+		t.Errorf("wrong error after cancel: %v", err)
+	}
+}